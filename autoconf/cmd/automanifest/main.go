@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jokgame/tools/autoconf/manifest"
+	"github.com/jokgame/tools/autoconf/verify"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+  automanifest list <manifest>              列出历史清单
+  automanifest [-dir <dir>] restore <manifest> <n>  将第 n 个历史清单恢复为当前清单
+  automanifest genkey <private-key> <public-key>   生成用于清单签名的 ed25519 密钥
+  automanifest -dir <dir> verify <manifest> <public-key>  检查清单的签名和清单引用的文件
+
+options:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	dir := flag.String("dir", "", "导出目录, 非空时恢复前检查历史清单引用的文件是否存在, 检查签名时默认为清单所在目录")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch args[0] {
+	case "list":
+		err = list(args[1])
+	case "restore":
+		if len(args) != 3 {
+			usage()
+			os.Exit(2)
+		}
+		var n int
+		n, err = strconv.Atoi(args[2])
+		if err != nil {
+			err = fmt.Errorf("invalid history number %q", args[2])
+			break
+		}
+		err = manifest.Restore(args[1], n, *dir)
+		if err == nil {
+			fmt.Printf("history %d of %s restored\n", n, args[1])
+		}
+	case "genkey":
+		if len(args) != 3 {
+			usage()
+			os.Exit(2)
+		}
+		err = genkey(args[1], args[2])
+	case "verify":
+		if len(args) != 3 {
+			usage()
+			os.Exit(2)
+		}
+		err = verifyManifest(args[1], args[2], *dir)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func list(filename string) error {
+	versions, err := manifest.History(filename)
+	if err != nil {
+		return err
+	}
+	if current, err := manifest.Load(filename); err == nil {
+		printVersion("current", current)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		printVersion(strconv.Itoa(versions[i].N), versions[i].Manifest)
+	}
+	return nil
+}
+
+func printVersion(name string, m *manifest.Manifest) {
+	buildID := m.BuildID
+	if buildID == "" {
+		buildID = "-"
+	}
+	fmt.Printf("%s\t%s\t%s\t%d files\n", name, formatTimestamp(m.Timestamp), buildID, len(m.Files))
+}
+
+func genkey(privateKeyFile, publicKeyFile string) error {
+	privateKey, publicKey, err := manifest.GenerateKey()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(privateKeyFile, privateKey, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(publicKeyFile, publicKey, 0644)
+}
+
+func verifyManifest(filename, publicKeyFile, dir string) error {
+	data, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return err
+	}
+	key, err := manifest.ParsePublicKey(data)
+	if err != nil {
+		return fmt.Errorf("load public key %s error: %w", publicKeyFile, err)
+	}
+	if dir == "" {
+		dir = filepath.Dir(filename)
+	}
+	m, err := verify.Dir(key, filename, dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s verified, %d files\n", filename, len(m.Files))
+	return nil
+}
+
+func formatTimestamp(timestamp int64) string {
+	if timestamp == 0 {
+		return "-"
+	}
+	return time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,272 @@
+// Package codec 编码和解码导出的配置文件.
+//
+// 导出目标可以配置压缩算法(gzip 或 zstd)和 AES-GCM 加密, 编码方式记录在清单的 codec 字段中,
+// 如 "gzip", "aes-gcm", "zstd+aes-gcm", 按 "+" 分隔的顺序依次编码, 解码时按相反的顺序.
+//
+// 加密后的文件内容为 nonce(12 字节) + 密文 + tag(16 字节). 密钥经 HKDF-SHA256 派生出两个子密钥,
+// 分别用于计算 nonce 的 HMAC-SHA256 和 AES-GCM 加密. nonce 由明文决定, 加密是确定性的:
+// 相同的明文总是加密为相同的密文, 导出结果和清单不会因为重新导出而变化,
+// 但也意味着可以从密文判断两个文件的内容是否相同.
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/jokgame/tools/autoconf/manifest"
+)
+
+// 支持的编码
+const (
+	Gzip   = "gzip"
+	Zstd   = "zstd"
+	AESGCM = "aes-gcm"
+)
+
+// ErrKeyRequired 解码加密的文件时没有提供密钥
+var ErrKeyRequired = errors.New("codec: key required")
+
+// Join 返回依次使用压缩算法 compress 和加密(encrypt 为 true 时)的编码, 都不使用时返回空字符串
+func Join(compress string, encrypt bool) string {
+	var steps []string
+	if compress != "" {
+		steps = append(steps, compress)
+	}
+	if encrypt {
+		steps = append(steps, AESGCM)
+	}
+	return strings.Join(steps, "+")
+}
+
+// Extension 返回编码后的文件的扩展名后缀, 如 gzip 为 ".gz", zstd+aes-gcm 为 ".zst.enc"
+func Extension(codec string) string {
+	var ext string
+	for _, step := range split(codec) {
+		switch step {
+		case Gzip:
+			ext += ".gz"
+		case Zstd:
+			ext += ".zst"
+		case AESGCM:
+			ext += ".enc"
+		}
+	}
+	return ext
+}
+
+// Validate 检查编码是否有效
+func Validate(codec string) error {
+	steps := split(codec)
+	for i, step := range steps {
+		switch step {
+		case Gzip, Zstd:
+			if i != 0 {
+				return fmt.Errorf("codec: %s must be the first step of %q", step, codec)
+			}
+		case AESGCM:
+			if i != len(steps)-1 {
+				return fmt.Errorf("codec: %s must be the last step of %q", step, codec)
+			}
+		default:
+			return fmt.Errorf("codec: unknown codec %q", step)
+		}
+	}
+	return nil
+}
+
+func split(codec string) []string {
+	if codec == "" {
+		return nil
+	}
+	return strings.Split(codec, "+")
+}
+
+// NewWriter 返回压缩后写入 w 的 writer, compress 为空时直接返回 w. 写完后必须调用 Close
+func NewWriter(w io.Writer, compress string) (io.WriteCloser, error) {
+	switch compress {
+	case "":
+		return nopCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case Zstd:
+		// 单线程编码保证输出总是相同, 多个协议已经并发导出
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("codec: unknown compression %q", compress)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// 派生子密钥的 HKDF info
+const (
+	nonceKeyInfo = "autoconf nonce"
+	aesKeyInfo   = "autoconf aes-gcm"
+)
+
+// Encrypt 使用 AES-GCM 加密 plaintext, 相同的 key 和 plaintext 总是得到相同的结果
+func Encrypt(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, hkdf(key, nonceKeyInfo, sha256.Size))
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt 解密 Encrypt 加密的数据
+func Decrypt(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("codec: ciphertext too short")
+	}
+	nonce := data[:aead.NonceSize()]
+	return aead.Open(nil, nonce, data[aead.NonceSize():], nil)
+}
+
+// newAEAD 返回使用 key 派生的 AES 子密钥的 AES-GCM, 子密钥与 key 的长度相同
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("codec: invalid key size %d", len(key))
+	}
+	block, err := aes.NewCipher(hkdf(key, aesKeyInfo, len(key)))
+	if err != nil {
+		return nil, fmt.Errorf("codec: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdf 使用 HKDF-SHA256 (RFC 5869, salt 为空) 从 key 派生长度为 n 的子密钥, n 不超过 255*32
+func hkdf(key []byte, info string, n int) []byte {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(key)
+	prk := extract.Sum(nil)
+
+	var (
+		out  = make([]byte, 0, n)
+		prev []byte
+	)
+	expand := hmac.New(sha256.New, prk)
+	for counter := byte(1); len(out) < n; counter++ {
+		expand.Reset()
+		expand.Write(prev)
+		expand.Write([]byte(info))
+		expand.Write([]byte{counter})
+		prev = expand.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:n]
+}
+
+// Decode 解码使用 codec 编码的数据, 加密的数据需要提供密钥 key
+func Decode(codec string, data, key []byte) ([]byte, error) {
+	if err := Validate(codec); err != nil {
+		return nil, err
+	}
+	steps := split(codec)
+	for i := len(steps) - 1; i >= 0; i-- {
+		var err error
+		switch steps[i] {
+		case AESGCM:
+			if key == nil {
+				return nil, ErrKeyRequired
+			}
+			data, err = Decrypt(key, data)
+		case Gzip:
+			var r *gzip.Reader
+			if r, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+				data, err = io.ReadAll(r)
+			}
+		case Zstd:
+			var d *zstd.Decoder
+			if d, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1)); err == nil {
+				data, err = d.DecodeAll(data, nil)
+				d.Close()
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("codec: decode %s error: %w", steps[i], err)
+		}
+	}
+	return data, nil
+}
+
+// LoadKey 加载密钥文件
+func LoadKey(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("load key %s error: %w", filename, err)
+	}
+	return key, nil
+}
+
+// ParseKey 解析 hex 或 base64 编码的 16, 24 或 32 字节的 AES 密钥
+func ParseKey(data []byte) ([]byte, error) {
+	s := string(bytes.TrimSpace(data))
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("codec: key must be hex or base64 encoded")
+		}
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("codec: invalid key size %d", len(key))
+}
+
+// DecodeFile 检查清单中的文件 info 编码后的内容 data 的 checksum, 解码后再检查 json 内容的 checksum
+func DecodeFile(info *manifest.FileInfo, data, key []byte) ([]byte, error) {
+	if err := checkFile(info.Filename, data, info.Checksum, info.Size); err != nil {
+		return nil, err
+	}
+	if info.Codec == "" {
+		return data, nil
+	}
+	plain, err := Decode(info.Codec, data, key)
+	if err != nil {
+		return nil, fmt.Errorf("decode file %s error: %w", info.Filename, err)
+	}
+	if err := checkFile(info.Filename+" (decoded)", plain, info.PlainChecksum, info.PlainSize); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+func checkFile(name string, data []byte, checksum string, size int64) error {
+	if size > 0 && int64(len(data)) != size {
+		return fmt.Errorf("file %s size mismatch: expected %d, got %d", name, size, len(data))
+	}
+	if fmt.Sprintf("%02x", sha256.Sum256(data)) != checksum {
+		return fmt.Errorf("file %s checksum mismatch", name)
+	}
+	return nil
+}
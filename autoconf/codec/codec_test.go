@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func encode(t *testing.T, compress string, key, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, compress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if key == nil {
+		return buf.Bytes()
+	}
+	data, err := Encrypt(key, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEncodeDecode(t *testing.T) {
+	plain := bytes.Repeat([]byte(`{"rows":[{"id":1001,"name":"剑"}]}`), 100)
+	for _, size := range []int{16, 24, 32} {
+		key := bytes.Repeat([]byte{byte(size)}, size)
+		for _, compress := range []string{"", Gzip, Zstd} {
+			for _, encrypt := range []bool{false, true} {
+				codec := Join(compress, encrypt)
+				if err := Validate(codec); err != nil {
+					t.Fatalf("codec %q: %v", codec, err)
+				}
+				var k []byte
+				if encrypt {
+					k = key
+				}
+				data := encode(t, compress, k, plain)
+				got, err := Decode(codec, data, k)
+				if err != nil {
+					t.Fatalf("codec %q key size %d: %v", codec, size, err)
+				}
+				if !bytes.Equal(got, plain) {
+					t.Errorf("codec %q key size %d: decoded content mismatch", codec, size)
+				}
+				if encrypt {
+					if _, err := Decode(codec, data, nil); !errors.Is(err, ErrKeyRequired) {
+						t.Errorf("codec %q: got error %v without key, want %v", codec, err, ErrKeyRequired)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncryptDeterministic(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	a, err := Encrypt(key, []byte("plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Encrypt(key, []byte("plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("same key and plaintext got different ciphertexts")
+	}
+	c, err := Encrypt(key, []byte("plain2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a[:12], c[:12]) {
+		t.Error("different plaintexts got the same nonce")
+	}
+
+	a[len(a)-1] ^= 1
+	if _, err := Decrypt(key, a); err == nil {
+		t.Error("tampered ciphertext: got no error")
+	}
+	other := bytes.Repeat([]byte{2}, 32)
+	if _, err := Decrypt(other, b); err == nil {
+		t.Error("wrong key: got no error")
+	}
+}
+
+func TestHKDF(t *testing.T) {
+	// RFC 5869 A.3: salt 和 info 都为空
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	want := "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8"
+	if got := hex.EncodeToString(hkdf(ikm, "", 42)); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	key := bytes.Repeat([]byte{1}, 32)
+	if bytes.Equal(hkdf(key, nonceKeyInfo, 32), hkdf(key, aesKeyInfo, 32)) {
+		t.Error("nonce key and aes key are the same")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 16)
+	for _, s := range []string{hex.EncodeToString(key) + "\n", "q6urq6urq6urq6urq6urqw=="} {
+		got, err := ParseKey([]byte(s))
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if !bytes.Equal(got, key) {
+			t.Errorf("%q: got %x, want %x", s, got, key)
+		}
+	}
+	if _, err := ParseKey([]byte("abcd")); err == nil {
+		t.Error("short key: got no error")
+	}
+}
@@ -0,0 +1,286 @@
+// Package manifest 读写导出目标的清单文件及其历史版本.
+//
+// 导出目标配置了 manifest 时, 每个协议导出为 <Bean>.<sha256>.json,
+// 清单记录当前使用的文件. 清单变化时旧的清单保存为历史清单 <manifest>.<n>.json,
+// n 从 1 开始递增, 可以用 Restore 将历史清单恢复为当前清单.
+//
+// 清单格式只增加字段, 只读取 files 中 name, checksum, filename 的旧读取方仍然兼容.
+// 没有 version 字段的清单为版本 1.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FormatVersion 当前的清单格式版本
+const FormatVersion = 4
+
+// FileInfo 清单中的一个文件
+type FileInfo struct {
+	Name string `json:"name"`
+	// 文件内容的 sha256, 编码后的文件为编码后的内容的 sha256
+	Checksum string `json:"checksum"`
+	Filename string `json:"filename"`
+	// 文件大小(字节), 版本 2 增加
+	Size int64 `json:"size,omitempty"`
+	// 协议定义的哈希, 用于检查客户端与服务器的协议是否一致, 版本 2 增加
+	SchemaHash string `json:"schema_hash,omitempty"`
+	// 文件的编码, 见 codec 包, 为空表示未编码的 json. 版本 3 增加
+	Codec string `json:"codec,omitempty"`
+	// 编码的文件解码后的 json 内容的 sha256 和大小, 版本 3 增加
+	PlainChecksum string `json:"plain_checksum,omitempty"`
+	PlainSize     int64  `json:"plain_size,omitempty"`
+}
+
+// Manifest 导出目标的清单
+type Manifest struct {
+	// 清单格式版本
+	Version int `json:"version,omitempty"`
+	// 构建编号, 由配置 build_id 指定
+	BuildID string `json:"build_id,omitempty"`
+	// 生成时间, unix 时间戳(秒)
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// 所有文件的哈希, 见 RootHash
+	RootHash string      `json:"root_hash,omitempty"`
+	Files    []*FileInfo `json:"files"`
+	// 包含所有表格的打包文件, 见 pack 包, 版本 4 增加
+	Pack *PackInfo `json:"pack,omitempty"`
+}
+
+// PackInfo 导出目标的打包文件
+type PackInfo struct {
+	Filename string `json:"filename"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// Parse 解析清单内容, 不支持比 FormatVersion 更新的格式
+func Parse(data []byte) (*Manifest, error) {
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Version > FormatVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	return m, nil
+}
+
+// Load 加载清单文件
+func Load(filename string) (*Manifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest %s error: %w", filename, err)
+	}
+	return m, nil
+}
+
+// Marshal 返回清单的 json 内容
+func (m *Manifest) Marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "    ")
+}
+
+// RootHash 计算所有文件的哈希: 按协议名排序后, 每个文件一行 "<name> <checksum>\n" 的 sha256,
+// 用于快速判断清单中是否有文件变化
+func RootHash(files []*FileInfo) string {
+	sorted := make([]*FileInfo, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	h := sha256.New()
+	for _, file := range sorted {
+		fmt.Fprintf(h, "%s %s\n", file.Name, file.Checksum)
+	}
+	return fmt.Sprintf("%02x", h.Sum(nil))
+}
+
+// SameFiles 判断两个清单的文件列表和打包文件是否相同
+func (m *Manifest) SameFiles(other *Manifest) bool {
+	if len(m.Files) != len(other.Files) {
+		return false
+	}
+	if (m.Pack == nil) != (other.Pack == nil) || (m.Pack != nil && *m.Pack != *other.Pack) {
+		return false
+	}
+	for i := range m.Files {
+		if *m.Files[i] != *other.Files[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checksumFilename 匹配带有 checksum 的导出文件名 <Bean>.<sha256>.json (msgpack 格式为 .msgpack, protobuf 格式为 .pb, lua 格式为 .lua),
+// 编码的文件带有编码的扩展名, 如 .json.gz, 以及打包文件 <export>.<sha256>.pack.
+// 名称部分与导出目标名的限制相同, 不能包含逗号, 空白和路径分隔符
+var checksumFilename = regexp.MustCompile(`^[^, \t/\\]+\.[0-9a-f]{64}\.((json|msgpack|pb|lua)(\.(gz|zst|enc))*|pack)$`)
+
+// IsChecksumFilename 判断文件名是否为带有 checksum 的导出文件名或打包文件名
+func IsChecksumFilename(name string) bool {
+	return checksumFilename.MatchString(name)
+}
+
+// HistoryFilename 返回清单 filename 的第 n 个历史清单的文件名,
+// 如 client.manifest.json 的第 3 个历史清单为 client.manifest.3.json
+func HistoryFilename(filename string, n int) string {
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "." + strconv.Itoa(n) + ext
+}
+
+// Version 一个历史清单
+type Version struct {
+	// 序号, 越大越新
+	N        int
+	Filename string
+	Manifest *Manifest
+}
+
+// History 返回清单 filename 的所有历史清单, 按序号从小到大排列
+func History(filename string) ([]Version, error) {
+	ext := filepath.Ext(filename)
+	prefix := filepath.Base(strings.TrimSuffix(filename, ext)) + "."
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var versions []Version
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil || n <= 0 {
+			continue
+		}
+		path := filepath.Join(filepath.Dir(filename), name)
+		m, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, Version{N: n, Filename: path, Manifest: m})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].N < versions[j].N
+	})
+	return versions, nil
+}
+
+// NextHistory 返回下一个历史清单的序号
+func NextHistory(versions []Version) int {
+	if len(versions) == 0 {
+		return 1
+	}
+	return versions[len(versions)-1].N + 1
+}
+
+// Restore 将清单 filename 的第 n 个历史清单恢复为当前清单, 当前清单保存为新的历史清单, 签名文件随清单一起恢复.
+// dir 非空时先检查历史清单引用的文件和打包文件都存在于导出目录 dir 中
+func Restore(filename string, n int, dir string) error {
+	versions, err := History(filename)
+	if err != nil {
+		return err
+	}
+	var target *Version
+	for i := range versions {
+		if versions[i].N == n {
+			target = &versions[i]
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("manifest %s has no history %d", filename, n)
+	}
+	if dir != "" {
+		for _, file := range target.Manifest.Files {
+			if _, err := os.Stat(filepath.Join(dir, file.Filename)); err != nil {
+				return fmt.Errorf("file %s of history %d not found: %w", file.Filename, n, err)
+			}
+		}
+		if pack := target.Manifest.Pack; pack != nil {
+			if _, err := os.Stat(filepath.Join(dir, pack.Filename)); err != nil {
+				return fmt.Errorf("pack file %s of history %d not found: %w", pack.Filename, n, err)
+			}
+		}
+	}
+	data, err := os.ReadFile(target.Filename)
+	if err != nil {
+		return err
+	}
+	sig, err := readOptionalFile(SignatureFilename(target.Filename))
+	if err != nil {
+		return err
+	}
+	current, err := os.ReadFile(filename)
+	if err == nil {
+		next := HistoryFilename(filename, NextHistory(versions))
+		currentSig, err := readOptionalFile(SignatureFilename(filename))
+		if err != nil {
+			return err
+		}
+		if currentSig != nil {
+			if err := writeFile(SignatureFilename(next), currentSig); err != nil {
+				return err
+			}
+		}
+		if err := writeFile(next, current); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	// 历史清单没有签名时删除当前清单的签名, 避免签名与清单不匹配
+	if sig != nil {
+		err = writeFile(SignatureFilename(filename), sig)
+	} else {
+		err = os.Remove(SignatureFilename(filename))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return writeFile(filename, data)
+}
+
+// readOptionalFile 读取文件内容, 文件不存在时返回 nil
+func readOptionalFile(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// writeFile 先写入临时文件再重命名, 避免读取到不完整的文件
+func writeFile(filename string, data []byte) error {
+	tmpfile := filename + ".tmp"
+	if err := os.WriteFile(tmpfile, data, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpfile, filename); err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+	return nil
+}
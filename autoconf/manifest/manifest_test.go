@@ -0,0 +1,150 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsChecksumFilename(t *testing.T) {
+	sum := strings.Repeat("0a", 32)
+	for _, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"Item." + sum + ".json", true},
+		{"my-item_2." + sum + ".json", true},
+		{"道具." + sum + ".msgpack", true},
+		{"Item." + sum + ".pb", true},
+		{"Item." + sum + ".lua", true},
+		{"Item." + sum + ".json.gz", true},
+		{"Item." + sum + ".json.zst.enc", true},
+		{"client." + sum + ".pack", true},
+		{"Item.json", false},
+		{"Item." + sum[1:] + ".json", false},
+		{"Item." + strings.ToUpper(sum) + ".json", false},
+		{"Item." + sum + ".yaml", false},
+		{"Item." + sum + ".pack.gz", false},
+		{"a,b." + sum + ".json", false},
+		{"a b." + sum + ".json", false},
+		{"." + sum + ".json", false},
+		{"client.manifest.json", false},
+	} {
+		if got := IsChecksumFilename(tt.name); got != tt.want {
+			t.Errorf("IsChecksumFilename(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "client.manifest.json")
+	history := &Manifest{
+		Version: FormatVersion,
+		Files:   []*FileInfo{{Name: "Item", Checksum: "a", Filename: "Item.a.json"}},
+		Pack:    &PackInfo{Checksum: "p", Filename: "client.p.pack"},
+	}
+	current := &Manifest{Version: FormatVersion, Files: []*FileInfo{{Name: "Item", Checksum: "b", Filename: "Item.b.json"}}}
+	for name, m := range map[string]*Manifest{HistoryFilename(filename, 1): history, filename: current} {
+		data, err := m.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(SignatureFilename(filename), []byte("sig\n"), 0644)
+
+	for _, tt := range []struct {
+		name  string
+		files []string
+		err   string
+	}{
+		{"missing file", nil, "file Item.a.json of history 1 not found"},
+		{"missing pack", []string{"Item.a.json"}, "pack file client.p.pack of history 1 not found"},
+		{"restored", []string{"client.p.pack"}, ""},
+	} {
+		for _, name := range tt.files {
+			os.WriteFile(filepath.Join(dir, name), nil, 0644)
+		}
+		err := Restore(filename, 1, dir)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Fatalf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	restored, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.SameFiles(history) {
+		t.Errorf("restored manifest has files %v, want %v", restored.Files, history.Files)
+	}
+	saved, err := Load(HistoryFilename(filename, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.SameFiles(current) {
+		t.Errorf("saved manifest has files %v, want %v", saved.Files, current.Files)
+	}
+	// 历史清单没有签名, 当前清单的签名随当前清单保存为历史清单的签名
+	if _, err := os.Stat(SignatureFilename(filename)); !os.IsNotExist(err) {
+		t.Errorf("signature of restored manifest: got error %v, want not exist", err)
+	}
+	if sig, err := os.ReadFile(SignatureFilename(HistoryFilename(filename, 2))); err != nil || string(sig) != "sig\n" {
+		t.Errorf("signature of saved manifest: got %q, %v", sig, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		data    string
+		version int
+		err     string
+	}{
+		{"version 1", `{"files":[{"name":"Item","checksum":"a","filename":"Item.a.json"}]}`, 1, ""},
+		{"current version", `{"version":4,"root_hash":"r","files":[],"pack":{"filename":"p.pack","checksum":"p","size":1}}`, FormatVersion, ""},
+		{"unknown fields", `{"version":2,"files":[],"extra":1}`, 2, ""},
+		{"newer version", `{"version":5,"files":[]}`, 0, "unsupported manifest version 5"},
+		{"invalid", `{"files":`, 0, "unexpected end of JSON input"},
+	} {
+		m, err := Parse([]byte(tt.data))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+		} else if err != nil || m.Version != tt.version {
+			t.Errorf("%s: got %v, %v, want version %d", tt.name, m, err, tt.version)
+		}
+	}
+}
+
+func TestRootHash(t *testing.T) {
+	item := &FileInfo{Name: "Item", Checksum: "a", Filename: "Item.a.json", Size: 1}
+	stone := &FileInfo{Name: "Stone", Checksum: "s", Filename: "Stone.s.json"}
+	// sha256("Item a\nStone s\n"), 客户端按相同的规则计算, 不能改变
+	const want = "00bf9a9dbe9c881f3dac74c5c2d26717a1ee1b49495e8da9528b6a0c27c19ea8"
+	for _, tt := range []struct {
+		name  string
+		files []*FileInfo
+		want  string
+	}{
+		{"sorted", []*FileInfo{item, stone}, want},
+		{"unsorted", []*FileInfo{stone, item}, want},
+		{"other fields ignored", []*FileInfo{{Name: "Item", Checksum: "a"}, stone}, want},
+		{"checksum changed", []*FileInfo{{Name: "Item", Checksum: "b"}, stone}, ""},
+		{"empty", nil, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	} {
+		got := RootHash(tt.files)
+		if tt.want == "" {
+			if got == want {
+				t.Errorf("%s: root hash not changed", tt.name)
+			}
+		} else if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
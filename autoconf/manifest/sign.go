@@ -0,0 +1,122 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// 清单的签名是对清单文件内容的 ed25519 签名, base64 编码后保存在 <manifest>.sig 中.
+// 密钥文件可以是 PEM 格式(openssl genpkey -algorithm ed25519 生成的 PKCS#8 私钥和 PKIX 公钥),
+// 也可以是 base64 编码的密钥(私钥为 32 字节的 seed 或 64 字节的私钥, 公钥为 32 字节)
+
+// ErrInvalidSignature 签名与清单内容不匹配
+var ErrInvalidSignature = errors.New("invalid manifest signature")
+
+// SignatureFilename 返回清单 filename 的签名文件名
+func SignatureFilename(filename string) string {
+	return filename + ".sig"
+}
+
+// Sign 返回清单内容 data 的签名文件内容
+func Sign(key ed25519.PrivateKey, data []byte) []byte {
+	sig := ed25519.Sign(key, data)
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+// VerifySignature 检查签名文件内容 sig 是否为清单内容 data 的有效签名
+func VerifySignature(key ed25519.PublicKey, data, sig []byte) error {
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil || len(decoded) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(key, data, decoded) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// GenerateKey 生成一对 PEM 格式的密钥
+func GenerateKey() (privateKey, publicKey []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	der, err = x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return privateKey, publicKey, nil
+}
+
+// LoadPrivateKey 加载私钥文件
+func LoadPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("load private key %s error: %w", filename, err)
+	}
+	return key, nil
+}
+
+// ParsePrivateKey 解析 PEM 或 base64 格式的私钥
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := key.(ed25519.PrivateKey); ok {
+			return key, nil
+		}
+		return nil, errors.New("not an ed25519 private key")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, errors.New("invalid private key")
+	}
+	switch len(decoded) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(decoded), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(decoded), nil
+	}
+	return nil, fmt.Errorf("invalid private key size %d", len(decoded))
+}
+
+// ParsePublicKey 解析 PEM 或 base64 格式的公钥
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := key.(ed25519.PublicKey); ok {
+			return key, nil
+		}
+		return nil, errors.New("not an ed25519 public key")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, errors.New("invalid public key")
+	}
+	if len(decoded) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key size %d", len(decoded))
+	}
+	return ed25519.PublicKey(decoded), nil
+}
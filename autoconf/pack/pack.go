@@ -0,0 +1,276 @@
+// Package pack 读写导出目标的打包文件.
+//
+// 打包文件将一个导出目标的所有表格保存在一个文件中, 文件头是所有表格的索引, 之后依次是各表格的内容,
+// 表格的内容与单独导出的文件相同(包括压缩和加密). 所有整数都是小端序:
+//
+//	magic     [4]byte  "ACPK"
+//	version   uint32   当前为 1
+//	count     uint32   表格数量
+//	entries   [count]  索引, 按表格名排序
+//	  nameLen  uint16
+//	  name     [nameLen]byte
+//	  codecLen uint16
+//	  codec    [codecLen]byte  编码, 见 codec 包
+//	  offset   uint64   表格内容在文件中的偏移
+//	  length   uint64   表格内容的长度
+//	  checksum [32]byte 表格内容的 sha256
+//	data      各表格的内容
+package pack
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	magic   = "ACPK"
+	version = 1
+)
+
+// ErrNotFound 打包文件中没有指定的表格
+var ErrNotFound = errors.New("pack: table not found")
+
+// Entry 打包文件中的一个表格
+type Entry struct {
+	Name  string
+	Codec string
+	// 表格内容在打包文件中的偏移和长度
+	Offset int64
+	Length int64
+	// 表格内容的 sha256, hex 编码
+	Checksum string
+}
+
+// Source 写入打包文件的一个表格
+type Source struct {
+	Name     string
+	Codec    string
+	Filename string
+}
+
+// Write 将 sources 中的文件打包写入 w
+func Write(w io.Writer, sources []Source) error {
+	sources = append([]Source(nil), sources...)
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Name < sources[j].Name
+	})
+	// 先计算各文件的长度和 checksum 生成索引, 再依次写入文件内容
+	entries := make([]Entry, len(sources))
+	headerSize := int64(len(magic) + 4 + 4)
+	for i, src := range sources {
+		if i > 0 && src.Name == sources[i-1].Name {
+			return fmt.Errorf("pack: table %s duplicated", src.Name)
+		}
+		if len(src.Name) > 0xffff || len(src.Codec) > 0xffff {
+			return fmt.Errorf("pack: table name or codec of %s too long", src.Name)
+		}
+		length, checksum, err := fileChecksum(src.Filename)
+		if err != nil {
+			return err
+		}
+		entries[i] = Entry{
+			Name:     src.Name,
+			Codec:    src.Codec,
+			Length:   length,
+			Checksum: checksum,
+		}
+		headerSize += int64(2+len(src.Name)+2+len(src.Codec)) + 8 + 8 + sha256.Size
+	}
+	offset := headerSize
+	for i := range entries {
+		entries[i].Offset = offset
+		offset += entries[i].Length
+	}
+
+	var header bytes.Buffer
+	header.WriteString(magic)
+	binary.Write(&header, binary.LittleEndian, uint32(version))
+	binary.Write(&header, binary.LittleEndian, uint32(len(entries)))
+	for _, e := range entries {
+		binary.Write(&header, binary.LittleEndian, uint16(len(e.Name)))
+		header.WriteString(e.Name)
+		binary.Write(&header, binary.LittleEndian, uint16(len(e.Codec)))
+		header.WriteString(e.Codec)
+		binary.Write(&header, binary.LittleEndian, uint64(e.Offset))
+		binary.Write(&header, binary.LittleEndian, uint64(e.Length))
+		checksum, _ := hex.DecodeString(e.Checksum)
+		header.Write(checksum)
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	for i, src := range sources {
+		if err := copyFile(w, src.Filename, entries[i].Length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fileChecksum(filename string) (int64, string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(w io.Writer, filename string, length int64) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("pack: file %s changed while packing", filename)
+	}
+	return nil
+}
+
+// Reader 读取打包文件, 打开时只读取索引, 表格的内容在需要时读取
+type Reader struct {
+	r       io.ReaderAt
+	entries []Entry
+	index   map[string]int
+}
+
+// NewReader 读取打包文件 r 的索引, size 为打包文件的大小
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	var head [len(magic) + 8]byte
+	if _, err := io.ReadFull(sr, head[:]); err != nil {
+		return nil, fmt.Errorf("pack: read header error: %w", err)
+	}
+	if string(head[:len(magic)]) != magic {
+		return nil, errors.New("pack: not a pack file")
+	}
+	if v := binary.LittleEndian.Uint32(head[len(magic):]); v != version {
+		return nil, fmt.Errorf("pack: unsupported version %d", v)
+	}
+	count := binary.LittleEndian.Uint32(head[len(magic)+4:])
+	p := &Reader{
+		r:     r,
+		index: make(map[string]int),
+	}
+	for i := uint32(0); i < count; i++ {
+		var e Entry
+		var err error
+		if e.Name, err = readString(sr); err != nil {
+			return nil, err
+		}
+		if e.Codec, err = readString(sr); err != nil {
+			return nil, err
+		}
+		var fixed [8 + 8 + sha256.Size]byte
+		if _, err := io.ReadFull(sr, fixed[:]); err != nil {
+			return nil, fmt.Errorf("pack: read index error: %w", err)
+		}
+		e.Offset = int64(binary.LittleEndian.Uint64(fixed[0:]))
+		e.Length = int64(binary.LittleEndian.Uint64(fixed[8:]))
+		e.Checksum = hex.EncodeToString(fixed[16:])
+		if e.Offset < 0 || e.Length < 0 || e.Offset > size || e.Length > size-e.Offset {
+			return nil, fmt.Errorf("pack: table %s out of range", e.Name)
+		}
+		p.index[e.Name] = len(p.entries)
+		p.entries = append(p.entries, e)
+	}
+	return p, nil
+}
+
+func readString(r io.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", fmt.Errorf("pack: read index error: %w", err)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", fmt.Errorf("pack: read index error: %w", err)
+	}
+	return string(buf), nil
+}
+
+// Entries 返回所有表格的索引
+func (p *Reader) Entries() []Entry {
+	return p.entries
+}
+
+// Lookup 返回表格 name 的索引
+func (p *Reader) Lookup(name string) (Entry, bool) {
+	i, ok := p.index[name]
+	if !ok {
+		return Entry{}, false
+	}
+	return p.entries[i], true
+}
+
+// Open 返回读取表格 name 的内容的 reader, 不检查 checksum
+func (p *Reader) Open(name string) (*io.SectionReader, error) {
+	e, ok := p.Lookup(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NewSectionReader(p.r, e.Offset, e.Length), nil
+}
+
+// ReadTable 读取表格 name 的内容并检查 checksum, 内容按表格的编码保存, 可以用 codec.Decode 解码
+func (p *Reader) ReadTable(name string) ([]byte, error) {
+	e, ok := p.Lookup(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	data := make([]byte, e.Length)
+	if n, err := p.r.ReadAt(data, e.Offset); n < len(data) {
+		return nil, fmt.Errorf("pack: read table %s error: %w", name, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != e.Checksum {
+		return nil, fmt.Errorf("pack: table %s checksum mismatch", name)
+	}
+	return data, nil
+}
+
+// File 打开的打包文件
+type File struct {
+	*Reader
+	f *os.File
+}
+
+// Open 打开打包文件 filename 并读取索引
+func Open(filename string) (*File, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r, err := NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open pack %s error: %w", filename, err)
+	}
+	return &File{Reader: r, f: f}, nil
+}
+
+// Close 关闭打包文件
+func (f *File) Close() error {
+	return f.f.Close()
+}
@@ -0,0 +1,103 @@
+package pack
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	tables := map[string][]byte{
+		"Stone": []byte(`{"rows":[{"id":1}]}`),
+		"Item":  bytes.Repeat([]byte("item"), 1000),
+		"Empty": nil,
+	}
+	codecs := map[string]string{"Item": "gzip"}
+	var sources []Source
+	for _, name := range []string{"Stone", "Item", "Empty"} {
+		filename := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(filename, tables[name], 0644); err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, Source{Name: name, Codec: codecs[name], Filename: filename})
+	}
+
+	filename := filepath.Join(dir, "client.pack")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(f, sources); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	entries := p.Entries()
+	if len(entries) != len(tables) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(tables))
+	}
+	for i, want := range []string{"Empty", "Item", "Stone"} {
+		if entries[i].Name != want {
+			t.Errorf("entry %d: got %s, want %s", i, entries[i].Name, want)
+		}
+	}
+	for name, content := range tables {
+		e, ok := p.Lookup(name)
+		if !ok {
+			t.Fatalf("table %s not found", name)
+		}
+		if e.Codec != codecs[name] {
+			t.Errorf("table %s: got codec %q, want %q", name, e.Codec, codecs[name])
+		}
+		sum := sha256.Sum256(content)
+		if e.Checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("table %s: checksum mismatch", name)
+		}
+		data, err := p.ReadTable(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("table %s: got %q, want %q", name, data, content)
+		}
+	}
+	if _, err := p.ReadTable("Missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing table: got error %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestReadCorrupted(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "Stone.json")
+	if err := os.WriteFile(filename, []byte(`{"row":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, []Source{{Name: "Stone", Filename: filename}}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+	p, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ReadTable("Stone"); err == nil {
+		t.Error("corrupted table: got no error")
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("ACPX")), 4); err == nil {
+		t.Error("invalid magic: got no error")
+	}
+}
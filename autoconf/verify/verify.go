@@ -0,0 +1,95 @@
+// Package verify 在接受导出的配置前检查清单的签名和清单中各文件的 checksum.
+//
+// 导出目标配置了 signing_key 时, 清单 <manifest> 的签名保存在 <manifest>.sig 中,
+// 客户端使用对应的公钥调用 Dir 检查本地的配置目录, 或者在下载时用 Manifest 和 File 逐个检查.
+package verify
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jokgame/tools/autoconf/manifest"
+)
+
+// Manifest 检查签名文件内容 sig 是否为清单内容 data 的有效签名, 然后解析清单并检查 root hash
+func Manifest(key ed25519.PublicKey, data, sig []byte) (*manifest.Manifest, error) {
+	if err := manifest.VerifySignature(key, data, sig); err != nil {
+		return nil, err
+	}
+	m, err := manifest.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if m.RootHash != "" && m.RootHash != manifest.RootHash(m.Files) {
+		return nil, errors.New("root hash mismatch")
+	}
+	return m, nil
+}
+
+// File 检查文件内容 data 是否与清单中的记录一致
+func File(info *manifest.FileInfo, data []byte) error {
+	return Reader(info, bytes.NewReader(data))
+}
+
+// Reader 检查从 r 读取的文件内容是否与清单中的记录一致
+func Reader(info *manifest.FileInfo, r io.Reader) error {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return fmt.Errorf("read file %s error: %w", info.Filename, err)
+	}
+	if info.Size > 0 && n != info.Size {
+		return fmt.Errorf("file %s size mismatch: expected %d, got %d", info.Filename, info.Size, n)
+	}
+	if checksum := fmt.Sprintf("%02x", h.Sum(nil)); checksum != info.Checksum {
+		return fmt.Errorf("file %s checksum mismatch", info.Filename)
+	}
+	return nil
+}
+
+// Dir 加载并检查清单文件 filename 及其签名, 再检查目录 dir 中清单引用的所有文件和打包文件
+func Dir(key ed25519.PublicKey, filename, dir string) (*manifest.Manifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := os.ReadFile(manifest.SignatureFilename(filename))
+	if err != nil {
+		return nil, err
+	}
+	m, err := Manifest(key, data, sig)
+	if err != nil {
+		return nil, fmt.Errorf("verify manifest %s error: %w", filename, err)
+	}
+	for _, info := range m.Files {
+		if err := verifyFile(info, filepath.Join(dir, info.Filename)); err != nil {
+			return nil, err
+		}
+	}
+	if m.Pack != nil {
+		info := &manifest.FileInfo{
+			Filename: m.Pack.Filename,
+			Checksum: m.Pack.Checksum,
+			Size:     m.Pack.Size,
+		}
+		if err := verifyFile(info, filepath.Join(dir, info.Filename)); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func verifyFile(info *manifest.FileInfo, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Reader(info, f)
+}
@@ -0,0 +1,126 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jokgame/tools/autoconf/manifest"
+)
+
+func testManifest(t *testing.T, contents map[string]string) (*manifest.Manifest, []byte) {
+	t.Helper()
+	m := &manifest.Manifest{Version: manifest.FormatVersion}
+	for _, name := range []string{"Item", "Stone"} {
+		content := contents[name]
+		checksum := fmt.Sprintf("%02x", sha256.Sum256([]byte(content)))
+		m.Files = append(m.Files, &manifest.FileInfo{
+			Name:     name,
+			Checksum: checksum,
+			Filename: name + "." + checksum + ".json",
+			Size:     int64(len(content)),
+		})
+	}
+	m.RootHash = manifest.RootHash(m.Files)
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, data
+}
+
+func TestManifest(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, data := testManifest(t, map[string]string{"Item": "a", "Stone": "s"})
+	sig := manifest.Sign(key, data)
+
+	// root hash 与文件列表不一致的清单, 使用有效的签名
+	m.RootHash = strings.Repeat("0", 64)
+	badRoot, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		key  ed25519.PublicKey
+		data []byte
+		sig  []byte
+		err  string
+	}{
+		{"valid", pub, data, sig, ""},
+		{"sig without newline", pub, data, sig[:len(sig)-1], ""},
+		{"tampered manifest", pub, []byte(strings.Replace(string(data), `"Item"`, `"Itam"`, 1)), sig, manifest.ErrInvalidSignature.Error()},
+		{"tampered signature", pub, data, []byte(strings.ToLower(string(sig))), manifest.ErrInvalidSignature.Error()},
+		{"truncated signature", pub, data, sig[:20], manifest.ErrInvalidSignature.Error()},
+		{"empty signature", pub, data, nil, manifest.ErrInvalidSignature.Error()},
+		{"other key", otherPub, data, sig, manifest.ErrInvalidSignature.Error()},
+		{"root hash mismatch", pub, badRoot, manifest.Sign(key, badRoot), "root hash mismatch"},
+	} {
+		got, err := Manifest(tt.key, tt.data, tt.sig)
+		if tt.err == "" {
+			if err != nil || got == nil || len(got.Files) != 2 {
+				t.Errorf("%s: got %v, %v", tt.name, got, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{"Item": "a", "Stone": "s"}
+	m, data := testManifest(t, contents)
+
+	for _, tt := range []struct {
+		name string
+		// 修改导出目录中的文件
+		modify func(dir string)
+		err    string
+	}{
+		{"valid", func(dir string) {}, ""},
+		{"missing signature", func(dir string) {
+			os.Remove(manifest.SignatureFilename(filepath.Join(dir, "client.manifest.json")))
+		}, "no such file"},
+		{"missing file", func(dir string) {
+			os.Remove(filepath.Join(dir, m.Files[1].Filename))
+		}, "no such file"},
+		{"modified file", func(dir string) {
+			os.WriteFile(filepath.Join(dir, m.Files[0].Filename), []byte("b"), 0644)
+		}, "checksum mismatch"},
+		{"truncated file", func(dir string) {
+			os.WriteFile(filepath.Join(dir, m.Files[0].Filename), nil, 0644)
+		}, "size mismatch"},
+		{"signature of other manifest", func(dir string) {
+			os.WriteFile(manifest.SignatureFilename(filepath.Join(dir, "client.manifest.json")), manifest.Sign(key, []byte("{}")), 0644)
+		}, manifest.ErrInvalidSignature.Error()},
+	} {
+		dir := t.TempDir()
+		filename := filepath.Join(dir, "client.manifest.json")
+		os.WriteFile(filename, data, 0644)
+		os.WriteFile(manifest.SignatureFilename(filename), manifest.Sign(key, data), 0644)
+		for _, file := range m.Files {
+			os.WriteFile(filepath.Join(dir, file.Filename), []byte(contents[file.Name]), 0644)
+		}
+		tt.modify(dir)
+		_, err := Dir(pub, filename, dir)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package xlsx
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/midlang/mid/src/mid/build"
+
+	"github.com/jokgame/tools/autoconf/codec"
+)
+
+// 增量导出的缓存文件名
+const defaultCacheFile = ".autoconf-cache.json"
+
+// 缓存格式版本, 导出结果或缓存格式变化时增加, 使旧的缓存失效
+const cacheVersion = 3
+
+// exportCache 增量导出的缓存, 记录每个协议上次导出时的输入哈希和导出结果
+type exportCache struct {
+	Version int                    `json:"version"`
+	Tables  map[string]*cacheEntry `json:"tables"`
+	// 根据所有表格生成的文件(如 sqlite 数据库)的指纹, 见 dbFingerprint
+	Generated map[string]string `json:"generated,omitempty"`
+	// 本次导出生成的文件的指纹, 保存时替换 Generated
+	generated map[string]string
+}
+
+// cacheEntry 一个协议的缓存
+type cacheEntry struct {
+	// 表格文件, 协议定义和导出配置的哈希
+	Hash string `json:"hash"`
+	// 导出的 json 文件
+	Files []*exportedFile `json:"files"`
+	// 根据 codegen 模板生成的文件
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+// loadCache 加载缓存文件, 文件不存在或无法解析时返回空的缓存
+func loadCache(filename string) *exportCache {
+	cache := &exportCache{
+		Version: cacheVersion,
+		Tables:  make(map[string]*cacheEntry),
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("read cache file '%s' error: %v", filename, err)
+		}
+		return cache
+	}
+	var loaded exportCache
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		log.Printf("ignore invalid cache file '%s': %v", filename, err)
+		return cache
+	}
+	if loaded.Version != cacheVersion || loaded.Tables == nil {
+		return cache
+	}
+	return &loaded
+}
+
+func (cache *exportCache) save(st *stage, filename string) error {
+	cache.Generated = cache.generated
+	content, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal cache error: %w", err)
+	}
+	return st.writeFile(filename, content, stageCache)
+}
+
+// lookup 返回哈希相同且导出的文件都没有被修改的缓存
+func (cache *exportCache) lookup(name, hash string) *cacheEntry {
+	if cache == nil {
+		return nil
+	}
+	entry := cache.Tables[name]
+	if entry == nil || entry.Hash != hash {
+		return nil
+	}
+	for _, file := range entry.Files {
+		if checksum, err := fileChecksum(file.Path); err != nil || checksum != file.Checksum {
+			return nil
+		}
+	}
+	for _, output := range entry.Outputs {
+		if _, err := os.Stat(output); err != nil {
+			return nil
+		}
+	}
+	return entry
+}
+
+// generatedUpToDate 判断根据所有表格生成的文件 target 是否不需要重新生成:
+// 指纹 fingerprint 与上次生成时相同且文件 filenames 都存在. 没有缓存或指纹为空时总是重新生成
+func (cache *exportCache) generatedUpToDate(target, fingerprint string, filenames ...string) bool {
+	if cache == nil || fingerprint == "" {
+		return false
+	}
+	if cache.generated == nil {
+		cache.generated = make(map[string]string)
+	}
+	cache.generated[target] = fingerprint
+	if cache.Generated[target] != fingerprint {
+		return false
+	}
+	for _, filename := range filenames {
+		if _, err := os.Stat(filename); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newCacheEntry(hash string, job *exportJob) *cacheEntry {
+	return &cacheEntry{
+		Hash:    hash,
+		Files:   job.exported,
+		Outputs: job.outputs,
+	}
+}
+
+// restore 使用缓存作为导出结果
+func (entry *cacheEntry) restore(job *exportJob) {
+	job.exported = entry.Files
+	job.outputs = entry.Outputs
+}
+
+func fileChecksum(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%02x", h.Sum(nil)), nil
+}
+
+// tableHash 计算协议导出结果依赖的所有输入的哈希: 表格文件的内容,
+// 协议及其继承和引用的结构体, 枚举的定义, 以及相关的导出配置
+func tableHash(pkg *build.Package, cfg *Config, bean *build.Bean, filename string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "version %d\n", cacheVersion)
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	io.WriteString(h, "\n")
+
+	writeSchema(h, pkg, bean, true, make(map[*build.Bean]bool))
+
+	settings, err := exportSettings(cfg, bean)
+	if err != nil {
+		return "", err
+	}
+	h.Write(settings)
+	return fmt.Sprintf("%02x", h.Sum(nil)), nil
+}
+
+// schemaHash 计算协议及其依赖的所有 bean 的定义的哈希, 不包括注释
+func schemaHash(pkg *build.Package, bean *build.Bean) string {
+	h := sha256.New()
+	writeSchema(h, pkg, bean, false, make(map[*build.Bean]bool))
+	return fmt.Sprintf("%02x", h.Sum(nil))
+}
+
+// writeSchema 将 bean 及其依赖的所有 bean 的定义写入 h, comments 为 false 时忽略注释
+func writeSchema(h hash.Hash, pkg *build.Package, bean *build.Bean, comments bool, visited map[*build.Bean]bool) {
+	if visited[bean] {
+		return
+	}
+	visited[bean] = true
+	comment := func(s string) string {
+		if comments {
+			return s
+		}
+		return ""
+	}
+	fmt.Fprintf(h, "bean %s %s %q %q\n", bean.Kind, bean.Name, bean.Tag, comment(bean.Comment))
+	var deps []build.Type
+	for _, t := range bean.Extends {
+		fmt.Fprintf(h, "extends %s\n", exprString(t))
+		deps = append(deps, t)
+	}
+	for _, field := range bean.Fields {
+		fmt.Fprintf(h, "field %q %s %s %q %q\n", field.Names, exprString(field.Type), exprString(field.Default), field.Tag, comment(field.Comment))
+		if field.Type != nil {
+			deps = append(deps, field.Type)
+		}
+	}
+	for _, t := range deps {
+		for {
+			if array, ok := t.(*build.ArrayType); ok {
+				t = array.T
+			} else if vector, ok := t.(*build.VectorType); ok {
+				t = vector.T
+			} else {
+				break
+			}
+		}
+		if m, ok := t.(*build.MapType); ok {
+			t = m.V
+		}
+		if st, ok := t.(*build.StructType); ok {
+			if b := pkg.FindBean(st.Name); b != nil {
+				writeSchema(h, pkg, b, comments, visited)
+			} else {
+				fmt.Fprintf(h, "missing %s\n", st.Name)
+			}
+		}
+	}
+}
+
+func exprString(e build.Expr) string {
+	switch e := e.(type) {
+	case nil:
+		return "-"
+	case *build.BasicLit:
+		return fmt.Sprintf("%v:%s", e.Kind, e.Value)
+	case build.Ident:
+		return string(e)
+	case *build.BasicType:
+		return e.Name
+	case *build.StructType:
+		return e.Package + "." + e.Name
+	case *build.ArrayType:
+		return "[" + exprString(e.Size) + "]" + exprString(e.T)
+	case *build.VectorType:
+		return "vector<" + exprString(e.T) + ">"
+	case *build.MapType:
+		return "map<" + exprString(e.K) + "," + exprString(e.V) + ">"
+	default:
+		return fmt.Sprintf("%T%+v", e, e)
+	}
+}
+
+// exportSettings 返回影响协议导出结果的配置
+func exportSettings(cfg *Config, bean *build.Bean) ([]byte, error) {
+	type exportSetting struct {
+		Name          string `json:"name"`
+		Dir           string `json:"dir"`
+		Manifest      bool   `json:"manifest"`
+		Int64AsString bool   `json:"int64_as_string"`
+		Format        string `json:"format,omitempty"`
+		LuaDedup      bool   `json:"lua_dedup,omitempty"`
+		Codec         string `json:"codec,omitempty"`
+		// 加密密钥文件内容的哈希
+		EncryptionKey string `json:"encryption_key,omitempty"`
+		// 生成代码的模板及其内容的哈希
+		Templates []string `json:"templates,omitempty"`
+	}
+	var settings struct {
+		JSON    JSONConfig      `json:"json"`
+		Exports []exportSetting `json:"exports"`
+	}
+	settings.JSON = cfg.JSON
+	for _, export := range exportsOfBean(bean) {
+		exportConfig := cfg.Export(export)
+		setting := exportSetting{
+			Name:          export,
+			Dir:           exportDir(cfg, export),
+			Manifest:      exportConfig.Manifest != "",
+			Int64AsString: exportConfig.Int64AsString,
+			Format:        exportConfig.Format,
+			LuaDedup:      exportConfig.LuaDedup,
+			Codec:         codec.Join(exportConfig.Compress, exportConfig.EncryptionKey != ""),
+		}
+		if exportConfig.EncryptionKey != "" {
+			// 密钥文件无法读取时在导出时报错
+			setting.EncryptionKey, _ = fileChecksum(exportConfig.EncryptionKey)
+		}
+		for _, c := range codegenOutputsOfExport(cfg, bean, export) {
+			// 模板无法读取时在生成代码时报错
+			checksum, _ := fileChecksum(c.template)
+			setting.Templates = append(setting.Templates, c.template, checksum, c.output, strconv.FormatBool(c.rowsOnly))
+		}
+		settings.Exports = append(settings.Exports, setting)
+	}
+	return json.Marshal(settings)
+}
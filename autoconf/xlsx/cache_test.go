@@ -0,0 +1,84 @@
+package xlsx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// TestExportCache 重复导出 testdata/golden 中的表格, 检查哪些协议重新导出.
+// 使用缓存的协议不重写导出的文件, 重新导出的文件通过重命名替换, 因此可以用 os.SameFile 区分
+func TestExportCache(t *testing.T) {
+	outdir := t.TempDir()
+	cfg := &Config{
+		XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+		Outdir:  outdir,
+		Cache:   filepath.Join(t.TempDir(), "cache.json"),
+	}
+	pkg := goldenPackage()
+	files := []string{"client/Item.json", "client/Global.json", "server/Item.json", "server/Stone.json", "server/Global.json"}
+	for _, tt := range []struct {
+		name    string
+		prepare func()
+		// 重新导出的文件, 为 nil 时不检查
+		exported map[string]bool
+	}{
+		{"no cache", func() {}, nil},
+		{"unchanged", func() {}, map[string]bool{}},
+		{
+			// 导出的文件被修改时重新导出该协议
+			"output modified",
+			func() { os.WriteFile(filepath.Join(outdir, "server", "Stone.json"), []byte("{}"), 0644) },
+			map[string]bool{"server/Stone.json": true},
+		},
+		{
+			"output removed",
+			func() { os.Remove(filepath.Join(outdir, "client", "Global.json")) },
+			map[string]bool{"client/Global.json": true, "server/Global.json": true},
+		},
+		{
+			"schema changed",
+			func() { pkg.Files[0].Beans[2].Fields[0].Comment = "// 横坐标" },
+			map[string]bool{"client/Item.json": true, "server/Item.json": true},
+		},
+		{
+			"tag changed",
+			func() {
+				global := pkg.Files[0].Beans[len(pkg.Files[0].Beans)-1]
+				global.Fields[0].Tag = build.Tag(`default:"1"`)
+			},
+			map[string]bool{"client/Global.json": true, "server/Global.json": true},
+		},
+		{
+			"cache removed",
+			func() { os.Remove(cfg.Cache) },
+			map[string]bool{"client/Item.json": true, "client/Global.json": true, "server/Item.json": true, "server/Stone.json": true, "server/Global.json": true},
+		},
+	} {
+		tt.prepare()
+		before := make(map[string]os.FileInfo)
+		for _, name := range files {
+			if info, err := os.Stat(filepath.Join(outdir, name)); err == nil {
+				before[name] = info
+			}
+		}
+		if err := ExportJSON(pkg, cfg); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		checkGolden(t, outdir)
+		if tt.exported == nil {
+			continue
+		}
+		for _, name := range files {
+			info, err := os.Stat(filepath.Join(outdir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exported := before[name] == nil || !os.SameFile(before[name], info); exported != tt.exported[name] {
+				t.Errorf("%s: %s exported %v, want %v", tt.name, name, exported, tt.exported[name])
+			}
+		}
+	}
+}
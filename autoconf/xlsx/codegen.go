@@ -0,0 +1,599 @@
+package xlsx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// codegen 根据协议的表格生成代码. 导出目标的 codegen 配置中每一项指定协议 table, 模板 template 和输出文件 output,
+// 导出该协议时用 text/template 执行模板. 模板的数据为 codegenData:
+//
+//	.Package  包名
+//	.Export   导出目标
+//	.Table    协议的表格, 包括 .Name, .Comment, .Singleton, .Key, .Fields 和 .Indexes
+//	.Rows     所有行, 单例表只有一行
+//	.Row      单例表的行, 其他表格为 nil
+//
+// 每一行是字段 key(name 标签)到值的 map, 只包含导出到该目标的字段. 值按字段类型转换: 整数为 int64(uint64 为 uint64),
+// 浮点数为 float64, 定点小数为 string, 枚举为 int, 结构体为 map, 数组为 []interface{}.
+// 字段的元数据见 codegenField, 模板中可以使用的函数见 codegenFuncs.
+// errors 和 strings 模板是 errors_table 和 strings_table 的 codegen, 数据为 .Rows
+
+// codegenOutput 一个导出目标中根据协议的表格生成代码的模板
+type codegenOutput struct {
+	export   string
+	template string
+	output   string
+	// 模板的数据为所有行的列表, 用于 errors 和 strings 模板
+	rowsOnly bool
+}
+
+// codegenOutputsOf 返回协议 bean 在所有导出目标中的 codegen
+func codegenOutputsOf(cfg *Config, bean *build.Bean) []codegenOutput {
+	var outputs []codegenOutput
+	exported := make(map[string]bool)
+	for _, export := range exportsOfBean(bean) {
+		if exported[export] || export == "-" || export == "" {
+			continue
+		}
+		exported[export] = true
+		outputs = append(outputs, codegenOutputsOfExport(cfg, bean, export)...)
+	}
+	return outputs
+}
+
+// codegenOutputsOfExport 返回协议 bean 在导出目标 export 中的 codegen
+func codegenOutputsOfExport(cfg *Config, bean *build.Bean, export string) []codegenOutput {
+	exportConfig := cfg.Export(export)
+	var outputs []codegenOutput
+	if t := exportConfig.Errors; t != nil && cfg.ErrorsTable == bean.Name {
+		outputs = append(outputs, codegenOutput{export: export, template: t.Template, output: t.Output, rowsOnly: true})
+	}
+	if t := exportConfig.Strings; t != nil && cfg.StringsTable == bean.Name {
+		outputs = append(outputs, codegenOutput{export: export, template: t.Template, output: t.Output, rowsOnly: true})
+	}
+	for _, c := range exportConfig.Codegen {
+		if c.Table == bean.Name {
+			outputs = append(outputs, codegenOutput{export: export, template: c.Template, output: c.Output})
+		}
+	}
+	return outputs
+}
+
+// logUnusedTemplates 输出没有指定 errors_table 或 strings_table 的模板, 与旧版本相同, 这些模板被忽略
+func logUnusedTemplates(cfg *Config) {
+	if cfg.ErrorsTable == "" {
+		for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+			return exportConfig.Errors != nil
+		}) {
+			log.Printf("export %s: ignore errors template because errors_table is empty", export)
+		}
+	}
+	if cfg.StringsTable == "" {
+		for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+			return exportConfig.Strings != nil
+		}) {
+			log.Printf("export %s: ignore strings template because strings_table is empty", export)
+		}
+	}
+}
+
+// checkCodegenTables 检查 codegen 的协议都导出到对应的导出目标
+func checkCodegenTables(pkg *build.Package, cfg *Config) error {
+	for export, exportConfig := range cfg.Exports {
+		if exportConfig == nil || len(exportConfig.Codegen) == 0 {
+			continue
+		}
+		protocols := make(map[string]bool)
+		for _, bean := range protocolsOfExport(pkg, export) {
+			protocols[bean.Name] = true
+		}
+		for _, c := range exportConfig.Codegen {
+			if !protocols[c.Table] {
+				return fmt.Errorf("export %s: codegen table %s is not a protocol exported to %s", export, c.Table, export)
+			}
+		}
+	}
+	return nil
+}
+
+// codegenData 模板的数据
+type codegenData struct {
+	Package string
+	Export  string
+	Table   *codegenTable
+	Rows    []codegenRow
+	Row     codegenRow
+}
+
+// codegenRow 一行数据或一个结构体的值
+type codegenRow map[string]interface{}
+
+// codegenStruct 协议或结构体, Fields 包括继承的字段
+type codegenStruct struct {
+	Name    string
+	Comment string
+	Fields  []*codegenField
+}
+
+// Field 返回 key 为 key 的字段, 不存在时返回 nil
+func (s *codegenStruct) Field(key string) *codegenField {
+	for _, f := range s.Fields {
+		if f.Key == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// codegenTable 协议的表格
+type codegenTable struct {
+	*codegenStruct
+	Singleton bool
+	// key 字段, 为 nil 时表示 key 字段不导出
+	Key *codegenField
+	// 有 index 标签的字段
+	Indexes []*codegenField
+}
+
+// codegenField 字段的元数据
+type codegenField struct {
+	// 字段名
+	Name string
+	// 数据中的字段名, 由 name 标签指定
+	Key     string
+	Comment string
+	// 值(数组为元素)的类型名: 基础类型名, 枚举名或结构体名
+	Type string
+	// 值(数组为元素)的类型: integer, float, decimal, bool, string, enum 或 struct
+	Kind string
+	// 数组大小, 0 表示不是数组
+	Size int
+	// 枚举字段的枚举
+	Enum *codegenEnum
+	// 结构体字段的结构体
+	Struct *codegenStruct
+
+	field *build.Field
+}
+
+// IsArray 判断字段是否是数组
+func (f *codegenField) IsArray() bool {
+	return f.Size > 0
+}
+
+// Optional 返回字段的 optional 标签, 没有时为空
+func (f *codegenField) Optional() string {
+	return f.field.GetTag("optional")
+}
+
+// Tag 返回字段的标签
+func (f *codegenField) Tag(name string) string {
+	return f.field.GetTag(name)
+}
+
+// codegenEnum 枚举
+type codegenEnum struct {
+	Name    string
+	Comment string
+	Values  []*codegenEnumValue
+}
+
+// codegenEnumValue 枚举的一项
+type codegenEnumValue struct {
+	Name  string
+	Desc  string
+	Value int
+}
+
+// Lookup 返回值为 value 的枚举项, 不存在时返回 nil
+func (e *codegenEnum) Lookup(value interface{}) *codegenEnumValue {
+	n, ok := codegenInt(value)
+	if !ok {
+		return nil
+	}
+	for _, v := range e.Values {
+		if int64(v.Value) == n {
+			return v
+		}
+	}
+	return nil
+}
+
+var codegenKinds = map[valueKind]string{
+	kindInteger: "integer",
+	kindFloat:   "float",
+	kindDecimal: "decimal",
+	kindBool:    "bool",
+	kindString:  "string",
+	kindEnum:    "enum",
+	kindStruct:  "struct",
+}
+
+// codegenSchema 构建一个导出目标的协议, 结构体和枚举的元数据
+type codegenSchema struct {
+	pkg     *build.Package
+	export  string
+	structs map[*build.Bean]*codegenStruct
+	enums   map[string]*codegenEnum
+}
+
+func newCodegenSchema(pkg *build.Package, export string) *codegenSchema {
+	return &codegenSchema{
+		pkg:     pkg,
+		export:  export,
+		structs: make(map[*build.Bean]*codegenStruct),
+		enums:   make(map[string]*codegenEnum),
+	}
+}
+
+func (s *codegenSchema) structOf(bean *build.Bean) (*codegenStruct, error) {
+	if st, ok := s.structs[bean]; ok {
+		return st, nil
+	}
+	st := &codegenStruct{
+		Name:    bean.Name,
+		Comment: getCommentContent(bean.Comment),
+	}
+	s.structs[bean] = st
+	fields, err := schemaFieldsOfBean(s.pkg, bean, s.export)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		field, err := s.fieldOf(f)
+		if err != nil {
+			return nil, err
+		}
+		st.Fields = append(st.Fields, field)
+	}
+	return st, nil
+}
+
+func (s *codegenSchema) fieldOf(f *schemaField) (*codegenField, error) {
+	field := &codegenField{
+		Name:    f.name,
+		Key:     f.key,
+		Comment: getCommentContent(f.field.Comment),
+		Type:    f.basic,
+		Kind:    codegenKinds[f.kind],
+		Size:    f.size,
+		field:   f.field,
+	}
+	switch f.kind {
+	case kindEnum:
+		field.Type = f.bean.Name
+		field.Enum = s.enumOf(f.bean)
+	case kindStruct:
+		field.Type = f.bean.Name
+		var err error
+		if field.Struct, err = s.structOf(f.bean); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (s *codegenSchema) enumOf(bean *build.Bean) *codegenEnum {
+	if e, ok := s.enums[bean.Name]; ok {
+		return e
+	}
+	e := &codegenEnum{
+		Name:    bean.Name,
+		Comment: getCommentContent(bean.Comment),
+	}
+	for _, v := range enumValuesOf(bean) {
+		if v.hasValue {
+			e.Values = append(e.Values, &codegenEnumValue{Name: v.name, Desc: v.desc, Value: v.value})
+		}
+	}
+	s.enums[bean.Name] = e
+	return e
+}
+
+// enum 返回名为 name 的枚举
+func (s *codegenSchema) enum(name string) (*codegenEnum, error) {
+	bean := s.pkg.FindBean(name)
+	if bean == nil || bean.Kind != "enum" {
+		return nil, fmt.Errorf("enum %s not found", name)
+	}
+	return s.enumOf(bean), nil
+}
+
+// tableOf 返回协议 bean 的表格
+func (s *codegenSchema) tableOf(bean *build.Bean) (*codegenTable, error) {
+	t, err := newSchemaTable(s.pkg, bean, s.export)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.structOf(bean)
+	if err != nil {
+		return nil, err
+	}
+	table := &codegenTable{
+		codegenStruct: st,
+		Singleton:     t.singleton,
+	}
+	if t.key != nil {
+		table.Key = st.Field(t.key.key)
+	}
+	for _, f := range t.indexes {
+		table.Indexes = append(table.Indexes, st.Field(f.key))
+	}
+	return table, nil
+}
+
+// rowOf 将导出的结构体的值转换为 codegenRow, 没有导出的字段被移除
+func rowOf(st *codegenStruct, value interface{}) codegenRow {
+	obj, ok := value.(*object)
+	if !ok {
+		return nil
+	}
+	row := make(codegenRow, len(st.Fields))
+	for _, f := range st.Fields {
+		if v, ok := obj.values[f.Key]; ok {
+			row[f.Key] = f.value(v)
+		}
+	}
+	return row
+}
+
+// value 将导出的字段值转换为模板中的值
+func (f *codegenField) value(v interface{}) interface{} {
+	if values, ok := v.([]interface{}); ok && f.Size > 0 {
+		result := make([]interface{}, len(values))
+		for i := range values {
+			result[i] = f.elemValue(values[i])
+		}
+		return result
+	}
+	return f.elemValue(v)
+}
+
+func (f *codegenField) elemValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case *object:
+		if f.Struct != nil {
+			return rowOf(f.Struct, v)
+		}
+		return plainValue(v)
+	case int64Value:
+		return int64(v)
+	case uint64Value:
+		return uint64(v)
+	case decimal:
+		return string(v)
+	case int64:
+		if f.Kind == "enum" {
+			return int(v)
+		}
+	}
+	return v
+}
+
+// codegenInt 将模板中的整数值转换为 int64
+func codegenInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	case float64:
+		return int64(v), float64(int64(v)) == v
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// codegenFuncs 返回模板中可以使用的函数:
+//
+//	lower, upper                     转换为小写, 大写
+//	camel, pascal, snake, kebab, constant
+//	                                 转换为 fooBar, FooBar, foo_bar, foo-bar, FOO_BAR
+//	quote                            go 字符串字面量
+//	json                             json 编码
+//	enum "Color"                     返回枚举, .Values 为所有枚举项
+//	enumName "Color" 1               返回枚举值的名字
+//	enumDesc "Color" 1               返回枚举值的描述
+//	lookup key                       返回 key 字段为 key 的行, 不存在时返回 nil
+//	lookupBy "field" value           返回字段 field(数据中的字段名)为 value 的所有行
+func codegenFuncs(schema *codegenSchema, data *codegenData) template.FuncMap {
+	var (
+		keys    map[string]codegenRow
+		indexes = make(map[string]map[string][]codegenRow)
+	)
+	enumValue := func(name string, value interface{}) (*codegenEnumValue, error) {
+		e, err := schema.enum(name)
+		if err != nil {
+			return nil, err
+		}
+		v := e.Lookup(value)
+		if v == nil {
+			return nil, fmt.Errorf("invalid value %v of enum %s", value, name)
+		}
+		return v, nil
+	}
+	return template.FuncMap{
+		"lower":    strings.ToLower,
+		"upper":    strings.ToUpper,
+		"camel":    camelCase,
+		"pascal":   pascalCase,
+		"snake":    snakeCase,
+		"kebab":    kebabCase,
+		"constant": constantCase,
+		"quote":    strconv.Quote,
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"enum": schema.enum,
+		"enumName": func(name string, value interface{}) (string, error) {
+			v, err := enumValue(name, value)
+			if err != nil {
+				return "", err
+			}
+			return v.Name, nil
+		},
+		"enumDesc": func(name string, value interface{}) (string, error) {
+			v, err := enumValue(name, value)
+			if err != nil {
+				return "", err
+			}
+			return v.Desc, nil
+		},
+		"lookup": func(key interface{}) (codegenRow, error) {
+			if data.Table.Key == nil {
+				return nil, fmt.Errorf("key field of %s is not exported", data.Table.Name)
+			}
+			if keys == nil {
+				keys = make(map[string]codegenRow, len(data.Rows))
+				for _, row := range data.Rows {
+					keys[fmt.Sprint(row[data.Table.Key.Key])] = row
+				}
+			}
+			return keys[fmt.Sprint(key)], nil
+		},
+		"lookupBy": func(field string, value interface{}) ([]codegenRow, error) {
+			f := data.Table.Field(field)
+			if f == nil || f.IsArray() || f.Kind == "struct" {
+				return nil, fmt.Errorf("field %s of %s not found or not comparable", field, data.Table.Name)
+			}
+			index, ok := indexes[field]
+			if !ok {
+				index = make(map[string][]codegenRow)
+				for _, row := range data.Rows {
+					if v, ok := row[field]; ok && v != nil {
+						k := fmt.Sprint(v)
+						index[k] = append(index[k], row)
+					}
+				}
+				indexes[field] = index
+			}
+			return index[fmt.Sprint(value)], nil
+		},
+	}
+}
+
+// generateCode 用协议 bean 的所有行 rows 执行模板, 生成 c.output
+func generateCode(pkg *build.Package, st *stage, bean *build.Bean, c codegenOutput, rows []interface{}) error {
+	schema := newCodegenSchema(pkg, c.export)
+	table, err := schema.tableOf(bean)
+	if err != nil {
+		return err
+	}
+	data := &codegenData{
+		Package: pkg.Name,
+		Export:  c.export,
+		Table:   table,
+		Rows:    make([]codegenRow, 0, len(rows)),
+	}
+	for _, value := range rows {
+		data.Rows = append(data.Rows, rowOf(table.codegenStruct, value))
+	}
+	if table.Singleton && len(data.Rows) > 0 {
+		data.Row = data.Rows[0]
+	}
+	content, err := os.ReadFile(c.template)
+	if err != nil {
+		return err
+	}
+	t, err := template.New(filepath.Base(c.template)).Funcs(codegenFuncs(schema, data)).Parse(string(content))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if c.rowsOnly {
+		err = t.Execute(&buf, data.Rows)
+	} else {
+		err = t.Execute(&buf, data)
+	}
+	if err != nil {
+		return err
+	}
+	return st.writeFile(c.output, buf.Bytes(), stageGenerated)
+}
+
+// splitWords 将标识符按 _, -, 空格和大小写的变化分割为单词, 如 HTTPServer_id 分割为 HTTP, Server, id
+func splitWords(s string) []string {
+	var (
+		words []string
+		word  []rune
+	)
+	runes := []rune(s)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = word[:0]
+			}
+			continue
+		}
+		if len(word) > 0 && unicode.IsUpper(r) {
+			prev := word[len(word)-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				words = append(words, string(word))
+				word = word[:0]
+			}
+		}
+		word = append(word, r)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+func titleWord(w string) string {
+	runes := []rune(strings.ToLower(w))
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func pascalCase(s string) string {
+	var buf strings.Builder
+	for _, w := range splitWords(s) {
+		buf.WriteString(titleWord(w))
+	}
+	return buf.String()
+}
+
+func camelCase(s string) string {
+	var buf strings.Builder
+	for i, w := range splitWords(s) {
+		if i == 0 {
+			buf.WriteString(strings.ToLower(w))
+		} else {
+			buf.WriteString(titleWord(w))
+		}
+	}
+	return buf.String()
+}
+
+func snakeCase(s string) string {
+	return strings.ToLower(strings.Join(splitWords(s), "_"))
+}
+
+func kebabCase(s string) string {
+	return strings.ToLower(strings.Join(splitWords(s), "-"))
+}
+
+func constantCase(s string) string {
+	return strings.ToUpper(strings.Join(splitWords(s), "_"))
+}
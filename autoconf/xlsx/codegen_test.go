@@ -0,0 +1,149 @@
+package xlsx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaseConversion(t *testing.T) {
+	for _, tt := range []struct {
+		s                                     string
+		pascal, camel, snake, kebab, constant string
+	}{
+		{"HTTPServer_id", "HttpServerId", "httpServerId", "http_server_id", "http-server-id", "HTTP_SERVER_ID"},
+		{"item2Name", "Item2Name", "item2Name", "item2_name", "item2-name", "ITEM2_NAME"},
+		{"max hp", "MaxHp", "maxHp", "max_hp", "max-hp", "MAX_HP"},
+		{"ID", "Id", "id", "id", "id", "ID"},
+		{"", "", "", "", "", ""},
+	} {
+		for _, c := range []struct {
+			name string
+			fn   func(string) string
+			want string
+		}{
+			{"pascal", pascalCase, tt.pascal},
+			{"camel", camelCase, tt.camel},
+			{"snake", snakeCase, tt.snake},
+			{"kebab", kebabCase, tt.kebab},
+			{"constant", constantCase, tt.constant},
+		} {
+			if got := c.fn(tt.s); got != c.want {
+				t.Errorf("%s(%q): got %q, want %q", c.name, tt.s, got, c.want)
+			}
+		}
+	}
+}
+
+// exportCodegen 用模板 templates(协议名到模板)导出 testdata/golden 的 export, errors 为 errors_table 为 Item 的模板.
+// 返回生成的文件的内容
+func exportCodegen(t *testing.T, export string, templates map[string]string, errors string) (map[string]string, error) {
+	t.Helper()
+	outdir := t.TempDir()
+	exportConfig := &ExportConfig{}
+	writeTemplate := func(name, content string) string {
+		filename := filepath.Join(outdir, "templates", name+".tmpl")
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	for table, content := range templates {
+		exportConfig.Codegen = append(exportConfig.Codegen, &CodegenConfig{
+			Table:    table,
+			Template: writeTemplate(table, content),
+			Output:   filepath.Join(outdir, "gen", table+".txt"),
+		})
+	}
+	cfg := &Config{
+		XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+		Outdir:  outdir,
+		Cache:   "-",
+		Exports: map[string]*ExportConfig{export: exportConfig},
+	}
+	if errors != "" {
+		cfg.ErrorsTable = "Item"
+		exportConfig.Errors = &TemplateConfig{
+			Template: writeTemplate("errors", errors),
+			Output:   filepath.Join(outdir, "gen", "errors.txt"),
+		}
+	}
+	if err := ExportJSON(goldenPackage(), cfg); err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	entries, err := os.ReadDir(filepath.Join(outdir, "gen"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(outdir, "gen", entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(data)
+	}
+	return files, nil
+}
+
+// TestCodegen 用 codegen 模板生成代码, 模板使用 text/template(不做 html 转义), 可以访问字段的元数据和函数库
+func TestCodegen(t *testing.T) {
+	files, err := exportCodegen(t, "server", map[string]string{
+		"Item": `package {{.Package}} // {{.Export}} {{.Table.Name}} {{constant .Table.Name}} key={{.Table.Key.Name}}
+{{range $i, $f := .Table.Fields}}{{if $i}} {{end}}{{.Key}}:{{.Kind}}:{{.Type}}{{if .IsArray}}[{{.Size}}]{{end}}{{end}}
+{{range .Rows}}{{if .name}}{{$.Table.Name}}{{.id}} = {{quote .name}}{{if .color}} {{enumName "Color" .color}}/{{enumDesc "Color" .color}} {{(index .attrs 1).b}}{{end}}
+{{end}}{{end}}{{with lookup 1004}}lookup 1004: {{.color}}{{end}}
+lookupBy color 0: {{range $i, $row := lookupBy "color" 0}}{{if $i}} {{end}}{{.id}}{{end}}
+{{range $i, $v := (enum "Color").Values}}{{if $i}} {{end}}{{pascal .Name}}={{.Value}}{{end}}
+{{with .Table.Field "price"}}{{json .}}{{end}}`,
+		"Global": `{{.Table.Singleton}} {{json .Row}} {{len .Rows}}`,
+	}, `{{range .}}{{.id}} {{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Item.txt": `package golden // server Item ITEM key=id
+attrs:struct:Attr[2] color:enum:Color flags:bool:bool[2] id:integer:int64 name:string:string pos:struct:Pos price:float:float64 ratio:float:float32 values:integer:int32[3]
+Item1001 = "剑" Red/红色 y
+Item9007199254740993 = "<b>&\"q\"" Blue/蓝色 z
+Item1003 = " 前后空格 "
+lookup 1004: 2
+lookupBy color 0: 1002 1003
+Red=1 Green=2 Blue=3
+{"Name":"price","Key":"price","Comment":"价格","Type":"float64","Kind":"float","Size":0,"Enum":null,"Struct":null}`,
+		"Global.txt": `true {"level":3,"title":"hello"} 1`,
+		"errors.txt": `1001 1002 9007199254740993 1003 1004 `,
+	}
+	for name, content := range want {
+		if files[name] != content {
+			t.Errorf("%s mismatch\ngot:\n%s\nwant:\n%s", name, files[name], content)
+		}
+	}
+	if len(files) != len(want) {
+		t.Errorf("got %d files, want %d", len(files), len(want))
+	}
+}
+
+func TestCodegenErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		export    string
+		templates map[string]string
+		err       string
+	}{
+		{"table not exported", "client", map[string]string{"Stone": ``}, "codegen table Stone is not a protocol exported to client"},
+		{"undeclared enum value", "server", map[string]string{"Stone": `{{range .Rows}}{{if .shade}}{{enumName "Color" .shade}}{{end}}{{end}}`}, "invalid value 9 of enum Color"},
+		{"unknown enum", "server", map[string]string{"Item": `{{enum "Shape"}}`}, "enum Shape not found"},
+		{"lookupBy struct field", "server", map[string]string{"Item": `{{lookupBy "pos" 0}}`}, "field pos of Item not found or not comparable"},
+		{"parse error", "server", map[string]string{"Item": `{{range}}`}, "missing value for range"},
+	} {
+		_, err := exportCodegen(t, tt.export, tt.templates, "")
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package xlsx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// 编译后节点的值类型
+type valueKind int
+
+const (
+	kindUnknown valueKind = iota
+	kindInteger
+	kindFloat
+	kindBool
+	kindString
+	kindEnum
+	kindDecimal
+	kindStruct
+	kindArray
+)
+
+// valuePlan 编译后的节点, 所有类型和标签都已解析, 对每一行数据直接按列取值
+type valuePlan struct {
+	kind     valueKind
+	nodeType string
+	text     string
+	// 叶子节点对应的列, -1 表示没有对应的列
+	column int
+	// 在 json 中的字段名, 为空表示不输出
+	key string
+
+	optional   optionalMode
+	sparse     sparseMode
+	precision  int
+	scale      int
+	hasDefault bool
+	// 默认值, 已经解析为节点类型的值
+	defaultValue interface{}
+	// 枚举描述到枚举值的映射
+	enums map[string]interface{}
+
+	// 子节点, 数组中缺少的元素为 nil
+	children []*valuePlan
+}
+
+// rowPlan 由表头编译的行转换计划
+type rowPlan struct {
+	root *valuePlan
+	// key 字段对应的节点, 为 nil 时每一行的 key 都为空
+	key *valuePlan
+}
+
+// compileRowPlan 将表头构建的节点树编译为行转换计划, nodes 为 buildJSONNodes 的结果,
+// nodes[i+1] 对应第 i 列
+func compileRowPlan(pkg *build.Package, bean *build.Bean, nodes []*Node) *rowPlan {
+	root := nodes[0]
+	root.sort(pkg)
+	columns := make(map[*Node]int, len(nodes)-1)
+	for i := 1; i < len(nodes); i++ {
+		// 相同标注的多列, 使用最后一列的数据
+		columns[nodes[i]] = i - 1
+	}
+	plan := &rowPlan{root: compileNode(root, columns)}
+	if root.children != nil && root.children.Len() > 0 {
+		keyField := keyFieldOfBean(pkg, bean)
+		for i, child := range root.children.list() {
+			if nameOfComment(child.text) == fieldName(keyField) {
+				plan.key = plan.root.children[i]
+				break
+			}
+		}
+	}
+	return plan
+}
+
+func compileNode(node *Node, columns map[*Node]int) *valuePlan {
+	if node == nil {
+		return nil
+	}
+	p := &valuePlan{
+		nodeType:  node.nodeType,
+		text:      node.text,
+		column:    -1,
+		key:       nameOfComment(node.text),
+		optional:  optionalOf(node.field),
+		precision: precisionOf(node.field),
+	}
+	if column, ok := columns[node]; ok {
+		p.column = column
+	}
+	if node.field != nil {
+		if tag := node.field.GetTag("name"); tag == "-" {
+			p.key = ""
+		} else if tag != "" {
+			p.key = tag
+		}
+	}
+	if node.isArray() {
+		p.kind = kindArray
+		p.sparse = sparseOf(node.field, p.optional)
+	} else if node.bean != nil && (node.bean.Kind == "protocol" || node.bean.Kind == "struct") {
+		p.kind = kindStruct
+	} else {
+		if scale, ok := decimalOf(node.field); ok && (node.isInteger() || node.isFloat() || node.isString()) {
+			p.kind = kindDecimal
+			p.scale = scale
+		} else if node.isInteger() {
+			p.kind = kindInteger
+		} else if node.isFloat() {
+			p.kind = kindFloat
+		} else if node.isBool() {
+			p.kind = kindBool
+		} else if node.isString() {
+			p.kind = kindString
+		} else if node.isEnum() {
+			p.kind = kindEnum
+			p.enums = make(map[string]interface{}, len(node.bean.Fields))
+			for _, f := range node.bean.Fields {
+				desc := descOfEnum(f)
+				if _, dup := p.enums[desc]; dup {
+					continue
+				}
+				if i, ok := build.ParseIntFromExpr(f.Default); ok {
+					p.enums[desc] = i
+				} else {
+					p.enums[desc] = nil
+				}
+			}
+		}
+		if node.field != nil && node.field.HasTag("default") {
+			p.hasDefault = true
+			p.defaultValue = p.parse(node.field.GetTag("default"))
+		}
+	}
+	if node.children != nil {
+		p.children = make([]*valuePlan, 0, node.children.Len())
+		for _, child := range node.children.list() {
+			p.children = append(p.children, compileNode(child, columns))
+		}
+	}
+	return p
+}
+
+// convert 转换一行数据, 返回该行的 key 和值, key 为空的行应该被忽略
+func (plan *rowPlan) convert(row []string) (string, interface{}) {
+	var key string
+	if plan.key != nil {
+		if value, ok := plan.key.value(row); ok && value != nil {
+			key = strings.TrimSpace(fmt.Sprintf("%v", value))
+		}
+	}
+	value, _ := plan.root.value(row)
+	return key, value
+}
+
+// value 返回节点的值, ok 表示该节点是否被设置. 空单元格的处理规则如下:
+//
+//  1. 字段带有 default 标签时, 空单元格视为填写了默认值, 如 `default:"10"`,
+//     枚举字段的默认值可以是枚举值或枚举描述, 数组字段的默认值作用于每个元素
+//  2. 否则该单元格视为未设置, 结构体的所有单元格都未设置时该结构体视为未设置,
+//     数组的所有元素都未设置时该数组视为未设置
+//  3. 未设置的字段或数组元素根据 optional 标签输出:
+//     - 没有 optional 标签: 输出零值, 即 0, "", false, 0(枚举) 或者字段都为零值的结构体
+//     - `optional:"true"`: 不输出该字段, 数组中未设置的元素被移除
+//     - `optional:"null"`: 输出 null, 数组中未设置的元素也输出 null
+//  4. 数组字段可以用 sparse 标签指定未设置元素的处理方式, 占位值在可选数组中为 null, 否则为零值:
+//     - `sparse:"keep"`: 保留所有元素的位置, 未设置的元素输出占位值
+//     - `sparse:"compact"`: 移除所有未设置的元素, 之后的元素位置前移
+//     - `sparse:"trim"`: 只移除末尾连续的未设置元素, 中间的未设置元素输出占位值
+//
+// 数字的处理见 parseInteger, parseFloat, 带有 `decimal:"<scale>"` 标签的字段为定点小数,
+// 带有 `precision:"<n>"` 标签的浮点数字段四舍五入到 n 位小数
+func (p *valuePlan) value(row []string) (interface{}, bool) {
+	switch p.kind {
+	case kindArray:
+		var (
+			values = make([]interface{}, 0, len(p.children))
+			set    bool
+			// 最后一个被设置的元素之后的位置
+			end int
+			// 用于生成缺少列的元素的占位值
+			elem *valuePlan
+		)
+		for _, child := range p.children {
+			if child != nil {
+				elem = child
+				break
+			}
+		}
+		for _, child := range p.children {
+			var (
+				value interface{}
+				ok    bool
+			)
+			if child != nil {
+				value, ok = child.value(row)
+			} else if elem != nil {
+				// 缺少列的元素视为未设置, 与所有单元格为空的元素相同
+				value, _ = elem.value(nil)
+			}
+			if ok {
+				set = true
+			} else if p.sparse == sparseCompact {
+				continue
+			} else if p.optional != notOptional {
+				value = nil
+			} else if value == nil {
+				continue
+			}
+			values = append(values, value)
+			if ok {
+				end = len(values)
+			}
+		}
+		if p.sparse == sparseTrim {
+			values = values[:end]
+		}
+		return values, set
+	case kindStruct:
+		// 子节点已经按字段声明顺序排序(见 Node.sort), 输出时保持该顺序
+		var (
+			values = newObject()
+			set    bool
+		)
+		for _, child := range p.children {
+			value, ok := child.value(row)
+			if ok {
+				set = true
+			}
+			if child.key == "" {
+				continue
+			}
+			if !ok {
+				switch child.optional {
+				case optionalOmit:
+					continue
+				case optionalNull:
+					values.set(child.key, nil)
+					continue
+				}
+			}
+			if value != nil {
+				values.set(child.key, value)
+			}
+		}
+		return values, set
+	}
+
+	var data string
+	if p.column >= 0 && p.column < len(row) {
+		data = row[p.column]
+	}
+	if isEmptyCell(data, p.kind == kindString) {
+		if !p.hasDefault {
+			return p.parse(""), false
+		}
+		return p.defaultValue, true
+	}
+	return p.parse(data), true
+}
+
+func isEmptyCell(data string, isString bool) bool {
+	if isString {
+		return data == ""
+	}
+	return strings.TrimSpace(data) == ""
+}
+
+// parse 解析叶子节点的单元格数据
+func (p *valuePlan) parse(data string) interface{} {
+	switch p.kind {
+	case kindDecimal:
+		return makeDecimal(data, p.scale)
+	case kindInteger:
+		return parseInteger(p.nodeType, data)
+	case kindFloat:
+		return parseFloat(p.nodeType, data, p.precision)
+	case kindBool:
+		var str = strings.TrimSpace(data)
+		if str == yes {
+			return true
+		}
+		if b, err := strconv.ParseBool(str); err == nil {
+			return b
+		}
+		if i, err := strconv.Atoi(str); err == nil {
+			return i != 0
+		}
+		return false
+	case kindString:
+		return data
+	case kindEnum:
+		data = strings.TrimSpace(data)
+		if data == "" {
+			return 0
+		}
+		if n, err := strconv.ParseInt(data, 10, 64); err == nil {
+			return n
+		}
+		if value, ok := p.enums[data]; ok {
+			return value
+		}
+		return 0
+	}
+	return nil
+}
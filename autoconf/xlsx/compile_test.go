@@ -0,0 +1,272 @@
+package xlsx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/midlang/mid/src/mid/build"
+	"github.com/midlang/mid/src/mid/lexer"
+)
+
+func goldenField(name string, t build.Type, tag, comment string) *build.Field {
+	return &build.Field{Names: []string{name}, Type: t, Tag: build.Tag(tag), Comment: comment}
+}
+
+func goldenEnumValue(name string, value int, comment string) *build.Field {
+	return &build.Field{Names: []string{name}, Default: &build.BasicLit{Kind: lexer.INT, Value: fmt.Sprint(value)}, Comment: comment}
+}
+
+// goldenPackage 返回 testdata/golden 中的表格对应的协议. 只使用基础版本就支持的特性,
+// 结构体的字段按名称顺序声明, 使按声明顺序输出的 json 与基础版本按名称排序的输出相同
+func goldenPackage() *build.Package {
+	basic := func(name string) build.Type { return &build.BasicType{Name: name} }
+	beans := []*build.Bean{
+		{Kind: "enum", Name: "Color", Fields: []*build.Field{
+			goldenEnumValue("Red", 1, "// 红色"),
+			goldenEnumValue("Green", 2, "// 绿色"),
+			goldenEnumValue("Blue", 3, "// 蓝色"),
+		}},
+		{Kind: "struct", Name: "Attr", Fields: []*build.Field{
+			goldenField("a", basic("int32"), "", "// 属性 a"),
+			goldenField("b", basic("string"), "", "// 属性 b"),
+		}},
+		{Kind: "struct", Name: "Pos", Fields: []*build.Field{
+			goldenField("x", basic("int"), "", "// x"),
+			goldenField("y", basic("int"), "", "// y"),
+		}},
+		{Kind: "protocol", Name: "Item", Tag: `export:"client,server"`, Fields: []*build.Field{
+			goldenField("attrs", testArray(&build.StructType{Name: "Attr"}, 2), "", "// 属性"),
+			goldenField("color", &build.StructType{Name: "Color"}, "", "// 颜色"),
+			goldenField("flags", testArray(basic("bool"), 2), "", "// 标记"),
+			goldenField("hidden", basic("string"), `name:"-"`, "// 不导出"),
+			goldenField("id", basic("int64"), `key:"true"`, "// ID"),
+			goldenField("name", basic("string"), "", "// 名字"),
+			goldenField("pos", &build.StructType{Name: "Pos"}, "", "// 位置"),
+			goldenField("price", basic("float64"), "", "// 价格"),
+			goldenField("ratio", basic("float32"), "", "// 比例"),
+			goldenField("values", testArray(basic("int32"), 3), "", "// 数值"),
+		}},
+		{Kind: "struct", Name: "Base", Fields: []*build.Field{
+			goldenField("id", basic("int64"), `key:"true"`, "// ID"),
+			goldenField("name", basic("string"), "", "// 名字"),
+		}},
+		{Kind: "protocol", Name: "Stone", Tag: `export:"server"`, Extends: []build.Type{&build.StructType{Name: "Base"}}, Fields: []*build.Field{
+			goldenField("shade", &build.StructType{Name: "Color"}, "", "// 色调"),
+			goldenField("weight", basic("uint32"), "", "// 重量"),
+		}},
+		{Kind: "protocol", Name: "Global", Tag: `singleton:"true"`, Fields: []*build.Field{
+			goldenField("level", basic("int"), "", "// 等级"),
+			goldenField("title", basic("string"), "", "// 标题"),
+		}},
+	}
+	return &build.Package{Name: "golden", Files: []*build.File{{Filename: "golden.mid", Package: "golden", Beans: beans}}}
+}
+
+// TestExportGolden 比较导出的 json 与基础版本(逐行遍历 Node 树)对同一组表格的导出结果.
+// 表格包含枚举描述和枚举值, 未知的枚举描述, 数组, 嵌套和继承的结构体, 空单元格, 空行和没有 key 的行
+func TestExportGolden(t *testing.T) {
+	outdir := t.TempDir()
+	cfg := &Config{
+		XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+		Outdir:  outdir,
+		Cache:   "-",
+	}
+	if err := ExportJSON(goldenPackage(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, outdir)
+}
+
+// checkGolden 检查导出目录 outdir 中的文件与 testdata/golden/json 中的文件完全相同
+func checkGolden(t *testing.T, outdir string) {
+	t.Helper()
+	golden := filepath.Join("testdata", "golden", "json")
+	var count int
+	err := filepath.Walk(golden, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(golden, path)
+		want, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		got, err := os.ReadFile(filepath.Join(outdir, rel))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s mismatch\ngot:  %s\nwant: %s", rel, got, want)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatal("no golden files")
+	}
+	err = filepath.Walk(outdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(outdir, path)
+		if _, err := os.Stat(filepath.Join(golden, rel)); err != nil {
+			t.Errorf("unexpected output %s", rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// convertTestRow 用 fields 声明协议 Row, 按表头 header 编译行转换计划并转换 row, 返回 key 和值的 json
+func convertTestRow(t *testing.T, fields []*build.Field, header, row []string) (string, string) {
+	t.Helper()
+	bean := &build.Bean{Kind: "protocol", Name: "Row", Fields: fields}
+	beans := []*build.Bean{
+		{Kind: "enum", Name: "Color", Fields: []*build.Field{
+			goldenEnumValue("Red", 1, "// 红色"),
+			goldenEnumValue("Blue", 2, "// 蓝色"),
+		}},
+		{Kind: "struct", Name: "Attr", Fields: []*build.Field{
+			testField("a", &build.BasicType{Name: "int32"}, ""),
+			testField("b", &build.BasicType{Name: "string"}, ""),
+		}},
+		bean,
+	}
+	pkg := &build.Package{Name: "demo", Files: []*build.File{{Filename: "demo.mid", Package: "demo", Beans: beans}}}
+	plan := compileRowPlan(pkg, bean, buildJSONNodes(nil, pkg, bean, commentsOfRow(header), header))
+	key, value := plan.convert(row)
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, string(data)
+}
+
+// TestRowPlanDefaultAndOptional 检查 valuePlan.value 对空单元格的处理, 表头中没有的字段(extra.b)不输出
+func TestRowPlanDefaultAndOptional(t *testing.T) {
+	basic := func(name string) build.Type { return &build.BasicType{Name: name} }
+	fields := []*build.Field{
+		testField("id", basic("int"), `key:"true"`),
+		testField("level", basic("int"), `default:"10"`),
+		testField("name", basic("string"), `optional:"true"`),
+		testField("note", basic("string"), `optional:"null"`),
+		testField("color", &build.StructType{Name: "Color"}, `default:"蓝色"`),
+		testField("shade", &build.StructType{Name: "Color"}, ""),
+		testField("ok", basic("bool"), ""),
+		testField("attr", &build.StructType{Name: "Attr"}, `optional:"true"`),
+		testField("extra", &build.StructType{Name: "Attr"}, ""),
+	}
+	header := []string{
+		"id(int)", "level(int)", "name(string)", "note(string)", "color(Color)", "shade(Color)", "ok(bool)",
+		"attr(Attr).a(int32)", "attr(Attr).b(string)", "extra(Attr).a(int32)",
+	}
+	for _, tt := range []struct {
+		name string
+		row  []string
+		key  string
+		want string
+	}{
+		{
+			"all set",
+			[]string{"1", "3", "剑", "n", "红色", "2", "是", "5", "x", "7"},
+			"1",
+			`{"id":1,"level":3,"name":"剑","note":"n","color":1,"shade":2,"ok":true,"attr":{"a":5,"b":"x"},"extra":{"a":7}}`,
+		},
+		{
+			"empty cells",
+			[]string{"2", " ", "", "", "", "", "", "", "", ""},
+			"2",
+			`{"id":2,"level":10,"note":null,"color":2,"shade":0,"ok":false,"extra":{"a":0}}`,
+		},
+		{
+			// 空格是字符串的有效值, 未知的枚举描述输出 0, 未声明的枚举值原样输出
+			"blank string and unknown enum",
+			[]string{"3", "0", " ", "", "绿色", "5", "0", "", "y", ""},
+			"3",
+			`{"id":3,"level":0,"name":" ","note":null,"color":0,"shade":5,"ok":false,"attr":{"a":0,"b":"y"},"extra":{"a":0}}`,
+		},
+		{
+			"short row",
+			[]string{"", "1"},
+			"",
+			`{"id":0,"level":1,"note":null,"color":2,"shade":0,"ok":false,"extra":{"a":0}}`,
+		},
+	} {
+		key, got := convertTestRow(t, fields, header, tt.row)
+		if key != tt.key || got != tt.want {
+			t.Errorf("%s: got key %q value %s, want key %q value %s", tt.name, key, got, tt.key, tt.want)
+		}
+	}
+}
+
+// TestRowPlanSparse 检查数组中未设置元素的处理, 表头中每个数组都缺少下标 1 的列.
+// 缺少列的结构体元素的占位值与其他元素的列相同, 所有元素都未设置的可选数组按 optional 标签输出
+func TestRowPlanSparse(t *testing.T) {
+	ints := testArray(&build.BasicType{Name: "int32"}, 3)
+	fields := []*build.Field{
+		testField("keep", ints, ""),
+		testField("compact", ints, `sparse:"compact"`),
+		testField("trim", ints, `sparse:"trim"`),
+		testField("omit", ints, `optional:"true"`),
+		testField("null", ints, `optional:"null"`),
+		testField("nulltrim", ints, `optional:"null" sparse:"trim"`),
+		testField("attrs", testArray(&build.StructType{Name: "Attr"}, 2), ""),
+	}
+	var header []string
+	for _, f := range fields[:6] {
+		header = append(header, f.Names[0]+"(int32[]).0", f.Names[0]+"(int32[]).2")
+	}
+	header = append(header, "attrs(Attr[]).1.b(string)")
+	for _, tt := range []struct {
+		name string
+		row  []string
+		want string
+	}{
+		{
+			"first set",
+			[]string{"1", "", "1", "", "1", "", "1", "", "1", "", "1", "", "x"},
+			`{"keep":[1,0,0],"compact":[1],"trim":[1],"omit":[1],"null":[1,null,null],"nulltrim":[1],"attrs":[{"b":""},{"b":"x"}]}`,
+		},
+		{
+			"last set",
+			[]string{"", "3", "", "3", "", "3", "", "3", "", "3", "", "3", ""},
+			`{"keep":[0,0,3],"compact":[3],"trim":[0,0,3],"omit":[3],"null":[null,null,3],"nulltrim":[null,null,3],"attrs":[{"b":""},{"b":""}]}`,
+		},
+		{
+			"none set",
+			[]string{},
+			`{"keep":[0,0,0],"compact":[],"trim":[],"null":null,"nulltrim":null,"attrs":[{"b":""},{"b":""}]}`,
+		},
+	} {
+		if _, got := convertTestRow(t, fields, header, tt.row); got != tt.want {
+			t.Errorf("%s:\ngot:  %s\nwant: %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRowPlanFieldOrder 检查输出按字段的声明顺序, 与表头中列的顺序无关, 多次转换的输出相同
+func TestRowPlanFieldOrder(t *testing.T) {
+	fields := []*build.Field{
+		testField("zeta", &build.BasicType{Name: "int"}, `key:"true"`),
+		testField("attr", &build.StructType{Name: "Attr"}, ""),
+		testField("mid", &build.BasicType{Name: "string"}, ""),
+		testField("alpha", &build.BasicType{Name: "bool"}, ""),
+	}
+	header := []string{"alpha(bool)", "attr(Attr).b(string)", "mid(string)", "attr(Attr).a(int32)", "zeta(int)"}
+	row := []string{"是", "x", "m", "5", "9"}
+	want := `{"zeta":9,"attr":{"a":5,"b":"x"},"mid":"m","alpha":true}`
+	for i := 0; i < 10; i++ {
+		if _, got := convertTestRow(t, fields, header, row); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+}
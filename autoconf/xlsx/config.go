@@ -0,0 +1,619 @@
+package xlsx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jokgame/tools/autoconf/codec"
+)
+
+// 默认的项目配置文件, 按顺序查找
+var defaultConfigFiles = []string{"autoconf.yaml", "autoconf.yml", "autoconf.json"}
+
+// Config 项目配置
+type Config struct {
+	// excel 文件所在目录
+	XlsxDir string `json:"xlsxdir" yaml:"xlsxdir"`
+	// 输出目录, 导出目标默认输出到 <outdir>/<export>. 作为 midc 插件运行时由 midc 的输出目录覆盖
+	Outdir string `json:"outdir" yaml:"outdir"`
+	// json 格式化选项
+	JSON JSONConfig `json:"json" yaml:"json"`
+	// 错误码表, 用于生成错误码代码
+	ErrorsTable string `json:"errors_table" yaml:"errors_table"`
+	// 字符串表, 用于生成字符串代码
+	StringsTable string `json:"strings_table" yaml:"strings_table"`
+	// 导出目标, 如 client, server
+	Exports map[string]*ExportConfig `json:"exports" yaml:"exports"`
+	// 同时导出的协议数量, 默认为 CPU 核数
+	Jobs int `json:"jobs" yaml:"jobs"`
+	// 增量导出的缓存文件, 默认为 <outdir>/.autoconf-cache.json, "-" 表示不使用缓存
+	Cache string `json:"cache" yaml:"cache"`
+	// 出错后继续导出其他协议以报告所有错误, 仍然不修改任何输出文件
+	KeepGoing bool `json:"keep_going" yaml:"keep_going"`
+	// 构建编号, 写入导出目标的清单, 通常由构建系统通过环境变量 build-id 指定
+	BuildID string `json:"build_id" yaml:"build_id"`
+}
+
+// JSONConfig json 格式化选项, 都为空时输出紧凑格式
+type JSONConfig struct {
+	Prefix string `json:"prefix" yaml:"prefix"`
+	Indent string `json:"indent" yaml:"indent"`
+}
+
+// ExportConfig 导出目标配置
+type ExportConfig struct {
+	// 导出目录, 默认为 <outdir>/<export>
+	Dir string `json:"dir" yaml:"dir"`
+	// manifest 文件, 非空时导出的文件名带上 checksum
+	Manifest string `json:"manifest" yaml:"manifest"`
+	// 保留的历史清单数量, 历史清单引用的文件不会被删除, 可以用 automanifest 恢复
+	History int `json:"history" yaml:"history"`
+	// ed25519 私钥文件, 非空时对清单签名, 签名保存在 <manifest>.sig 中
+	SigningKey string `json:"signing_key" yaml:"signing_key"`
+	// 导出格式, json(默认), msgpack, protobuf 或 lua. protobuf 格式同时生成 <dir>/<package>.proto
+	Format string `json:"format" yaml:"format"`
+	// lua 格式中多次出现的相同的子表是否只创建一次, 减少加载后的内存占用. 共享的子表不能在脚本中修改
+	LuaDedup bool `json:"lua_dedup" yaml:"lua_dedup"`
+	// 压缩算法, gzip 或 zstd, 为空表示不压缩
+	Compress string `json:"compress" yaml:"compress"`
+	// AES 密钥文件(hex 或 base64 编码的 16, 24 或 32 字节), 非空时使用 AES-GCM 加密导出的文件
+	EncryptionKey string `json:"encryption_key" yaml:"encryption_key"`
+	// 是否将所有表格打包为一个文件 <export>.pack, 配置了 manifest 时为 <export>.<sha256>.pack
+	Pack bool `json:"pack" yaml:"pack"`
+	// 是否将所有表格导出到 sqlite 数据库 <dir>/<export>.sqlite, 用于数据分析和 GM 工具
+	SQLite bool `json:"sqlite" yaml:"sqlite"`
+	// 生成建表语句 <dir>/<export>.schema.sql 和数据脚本 <dir>/<export>.seed.sql 的 sql 方言,
+	// mysql 或 postgres, 为空表示不生成
+	SQL string `json:"sql" yaml:"sql"`
+	// 是否将 int64/uint64 字段输出为字符串, 用于无法精确表示 64 位整数的客户端
+	Int64AsString bool `json:"int64_as_string" yaml:"int64_as_string"`
+	// 根据错误码表生成代码的模板, 相当于 errors_table 的 codegen, 模板的数据为所有行的列表, 没有 errors_table 时忽略
+	Errors *TemplateConfig `json:"errors" yaml:"errors"`
+	// 根据字符串表生成代码的模板, 相当于 strings_table 的 codegen, 模板的数据为所有行的列表, 没有 strings_table 时忽略
+	Strings *TemplateConfig `json:"strings" yaml:"strings"`
+	// 根据协议的表格生成代码的模板, 见 codegen.go
+	Codegen []*CodegenConfig `json:"codegen" yaml:"codegen"`
+	// 生成加载导出数据的 go 代码, 只支持 json 格式
+	Go *GoConfig `json:"go" yaml:"go"`
+	// 生成 typescript 类型声明 tables.d.ts 和加载代码 tables.js 的目录, 为空表示不生成, 只支持不压缩不加密的 json 格式
+	TypeScript string `json:"typescript" yaml:"typescript"`
+	// 生成加载导出数据的 c# 代码, 用于 Unity 客户端, 只支持 json 和 msgpack 格式, 只支持 gzip 压缩且不支持加密
+	CSharp *CSharpConfig `json:"csharp" yaml:"csharp"`
+	// 生成各协议的 json schema <Protocol>.schema.json 的目录, 为空表示不生成, 只支持 json 格式
+	JSONSchema string `json:"json_schema" yaml:"json_schema"`
+}
+
+// TemplateConfig 代码生成模板
+type TemplateConfig struct {
+	Template string `json:"template" yaml:"template"`
+	Output   string `json:"output" yaml:"output"`
+}
+
+// CodegenConfig 根据一个协议的表格生成代码的模板
+type CodegenConfig struct {
+	// 协议名
+	Table    string `json:"table" yaml:"table"`
+	Template string `json:"template" yaml:"template"`
+	Output   string `json:"output" yaml:"output"`
+}
+
+// GoConfig 生成加载导出数据的 go 代码的配置
+type GoConfig struct {
+	// 输出目录
+	Dir string `json:"dir" yaml:"dir"`
+	// 包名, 默认为输出目录名
+	Package string `json:"package" yaml:"package"`
+}
+
+// CSharpConfig 生成加载导出数据的 c# 代码的配置
+type CSharpConfig struct {
+	// 输出目录
+	Dir string `json:"dir" yaml:"dir"`
+	// 命名空间, 默认为首字母大写的包名
+	Namespace string `json:"namespace" yaml:"namespace"`
+}
+
+// LoadConfig 加载配置文件, 根据后缀名选择 yaml 或 json 格式,
+// 文件中的相对路径都相对于配置文件所在目录
+func LoadConfig(filename string) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(Config)
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("parse config %s error: %w", filename, err)
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("parse config %s error: %w", filename, err)
+		}
+		if dec.More() {
+			return nil, fmt.Errorf("parse config %s error: unexpected data after top-level value", filename)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file %s", filename)
+	}
+	cfg.resolvePaths(filepath.Dir(filename))
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// loadConfigWithEnv 加载配置文件并使用环境变量覆盖配置项.
+// 配置文件由环境变量 config 指定, 未指定时查找默认的配置文件, 找不到则使用空配置
+func loadConfigWithEnv(envvars map[string]string) (*Config, error) {
+	var (
+		cfg *Config
+		err error
+	)
+	if filename := envvars["config"]; filename != "" {
+		cfg, err = LoadConfig(filename)
+	} else {
+		for _, filename := range defaultConfigFiles {
+			if _, statErr := os.Stat(filename); statErr == nil {
+				cfg, err = LoadConfig(filename)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = new(Config)
+	}
+	if err := cfg.applyEnv(envvars); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate 检查配置是否有效
+func (cfg *Config) Validate() error {
+	if cfg.Jobs < 0 {
+		return fmt.Errorf("invalid jobs %d", cfg.Jobs)
+	}
+	for name, export := range cfg.Exports {
+		if name == "" || name == "-" || strings.ContainsAny(name, ", \t/\\") {
+			return fmt.Errorf("invalid export name %q", name)
+		}
+		if export == nil {
+			return fmt.Errorf("export %s: empty config", name)
+		}
+		if export.History < 0 {
+			return fmt.Errorf("export %s: invalid history %d", name, export.History)
+		}
+		switch export.Format {
+		case "", formatJSON, formatMsgpack, formatProtobuf, formatLua:
+		default:
+			return fmt.Errorf("export %s: unsupported format %q", name, export.Format)
+		}
+		if export.Int64AsString && export.Format != "" && export.Format != formatJSON && export.Format != formatLua {
+			return fmt.Errorf("export %s: int64_as_string is only supported by json and lua format", name)
+		}
+		if export.LuaDedup && export.Format != formatLua {
+			return fmt.Errorf("export %s: lua_dedup is only supported by lua format", name)
+		}
+		switch export.Compress {
+		case "", codec.Gzip, codec.Zstd:
+		default:
+			return fmt.Errorf("export %s: unsupported compress %q", name, export.Compress)
+		}
+		switch sqlDialect(export.SQL) {
+		case "", sqlMySQL, sqlPostgres:
+		default:
+			return fmt.Errorf("export %s: unsupported sql dialect %q", name, export.SQL)
+		}
+		if export.SigningKey != "" && export.Manifest == "" {
+			return fmt.Errorf("export %s: signing_key specified but manifest is empty", name)
+		}
+		if err := export.Errors.validate(); err != nil {
+			return fmt.Errorf("export %s: errors: %w", name, err)
+		}
+		if err := export.Strings.validate(); err != nil {
+			return fmt.Errorf("export %s: strings: %w", name, err)
+		}
+		for i, c := range export.Codegen {
+			if err := c.validate(); err != nil {
+				return fmt.Errorf("export %s: codegen[%d]: %w", name, i, err)
+			}
+		}
+		if err := export.Go.validate(); err != nil {
+			return fmt.Errorf("export %s: go: %w", name, err)
+		}
+		if export.Go != nil && export.Format != "" && export.Format != formatJSON {
+			return fmt.Errorf("export %s: go code is only supported by json format", name)
+		}
+		if export.TypeScript != "" && export.Format != "" && export.Format != formatJSON {
+			return fmt.Errorf("export %s: typescript code is only supported by json format", name)
+		}
+		if export.TypeScript != "" && (export.Compress != "" || export.EncryptionKey != "") {
+			return fmt.Errorf("export %s: typescript code does not support compress or encryption_key", name)
+		}
+		if export.JSONSchema != "" && export.Format != "" && export.Format != formatJSON {
+			return fmt.Errorf("export %s: json schema is only supported by json format", name)
+		}
+		if err := export.CSharp.validate(); err != nil {
+			return fmt.Errorf("export %s: csharp: %w", name, err)
+		}
+		if export.CSharp != nil && export.Format != "" && export.Format != formatJSON && export.Format != formatMsgpack {
+			return fmt.Errorf("export %s: csharp code is only supported by json and msgpack format", name)
+		}
+		if export.CSharp != nil && (export.Compress == codec.Zstd || export.EncryptionKey != "") {
+			return fmt.Errorf("export %s: csharp code only supports gzip compress without encryption_key", name)
+		}
+	}
+	return nil
+}
+
+// jobs 返回同时导出的协议数量
+func (cfg *Config) jobs() int {
+	if cfg.Jobs > 0 {
+		return cfg.Jobs
+	}
+	return runtime.NumCPU()
+}
+
+// cacheFile 返回增量导出的缓存文件, 为空表示不使用缓存
+func (cfg *Config) cacheFile() string {
+	switch cfg.Cache {
+	case "-":
+		return ""
+	case "":
+		return filepath.Join(cfg.Outdir, defaultCacheFile)
+	}
+	return cfg.Cache
+}
+
+func (t *TemplateConfig) validate() error {
+	if t == nil {
+		return nil
+	}
+	if t.Template == "" {
+		return errors.New("template is empty")
+	}
+	if t.Output == "" {
+		return errors.New("output is empty")
+	}
+	return nil
+}
+
+func (c *CodegenConfig) validate() error {
+	if c == nil {
+		return errors.New("codegen is empty")
+	}
+	if c.Table == "" {
+		return errors.New("table is empty")
+	}
+	return (&TemplateConfig{Template: c.Template, Output: c.Output}).validate()
+}
+
+func (c *GoConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Dir == "" {
+		return errors.New("dir is empty")
+	}
+	if name := c.packageName(); !token.IsIdentifier(name) {
+		return fmt.Errorf("invalid package name %q", name)
+	}
+	return nil
+}
+
+func (c *GoConfig) packageName() string {
+	if c.Package != "" {
+		return c.Package
+	}
+	return filepath.Base(c.Dir)
+}
+
+func (c *CSharpConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Dir == "" {
+		return errors.New("dir is empty")
+	}
+	if c.Namespace != "" {
+		for _, name := range strings.Split(c.Namespace, ".") {
+			if !token.IsIdentifier(name) {
+				return fmt.Errorf("invalid namespace %q", c.Namespace)
+			}
+		}
+	}
+	return nil
+}
+
+// Export 返回导出目标的配置, 不存在时返回空配置
+func (cfg *Config) Export(name string) ExportConfig {
+	if export, ok := cfg.Exports[name]; ok && export != nil {
+		return *export
+	}
+	return ExportConfig{}
+}
+
+func (cfg *Config) export(name string) *ExportConfig {
+	if cfg.Exports == nil {
+		cfg.Exports = make(map[string]*ExportConfig)
+	}
+	export, ok := cfg.Exports[name]
+	if !ok || export == nil {
+		export = new(ExportConfig)
+		cfg.Exports[name] = export
+	}
+	return export
+}
+
+// exportsWhere 返回配置满足 match 的导出目标, 按名称排序
+func exportsWhere(cfg *Config, match func(*ExportConfig) bool) []string {
+	var exports []string
+	for name, export := range cfg.Exports {
+		if export != nil && match(export) {
+			exports = append(exports, name)
+		}
+	}
+	sort.Strings(exports)
+	return exports
+}
+
+func (cfg *Config) resolvePaths(dir string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	resolve(&cfg.XlsxDir)
+	resolve(&cfg.Outdir)
+	if cfg.Cache != "-" {
+		resolve(&cfg.Cache)
+	}
+	for _, export := range cfg.Exports {
+		if export == nil {
+			continue
+		}
+		resolve(&export.Dir)
+		resolve(&export.Manifest)
+		resolve(&export.SigningKey)
+		resolve(&export.EncryptionKey)
+		for _, t := range []*TemplateConfig{export.Errors, export.Strings} {
+			if t != nil {
+				resolve(&t.Template)
+				resolve(&t.Output)
+			}
+		}
+		for _, c := range export.Codegen {
+			if c != nil {
+				resolve(&c.Template)
+				resolve(&c.Output)
+			}
+		}
+		if export.Go != nil {
+			resolve(&export.Go.Dir)
+		}
+		resolve(&export.TypeScript)
+		if export.CSharp != nil {
+			resolve(&export.CSharp.Dir)
+		}
+		resolve(&export.JSONSchema)
+	}
+}
+
+// applyEnv 使用 midc 的环境变量(-Ekey=value)覆盖配置项, 支持的环境变量:
+//
+//	xlsxdir
+//	jsonprefix (兼容旧的拼写 jsonpreifx)
+//	jsonindent
+//	errors-table
+//	strings-table
+//	jobs
+//	cache
+//	keep-going
+//	build-id
+//	exported-<export>-dir
+//	manifest-<export>
+//	history-<export>
+//	signing-key-<export>
+//	format-<export>
+//	lua-dedup-<export>
+//	compress-<export>
+//	encryption-key-<export>
+//	pack-<export>
+//	sqlite-<export>
+//	sql-<export>
+//	errors-<export>-template, errors-<export>-output
+//	strings-<export>-template, strings-<export>-output
+//	go-<export>-dir, go-<export>-package
+//	typescript-<export>
+//	csharp-<export>-dir, csharp-<export>-namespace
+//	json-schema-<export>
+//
+// 其他环境变量可能由 midc 的其他插件使用, 只输出日志. 与旧版本相同, 只通过环境变量指定了
+// errors 或 strings 的模板和输出文件之一时忽略该模板, 配置文件中不完整的模板在 Validate 时报错
+func (cfg *Config) applyEnv(envvars map[string]string) error {
+	// 配置文件中指定的模板
+	configured := make(map[*TemplateConfig]bool)
+	for _, export := range cfg.Exports {
+		if export != nil {
+			configured[export.Errors] = true
+			configured[export.Strings] = true
+		}
+	}
+	for key, value := range envvars {
+		switch key {
+		case "config":
+		case "xlsxdir":
+			cfg.XlsxDir = value
+		case "jsonprefix":
+			cfg.JSON.Prefix = value
+		case "jsonpreifx":
+			if _, ok := envvars["jsonprefix"]; !ok {
+				cfg.JSON.Prefix = value
+			}
+		case "jsonindent":
+			cfg.JSON.Indent = value
+		case "errors-table":
+			cfg.ErrorsTable = value
+		case "strings-table":
+			cfg.StringsTable = value
+		case "jobs":
+			jobs, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid env jobs %q", value)
+			}
+			cfg.Jobs = jobs
+		case "cache":
+			cfg.Cache = value
+		case "keep-going":
+			keepGoing := true
+			if value != "" {
+				var err error
+				if keepGoing, err = strconv.ParseBool(value); err != nil {
+					return fmt.Errorf("invalid env keep-going %q", value)
+				}
+			}
+			cfg.KeepGoing = keepGoing
+		case "build-id":
+			cfg.BuildID = value
+		default:
+			if name, ok := cutAffix(key, "exported-", "-dir"); ok {
+				cfg.export(name).Dir = value
+			} else if name, ok := cutAffix(key, "manifest-", ""); ok {
+				cfg.export(name).Manifest = value
+			} else if name, ok := cutAffix(key, "history-", ""); ok {
+				history, err := strconv.Atoi(value)
+				if err != nil {
+					return fmt.Errorf("invalid env %s %q", key, value)
+				}
+				cfg.export(name).History = history
+			} else if name, ok := cutAffix(key, "signing-key-", ""); ok {
+				cfg.export(name).SigningKey = value
+			} else if name, ok := cutAffix(key, "format-", ""); ok {
+				cfg.export(name).Format = value
+			} else if name, ok := cutAffix(key, "lua-dedup-", ""); ok {
+				dedup, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("invalid env %s %q", key, value)
+				}
+				cfg.export(name).LuaDedup = dedup
+			} else if name, ok := cutAffix(key, "compress-", ""); ok {
+				cfg.export(name).Compress = value
+			} else if name, ok := cutAffix(key, "encryption-key-", ""); ok {
+				cfg.export(name).EncryptionKey = value
+			} else if name, ok := cutAffix(key, "pack-", ""); ok {
+				pack, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("invalid env %s %q", key, value)
+				}
+				cfg.export(name).Pack = pack
+			} else if name, ok := cutAffix(key, "sqlite-", ""); ok {
+				sqlite, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("invalid env %s %q", key, value)
+				}
+				cfg.export(name).SQLite = sqlite
+			} else if name, ok := cutAffix(key, "sql-", ""); ok {
+				cfg.export(name).SQL = value
+			} else if name, ok := cutAffix(key, "errors-", "-template"); ok {
+				cfg.export(name).errorsTemplate().Template = value
+			} else if name, ok := cutAffix(key, "errors-", "-output"); ok {
+				cfg.export(name).errorsTemplate().Output = value
+			} else if name, ok := cutAffix(key, "strings-", "-template"); ok {
+				cfg.export(name).stringsTemplate().Template = value
+			} else if name, ok := cutAffix(key, "strings-", "-output"); ok {
+				cfg.export(name).stringsTemplate().Output = value
+			} else if name, ok := cutAffix(key, "go-", "-dir"); ok {
+				cfg.export(name).goConfig().Dir = value
+			} else if name, ok := cutAffix(key, "go-", "-package"); ok {
+				cfg.export(name).goConfig().Package = value
+			} else if name, ok := cutAffix(key, "typescript-", ""); ok {
+				cfg.export(name).TypeScript = value
+			} else if name, ok := cutAffix(key, "csharp-", "-dir"); ok {
+				cfg.export(name).csharpConfig().Dir = value
+			} else if name, ok := cutAffix(key, "csharp-", "-namespace"); ok {
+				cfg.export(name).csharpConfig().Namespace = value
+			} else if name, ok := cutAffix(key, "json-schema-", ""); ok {
+				cfg.export(name).JSONSchema = value
+			} else {
+				log.Printf("ignore unknown env %s", key)
+			}
+		}
+	}
+	for name, export := range cfg.Exports {
+		if export == nil {
+			continue
+		}
+		if t := export.Errors; t != nil && !configured[t] && (t.Template == "" || t.Output == "") {
+			log.Printf("ignore incomplete env errors-%s-template and errors-%s-output", name, name)
+			export.Errors = nil
+		}
+		if t := export.Strings; t != nil && !configured[t] && (t.Template == "" || t.Output == "") {
+			log.Printf("ignore incomplete env strings-%s-template and strings-%s-output", name, name)
+			export.Strings = nil
+		}
+	}
+	return nil
+}
+
+func (export *ExportConfig) errorsTemplate() *TemplateConfig {
+	if export.Errors == nil {
+		export.Errors = new(TemplateConfig)
+	}
+	return export.Errors
+}
+
+func (export *ExportConfig) csharpConfig() *CSharpConfig {
+	if export.CSharp == nil {
+		export.CSharp = new(CSharpConfig)
+	}
+	return export.CSharp
+}
+
+func (export *ExportConfig) goConfig() *GoConfig {
+	if export.Go == nil {
+		export.Go = new(GoConfig)
+	}
+	return export.Go
+}
+
+func (export *ExportConfig) stringsTemplate() *TemplateConfig {
+	if export.Strings == nil {
+		export.Strings = new(TemplateConfig)
+	}
+	return export.Strings
+}
+
+// cutAffix 去掉 s 的前缀 prefix 和后缀 suffix, 剩余部分非空时返回 true
+func cutAffix(s, prefix, suffix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) || !strings.HasSuffix(s, suffix) {
+		return "", false
+	}
+	if len(s) <= len(prefix)+len(suffix) {
+		return "", false
+	}
+	return s[len(prefix) : len(s)-len(suffix)], true
+}
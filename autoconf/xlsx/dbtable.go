@@ -0,0 +1,260 @@
+package xlsx
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// dbTable 协议在数据库中对应的表, 用于生成 sqlite 数据库和 sql 脚本:
+//
+//   - 表名为协议名, 按表格中的顺序插入各行
+//   - 结构体字段展开为多列, 列名为各层字段名(name 标签)以 "." 连接, 如 "attrs.hp"
+//   - 数组字段为一个 json 列, 未设置的字段为 NULL
+//   - key 字段为主键或唯一索引, `index:"unique"` 的字段建唯一索引, `index:"true"` 的字段建普通索引
+type dbTable struct {
+	bean *build.Bean
+	// 导出目标, 只包含导出到该目标的字段
+	export  string
+	columns []*dbColumn
+	// key 字段的列, 为 nil 时表示 key 字段不是单独的一列
+	key *dbColumn
+	// 唯一索引的列
+	uniques []*dbColumn
+	// 普通索引的列
+	indexes []*dbColumn
+}
+
+// dbColumn 表的一列, 对应一个字段或展开的结构体中的一个字段
+type dbColumn struct {
+	field *schemaField
+	name  string
+	// 在行数据中的各层字段名
+	path []string
+	// 数组或无法展开的结构体, 以 json 保存
+	json bool
+}
+
+// newDBTable 生成协议 bean 在导出目标 export 中对应的表
+func newDBTable(pkg *build.Package, bean *build.Bean, export string) (*dbTable, error) {
+	table := &dbTable{bean: bean, export: export}
+	if err := table.addColumns(pkg, bean, nil, []*build.Bean{bean}); err != nil {
+		return nil, err
+	}
+	key := keyFieldOfBean(pkg, bean)
+	for _, c := range table.columns {
+		if len(c.path) != 1 || c.json {
+			continue
+		}
+		if c.field.field == key {
+			table.key = c
+		} else if index := indexOf(c.field.field); index == indexUnique {
+			table.uniques = append(table.uniques, c)
+		} else if index == indexNormal {
+			table.indexes = append(table.indexes, c)
+		}
+	}
+	return table, nil
+}
+
+func (table *dbTable) addColumns(pkg *build.Package, bean *build.Bean, prefix []string, path []*build.Bean) error {
+	fields, err := schemaFieldsOfBean(pkg, bean, table.export)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		c := &dbColumn{
+			field: f,
+			path:  append(append([]string(nil), prefix...), f.key),
+		}
+		c.name = strings.Join(c.path, ".")
+		if f.kind == kindStruct && f.size == 0 && !containsBean(path, f.bean) {
+			if err := table.addColumns(pkg, f.bean, c.path, append(path, f.bean)); err != nil {
+				return err
+			}
+			continue
+		}
+		c.json = f.size > 0 || f.kind == kindStruct
+		table.columns = append(table.columns, c)
+	}
+	return nil
+}
+
+func containsBean(beans []*build.Bean, bean *build.Bean) bool {
+	for _, b := range beans {
+		if b == bean {
+			return true
+		}
+	}
+	return false
+}
+
+// values 返回一行数据 row 中各列的值, 未设置的列为 nil
+func (table *dbTable) values(row interface{}) []interface{} {
+	values := make([]interface{}, len(table.columns))
+	for i, c := range table.columns {
+		value := row
+		for _, key := range c.path {
+			obj, ok := value.(*object)
+			if !ok {
+				value = nil
+				break
+			}
+			value = obj.values[key]
+		}
+		values[i] = value
+	}
+	return values
+}
+
+// indexed 判断列是否为 key 或带有索引
+func (table *dbTable) indexed(c *dbColumn) bool {
+	if c == table.key {
+		return true
+	}
+	for _, x := range table.uniques {
+		if x == c {
+			return true
+		}
+	}
+	for _, x := range table.indexes {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
+
+// dbJobsOf 返回导出到 export 的协议的导出任务
+func dbJobsOf(jobs []*exportJob, export string) []*exportJob {
+	var result []*exportJob
+	for _, job := range jobs {
+		for _, e := range exportsOfBean(job.bean) {
+			if e == export {
+				result = append(result, job)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// dbFingerprint 返回根据协议 jobs 的表格生成的数据库文件的指纹, 由生成设置 settings (如 sql 方言),
+// 协议列表和各协议表格的哈希决定. 没有使用增量导出的缓存时返回空字符串
+func dbFingerprint(settings string, jobs []*exportJob) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", settings)
+	for _, job := range jobs {
+		hash := "-"
+		if job.entry != nil {
+			hash = job.entry.Hash
+		} else if job.skipped == "" {
+			return ""
+		}
+		fmt.Fprintf(h, "%s %s\n", job.bean.Name, hash)
+	}
+	return fmt.Sprintf("%02x", h.Sum(nil))
+}
+
+// dbRowFiles 协议的表格在一个导出目标的数据库文件中的各行数据, 导出表格时写入暂存目录中的临时文件,
+// 所有表格导出后再合并, 见 stageSQLite 和 stageSQLScripts
+type dbRowFiles struct {
+	// sqlite 数据库中的各行, 见 sqliteRowsEncoder
+	sqlite string
+	// sql 数据脚本中的各行, 见 sqlSeedEncoder
+	seed string
+}
+
+// dbOutput 写入 dbRowFiles 中的一个临时文件
+type dbOutput struct {
+	export string
+	// 指向 dbRowFiles 中的文件名
+	target *string
+	file   *os.File
+	buf    *bufio.Writer
+	enc    tableEncoder
+}
+
+// newDBOutputs 为导出到 export 的协议 bean 创建需要的数据库临时文件, 导出目标没有配置 sqlite 和 sql 时返回 nil
+func newDBOutputs(pkg *build.Package, cfg *Config, st *stage, bean *build.Bean, export string, files *dbRowFiles) ([]*dbOutput, error) {
+	exportConfig := cfg.Export(export)
+	if !exportConfig.SQLite && exportConfig.SQL == "" {
+		return nil, nil
+	}
+	table, err := newDBTable(pkg, bean, export)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []*dbOutput
+	create := func(name string, target *string, newEncoder func(w io.Writer) (tableEncoder, error)) error {
+		file, err := st.create(exportDir(cfg, export), bean.Name+name)
+		if err != nil {
+			return err
+		}
+		out := &dbOutput{export: export, target: target, file: file, buf: bufio.NewWriter(file)}
+		outputs = append(outputs, out)
+		out.enc, err = newEncoder(out.buf)
+		return err
+	}
+	if exportConfig.SQLite {
+		err = create(".sqlite.rows", &files.sqlite, func(w io.Writer) (tableEncoder, error) {
+			return newSQLiteRowsEncoder(w, table), nil
+		})
+	}
+	if err == nil && exportConfig.SQL != "" {
+		err = create(".seed.sql.rows", &files.seed, func(w io.Writer) (tableEncoder, error) {
+			return newSQLSeedEncoder(w, sqlDialect(exportConfig.SQL), table)
+		})
+	}
+	if err != nil {
+		for _, out := range outputs {
+			out.file.Close()
+		}
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// close 写完数据后关闭临时文件并记录文件名
+func (out *dbOutput) close() error {
+	err := out.enc.close()
+	if err == nil {
+		err = out.buf.Flush()
+	}
+	if e := out.file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return fmt.Errorf("write file %s error: %w", out.file.Name(), err)
+	}
+	*out.target = out.file.Name()
+	return nil
+}
+
+// readDBRows 重新读取协议的表格, 依次用每一行的数据调用 fn, 用于使用了缓存的协议. 跳过的协议没有数据
+func readDBRows(pkg *build.Package, job *exportJob, fn func(row interface{}) error) error {
+	if job.skipped != "" {
+		return nil
+	}
+	sheet, err := openSheet(pkg, job.bean, job.filename)
+	if err != nil {
+		return err
+	}
+	defer sheet.Close()
+	for {
+		_, value, err := sheet.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(value); err != nil {
+			return err
+		}
+	}
+}
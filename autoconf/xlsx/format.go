@@ -0,0 +1,52 @@
+package xlsx
+
+import (
+	"path/filepath"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// 导出数据的格式
+const (
+	formatJSON     = "json"
+	formatMsgpack  = "msgpack"
+	formatProtobuf = "protobuf"
+	formatLua      = "lua"
+)
+
+// tableEncoder 逐行输出表格数据
+type tableEncoder interface {
+	encode(value interface{}) error
+	close() error
+}
+
+// formatExtension 返回导出格式的文件扩展名
+func formatExtension(format string) string {
+	switch format {
+	case formatMsgpack:
+		return ".msgpack"
+	case formatProtobuf:
+		return ".pb"
+	case formatLua:
+		return ".lua"
+	}
+	return ".json"
+}
+
+// stageProtoFiles 为导出格式为 protobuf 的导出目标生成 .proto 文件 <dir>/<package>.proto,
+// 包含导出到该目标的所有协议的表格
+func stageProtoFiles(pkg *build.Package, cfg *Config, st *stage) error {
+	for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+		return exportConfig.Format == formatProtobuf
+	}) {
+		content, err := generateProto(pkg, protocolsOfExport(pkg, export), export)
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(exportDir(cfg, export), pkg.Name+".proto")
+		if err := st.writeFile(filename, content, stageGenerated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1144 @@
+package xlsx
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/midlang/mid/src/mid/build"
+
+	"github.com/jokgame/tools/autoconf/codec"
+)
+
+// c# 代码, 用于 Unity 客户端加载导出目标的 json 或 msgpack 数据, 不依赖第三方库.
+// 配置了 csharp 的导出目标在 csharp.dir 中生成两个文件:
+//
+//	Autoconf.cs  json 和 msgpack 的解析, 清单, 加载函数 Registry.LoadManifest 和 Registry.LoadDir
+//	Tables.cs    枚举, 类, 各协议的表格和包含所有表格的 Registry
+//
+// 类的公开字段与导出的数据一一对应: 字段名为首字母大写的字段名, 只包含导出到该目标的字段,
+// 继承的协议或结构体为基类. 未设置的数值字段为 0, 数组为空数组.
+// 类都是 partial 类, 实现了 IRowValidator 的行在所有表格加载后检查
+
+// csGenerator 生成一个导出目标的 c# 代码
+type csGenerator struct {
+	pkg       *build.Package
+	export    string
+	namespace string
+	tables    []*schemaTable
+	// 需要生成的枚举和类
+	beans []*build.Bean
+}
+
+// stageCSharpFiles 为配置了 csharp 的导出目标生成 c# 代码
+func stageCSharpFiles(pkg *build.Package, cfg *Config, st *stage) error {
+	for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+		return exportConfig.CSharp != nil
+	}) {
+		exportConfig := cfg.Export(export)
+		g := &csGenerator{
+			pkg:       pkg,
+			export:    export,
+			namespace: exportConfig.CSharp.Namespace,
+		}
+		if g.namespace == "" {
+			g.namespace = goFieldName(pkg.Name)
+		}
+		var err error
+		if g.tables, g.beans, err = schemaTablesOf(pkg, export); err != nil {
+			return fmt.Errorf("generate csharp of %s error: %w", export, err)
+		}
+		if err := g.checkNames(); err != nil {
+			return fmt.Errorf("generate csharp of %s error: %w", export, err)
+		}
+		tables, err := g.generate()
+		if err != nil {
+			return fmt.Errorf("generate csharp of %s error: %w", export, err)
+		}
+		codecName := codec.Join(exportConfig.Compress, exportConfig.EncryptionKey != "")
+		ext := formatExtension(exportConfig.Format) + codec.Extension(codecName)
+		runtime := g.runtime(ext, codecName, exportConfig.Format == formatMsgpack)
+		if err := st.writeFile(filepath.Join(exportConfig.CSharp.Dir, "Autoconf.cs"), runtime, stageGenerated); err != nil {
+			return err
+		}
+		if err := st.writeFile(filepath.Join(exportConfig.CSharp.Dir, "Tables.cs"), tables, stageGenerated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csRuntimeNames Autoconf.cs 中声明的类型和 Registry 的成员
+var csRuntimeNames = map[string]bool{
+	"IRowValidator": true, "ManifestFile": true, "Manifest": true, "Registry": true,
+	"Values": true, "Json": true, "MsgPack": true, "Readers": true,
+}
+
+// checkNames 检查生成的类型名是否与 Autoconf.cs 中的名字冲突, 以及字段名是否与类名相同
+func (g *csGenerator) checkNames() error {
+	names := make(map[string]bool)
+	for _, bean := range g.beans {
+		names[bean.Name] = true
+		if csRuntimeNames[bean.Name] {
+			return fmt.Errorf("type name %s conflicts with generated code", bean.Name)
+		}
+	}
+	for _, t := range g.tables {
+		if name := t.bean.Name + "Table"; names[name] {
+			return fmt.Errorf("type name %s conflicts with the table of %s", name, t.bean.Name)
+		}
+	}
+	for _, bean := range g.beans {
+		if bean.Kind == "enum" {
+			continue
+		}
+		fields, err := g.ownFields(bean)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if goFieldName(f.name) == bean.Name {
+				return fmt.Errorf("field name '%s.%s::%s' conflicts with the class name", g.pkg.Name, bean.Name, f.name)
+			}
+		}
+	}
+	return nil
+}
+
+var csKeywords = map[string]bool{
+	"abstract": true, "as": true, "base": true, "bool": true, "break": true, "byte": true, "case": true,
+	"catch": true, "char": true, "checked": true, "class": true, "const": true, "continue": true,
+	"decimal": true, "default": true, "delegate": true, "do": true, "double": true, "else": true,
+	"enum": true, "event": true, "explicit": true, "extern": true, "false": true, "finally": true,
+	"fixed": true, "float": true, "for": true, "foreach": true, "goto": true, "if": true, "implicit": true,
+	"in": true, "int": true, "interface": true, "internal": true, "is": true, "lock": true, "long": true,
+	"namespace": true, "new": true, "null": true, "object": true, "operator": true, "out": true,
+	"override": true, "params": true, "private": true, "protected": true, "public": true,
+	"readonly": true, "ref": true, "return": true, "sbyte": true, "sealed": true, "short": true,
+	"sizeof": true, "stackalloc": true, "static": true, "string": true, "struct": true, "switch": true,
+	"this": true, "throw": true, "true": true, "try": true, "typeof": true, "uint": true, "ulong": true,
+	"unchecked": true, "unsafe": true, "ushort": true, "using": true, "virtual": true, "void": true,
+	"volatile": true, "while": true,
+}
+
+// csIdentifier 返回 c# 中的标识符, 关键字加上 @ 前缀
+func csIdentifier(name string) string {
+	if csKeywords[name] {
+		return "@" + name
+	}
+	return name
+}
+
+// csString 返回字符串的 c# 字面量
+func csString(s string) string {
+	return jsString(s)
+}
+
+func writeCSComment(buf *bytes.Buffer, indent, comment string) {
+	if comment = getCommentContent(comment); comment != "" {
+		comment = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(comment)
+		fmt.Fprintf(buf, "%s/// <summary>%s</summary>\n", indent, comment)
+	}
+}
+
+// csBasicTypes 整数类型在 c# 中的类型
+var csBasicTypes = map[string]string{
+	"int8":   "sbyte",
+	"int16":  "short",
+	"int32":  "int",
+	"int64":  "long",
+	"int":    "long",
+	"uint8":  "byte",
+	"byte":   "byte",
+	"uint16": "ushort",
+	"uint32": "uint",
+	"uint64": "ulong",
+	"uint":   "ulong",
+}
+
+// csElemType 返回字段的值(数组为元素)的 c# 类型
+func csElemType(f *schemaField) string {
+	switch f.kind {
+	case kindEnum, kindStruct:
+		return f.bean.Name
+	case kindDecimal:
+		return "decimal"
+	case kindBool:
+		return "bool"
+	case kindString:
+		return "string"
+	case kindFloat:
+		if f.basic == "float32" {
+			return "float"
+		}
+		return "double"
+	}
+	if typ, ok := csBasicTypes[f.basic]; ok {
+		return typ
+	}
+	return "long"
+}
+
+// csType 返回字段的 c# 类型
+func csType(f *schemaField) string {
+	if f.size > 0 {
+		return csElemType(f) + "[]"
+	}
+	return csElemType(f)
+}
+
+// csRead 返回将数据中的值 v 转换为字段(数组为元素)的类型的表达式
+func csRead(f *schemaField, v string) string {
+	switch f.kind {
+	case kindEnum:
+		return fmt.Sprintf("(%s)Values.AsLong(%s)", f.bean.Name, v)
+	case kindStruct:
+		return fmt.Sprintf("Readers.Read%s(%s)", f.bean.Name, v)
+	case kindDecimal:
+		return fmt.Sprintf("Values.AsDecimal(%s)", v)
+	case kindBool:
+		return fmt.Sprintf("Values.AsBool(%s)", v)
+	case kindString:
+		return fmt.Sprintf("Values.AsString(%s)", v)
+	case kindFloat:
+		if f.basic == "float32" {
+			return fmt.Sprintf("(float)Values.AsDouble(%s)", v)
+		}
+		return fmt.Sprintf("Values.AsDouble(%s)", v)
+	}
+	switch typ := csElemType(f); typ {
+	case "long":
+		return fmt.Sprintf("Values.AsLong(%s)", v)
+	case "ulong":
+		return fmt.Sprintf("Values.AsULong(%s)", v)
+	case "byte", "ushort", "uint":
+		return fmt.Sprintf("(%s)Values.AsULong(%s)", typ, v)
+	default:
+		return fmt.Sprintf("(%s)Values.AsLong(%s)", typ, v)
+	}
+}
+
+// generate 生成 Tables.cs
+func (g *csGenerator) generate() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by autoconf. DO NOT EDIT.\n\n")
+	buf.WriteString("using System;\nusing System.Collections.Generic;\n\n")
+	fmt.Fprintf(&buf, "namespace %s\n{\n", g.namespace)
+	for i, bean := range g.beans {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		if bean.Kind == "enum" {
+			g.writeEnum(&buf, bean)
+		} else if err := g.writeClass(&buf, bean); err != nil {
+			return nil, err
+		}
+	}
+	g.writeReaders(&buf)
+	for _, t := range g.tables {
+		g.writeTable(&buf, t)
+	}
+	g.writeRegistry(&buf)
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+func (g *csGenerator) writeEnum(buf *bytes.Buffer, bean *build.Bean) {
+	writeCSComment(buf, "\t", bean.Comment)
+	fmt.Fprintf(buf, "\tpublic enum %s\n\t{\n", bean.Name)
+	for _, v := range enumValuesOf(bean) {
+		if v.desc != v.name {
+			writeCSComment(buf, "\t\t", "// "+v.desc)
+		}
+		fmt.Fprintf(buf, "\t\t%s = %d,\n", csIdentifier(v.name), v.value)
+	}
+	buf.WriteString("\t}\n")
+}
+
+// ownFields 返回 bean 自己声明的(不包括继承的)导出到该目标的字段
+func (g *csGenerator) ownFields(bean *build.Bean) ([]*schemaField, error) {
+	fields, err := schemaFieldsOfBean(g.pkg, bean, g.export)
+	if err != nil {
+		return nil, err
+	}
+	own := make(map[*build.Field]bool, len(bean.Fields))
+	for _, f := range bean.Fields {
+		own[f] = true
+	}
+	var result []*schemaField
+	for _, f := range fields {
+		if own[f.field] {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+// baseOf 返回 bean 的基类, c# 只支持单继承
+func baseOf(bean *build.Bean) (string, error) {
+	switch len(bean.Extends) {
+	case 0:
+		return "", nil
+	case 1:
+		return bean.Extends[0].(*build.StructType).Name, nil
+	}
+	return "", fmt.Errorf("%s extends more than one bean, which is not supported by csharp", bean.Name)
+}
+
+func (g *csGenerator) writeClass(buf *bytes.Buffer, bean *build.Bean) error {
+	fields, err := g.ownFields(bean)
+	if err != nil {
+		return err
+	}
+	base, err := baseOf(bean)
+	if err != nil {
+		return err
+	}
+	writeCSComment(buf, "\t", bean.Comment)
+	fmt.Fprintf(buf, "\t[Serializable]\n\tpublic partial class %s", bean.Name)
+	if base != "" {
+		fmt.Fprintf(buf, " : %s", base)
+	}
+	buf.WriteString("\n\t{\n")
+	for _, f := range fields {
+		writeCSComment(buf, "\t\t", f.field.Comment)
+		fmt.Fprintf(buf, "\t\tpublic %s %s;\n", csType(f), csIdentifier(goFieldName(f.name)))
+	}
+	buf.WriteString("\t}\n")
+	return nil
+}
+
+// writeReaders 生成将解析后的数据转换为类的函数
+func (g *csGenerator) writeReaders(buf *bytes.Buffer) {
+	buf.WriteString("\n\tinternal static class Readers\n\t{")
+	for _, bean := range g.beans {
+		if bean.Kind == "enum" {
+			continue
+		}
+		// writeClass 已经检查过字段和继承关系
+		fields, _ := g.ownFields(bean)
+		base, _ := baseOf(bean)
+		name := bean.Name
+		fmt.Fprintf(buf, "\n\t\tinternal static %s Read%s(object value)\n\t\t{\n", name, name)
+		buf.WriteString("\t\t\tvar obj = Values.AsObject(value);\n\t\t\tif (obj == null)\n\t\t\t{\n\t\t\t\treturn null;\n\t\t\t}\n")
+		fmt.Fprintf(buf, "\t\t\tvar x = new %s();\n\t\t\tRead%sFields(x, obj);\n\t\t\treturn x;\n\t\t}\n", name, name)
+		fmt.Fprintf(buf, "\n\t\tinternal static void Read%sFields(%s x, IDictionary<string, object> obj)\n\t\t{\n", name, name)
+		if base != "" {
+			fmt.Fprintf(buf, "\t\t\tRead%sFields(x, obj);\n", base)
+		}
+		for _, f := range fields {
+			value := fmt.Sprintf("Values.Get(obj, %s)", csString(f.key))
+			var expr string
+			if f.size > 0 {
+				expr = fmt.Sprintf("Values.AsArray(%s, v => %s)", value, csRead(f, "v"))
+			} else {
+				expr = csRead(f, value)
+			}
+			fmt.Fprintf(buf, "\t\t\tx.%s = %s;\n", csIdentifier(goFieldName(f.name)), expr)
+		}
+		buf.WriteString("\t\t}\n")
+	}
+	buf.WriteString("\t}\n")
+}
+
+func (g *csGenerator) writeTable(buf *bytes.Buffer, t *schemaTable) {
+	name := t.bean.Name
+	typ := name + "Table"
+	rowsValue := csString("rows")
+	if t.singleton {
+		fmt.Fprintf(buf, "\n\t/// <summary>单例表 %s</summary>\n\tpublic sealed class %s\n\t{\n", name, typ)
+		fmt.Fprintf(buf, "\t\tprivate %s row = new %s();\n\n", name, name)
+		fmt.Fprintf(buf, "\t\t/// <summary>表格的数据</summary>\n\t\tpublic %s Row\n\t\t{\n\t\t\tget { return row; }\n\t\t}\n", name)
+		buf.WriteString("\n\t\tinternal void Load(object data)\n\t\t{\n")
+		fmt.Fprintf(buf, "\t\t\tvar row = Readers.Read%s(Values.Get(Values.AsObject(data), \"row\"));\n", name)
+		buf.WriteString("\t\t\tif (row != null)\n\t\t\t{\n\t\t\t\tthis.row = row;\n\t\t\t}\n\t\t}\n")
+		buf.WriteString("\n\t\tinternal void Validate(Registry r)\n\t\t{\n\t\t\tvar v = row as IRowValidator;\n")
+		buf.WriteString("\t\t\tif (v != null)\n\t\t\t{\n\t\t\t\tv.Validate(r);\n\t\t\t}\n\t\t}\n\t}\n")
+		return
+	}
+
+	fmt.Fprintf(buf, "\n\t/// <summary>表格 %s 的所有行</summary>\n\tpublic sealed class %s\n\t{\n", name, typ)
+	fmt.Fprintf(buf, "\t\tprivate readonly List<%s> rows = new List<%s>();\n", name, name)
+	if t.key != nil {
+		keyType := csType(t.key)
+		fmt.Fprintf(buf, "\t\tprivate readonly Dictionary<%s, %s> byKey = new Dictionary<%s, %s>();\n", keyType, name, keyType, name)
+	}
+	for _, f := range t.indexes {
+		field := goFieldName(f.name)
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "\t\tprivate readonly Dictionary<%s, %s> by%s = new Dictionary<%s, %s>();\n", csType(f), name, field, csType(f), name)
+		} else {
+			fmt.Fprintf(buf, "\t\tprivate readonly Dictionary<%s, List<%s>> by%s = new Dictionary<%s, List<%s>>();\n", csType(f), name, field, csType(f), name)
+		}
+	}
+	fmt.Fprintf(buf, "\n\t\t/// <summary>按表格中的顺序排列的所有行</summary>\n\t\tpublic IReadOnlyList<%s> Rows\n\t\t{\n\t\t\tget { return rows; }\n\t\t}\n", name)
+	buf.WriteString("\n\t\t/// <summary>行数</summary>\n\t\tpublic int Count\n\t\t{\n\t\t\tget { return rows.Count; }\n\t\t}\n")
+	if t.key != nil {
+		fmt.Fprintf(buf, "\n\t\t/// <summary>返回 %s 为 key 的行, 不存在时返回 null</summary>\n", t.key.key)
+		fmt.Fprintf(buf, "\t\tpublic %s Get(%s key)\n\t\t{\n\t\t\t%s row;\n", name, csType(t.key), name)
+		buf.WriteString("\t\t\treturn byKey.TryGetValue(key, out row) ? row : null;\n\t\t}\n")
+	}
+	for _, f := range t.indexes {
+		field := goFieldName(f.name)
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "\n\t\t/// <summary>返回 %s 为 value 的行, 不存在时返回 null</summary>\n", f.key)
+			fmt.Fprintf(buf, "\t\tpublic %s GetBy%s(%s value)\n\t\t{\n\t\t\t%s row;\n", name, field, csType(f), name)
+			fmt.Fprintf(buf, "\t\t\treturn by%s.TryGetValue(value, out row) ? row : null;\n\t\t}\n", field)
+		} else {
+			fmt.Fprintf(buf, "\n\t\t/// <summary>返回 %s 为 value 的所有行</summary>\n", f.key)
+			fmt.Fprintf(buf, "\t\tpublic IReadOnlyList<%s> GetBy%s(%s value)\n\t\t{\n\t\t\tList<%s> result;\n", name, field, csType(f), name)
+			fmt.Fprintf(buf, "\t\t\treturn by%s.TryGetValue(value, out result) ? result : (IReadOnlyList<%s>)Array.Empty<%s>();\n\t\t}\n", field, name, name)
+		}
+	}
+
+	buf.WriteString("\n\t\tinternal void Load(object data)\n\t\t{\n")
+	fmt.Fprintf(buf, "\t\t\tforeach (var value in Values.AsList(Values.Get(Values.AsObject(data), %s)))\n\t\t\t{\n", rowsValue)
+	fmt.Fprintf(buf, "\t\t\t\tvar row = Readers.Read%s(value);\n", name)
+	if t.key != nil {
+		field := csIdentifier(goFieldName(t.key.name))
+		fmt.Fprintf(buf, "\t\t\t\tif (byKey.ContainsKey(row.%s))\n\t\t\t\t{\n", field)
+		fmt.Fprintf(buf, "\t\t\t\t\tthrow new FormatException(%s + row.%s + \" duplicated\");\n\t\t\t\t}\n", csString(t.key.key+" "), field)
+		fmt.Fprintf(buf, "\t\t\t\tbyKey.Add(row.%s, row);\n", field)
+	}
+	for _, f := range t.indexes {
+		field := goFieldName(f.name)
+		value := "row." + csIdentifier(field)
+		if f.kind == kindString {
+			// 可选的字符串字段可能为 null, 不建立索引
+			fmt.Fprintf(buf, "\t\t\t\tif (%s != null)\n\t\t\t\t{\n", value)
+		} else {
+			buf.WriteString("\t\t\t\t{\n")
+		}
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "\t\t\t\t\tif (by%s.ContainsKey(%s))\n\t\t\t\t\t{\n", field, value)
+			fmt.Fprintf(buf, "\t\t\t\t\t\tthrow new FormatException(%s + %s + \" duplicated\");\n\t\t\t\t\t}\n", csString(f.key+" "), value)
+			fmt.Fprintf(buf, "\t\t\t\t\tby%s.Add(%s, row);\n", field, value)
+		} else {
+			fmt.Fprintf(buf, "\t\t\t\t\tList<%s> list;\n", name)
+			fmt.Fprintf(buf, "\t\t\t\t\tif (!by%s.TryGetValue(%s, out list))\n\t\t\t\t\t{\n", field, value)
+			fmt.Fprintf(buf, "\t\t\t\t\t\tlist = new List<%s>();\n\t\t\t\t\t\tby%s.Add(%s, list);\n\t\t\t\t\t}\n", name, field, value)
+			buf.WriteString("\t\t\t\t\tlist.Add(row);\n")
+		}
+		buf.WriteString("\t\t\t\t}\n")
+	}
+	buf.WriteString("\t\t\t\trows.Add(row);\n\t\t\t}\n\t\t}\n")
+
+	buf.WriteString("\n\t\tinternal void Validate(Registry r)\n\t\t{\n\t\t\tfor (var i = 0; i < rows.Count; i++)\n\t\t\t{\n")
+	buf.WriteString("\t\t\t\tvar v = rows[i] as IRowValidator;\n\t\t\t\tif (v == null)\n\t\t\t\t{\n\t\t\t\t\tcontinue;\n\t\t\t\t}\n")
+	buf.WriteString("\t\t\t\ttry\n\t\t\t\t{\n\t\t\t\t\tv.Validate(r);\n\t\t\t\t}\n\t\t\t\tcatch (Exception e)\n\t\t\t\t{\n")
+	buf.WriteString("\t\t\t\t\tthrow new FormatException(\"row \" + (i + 1) + \": \" + e.Message, e);\n\t\t\t\t}\n\t\t\t}\n\t\t}\n\t}\n")
+}
+
+func (g *csGenerator) writeRegistry(buf *bytes.Buffer) {
+	buf.WriteString("\n\t/// <summary>所有表格, 没有导出的表格为空表</summary>\n\tpublic sealed partial class Registry\n\t{\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(buf, "\t\tpublic readonly %sTable %s = new %sTable();\n", t.bean.Name, csIdentifier(t.bean.Name), t.bean.Name)
+	}
+	buf.WriteString("\n\t\t// 所有表格的名字和表格结构的哈希\n\t\tprivate static readonly string[][] tables =\n\t\t{\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(buf, "\t\t\tnew[] { %s, %s },\n", csString(t.bean.Name), csString(t.schemaHash))
+	}
+	buf.WriteString("\t\t};\n\n\t\tprivate void LoadTable(string name, object data)\n\t\t{\n\t\t\tswitch (name)\n\t\t\t{\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(buf, "\t\t\t\tcase %s:\n\t\t\t\t\t%s.Load(data);\n\t\t\t\t\tbreak;\n", csString(t.bean.Name), csIdentifier(t.bean.Name))
+	}
+	buf.WriteString("\t\t\t}\n\t\t}\n\n\t\tprivate void ValidateRows()\n\t\t{\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(buf, "\t\t\t%s.Validate(this);\n", csIdentifier(t.bean.Name))
+	}
+	buf.WriteString("\t\t}\n\t}\n")
+}
+
+// runtime 生成 Autoconf.cs, ext 和 codecName 为导出目标的数据文件的扩展名和编码, msgpack 表示数据文件为 msgpack 格式
+func (g *csGenerator) runtime(ext, codecName string, msgpack bool) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by autoconf. DO NOT EDIT.\n")
+	buf.WriteString(csRuntimeUsings)
+	fmt.Fprintf(&buf, "\nnamespace %s\n{", g.namespace)
+	buf.WriteString(csRuntime)
+	unmarshal := "Json.Parse(data)"
+	if msgpack {
+		unmarshal = "MsgPack.Unpack(data)"
+	}
+	buf.WriteString("\n\tpublic sealed partial class Registry\n\t{\n")
+	fmt.Fprintf(&buf, "\t\t// 表格文件的扩展名和编码\n\t\tprivate static readonly string fileExt = %s;\n\t\tprivate static readonly string fileCodec = %s;\n",
+		csString(ext), csString(codecName))
+	fmt.Fprintf(&buf, "\n\t\tprivate static object Unmarshal(byte[] data)\n\t\t{\n\t\t\treturn %s;\n\t\t}\n", unmarshal)
+	buf.WriteString(csRegistryRuntime)
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+const csRuntimeUsings = `
+using System;
+using System.Collections.Generic;
+using System.Globalization;
+using System.IO;
+using System.IO.Compression;
+using System.Security.Cryptography;
+using System.Text;
+`
+
+const csRuntime = `
+	/// <summary>行数据类型可以在 partial 类中实现的接口, 所有表格加载后对每一行调用, 抛出异常时加载失败</summary>
+	public interface IRowValidator
+	{
+		void Validate(Registry r);
+	}
+
+	/// <summary>清单中的一个文件</summary>
+	public sealed class ManifestFile
+	{
+		public string Name;
+		/// <summary>文件内容的 sha256</summary>
+		public string Checksum;
+		public string Filename;
+		public long Size;
+		/// <summary>协议定义的哈希</summary>
+		public string SchemaHash;
+		/// <summary>文件的编码, 为空表示未编码的数据</summary>
+		public string Codec;
+		public string PlainChecksum;
+		public long PlainSize;
+	}
+
+	/// <summary>导出目标的清单</summary>
+	public sealed class Manifest
+	{
+		public int Version;
+		public string BuildId;
+		public long Timestamp;
+		public string RootHash;
+		public List<ManifestFile> Files = new List<ManifestFile>();
+
+		/// <summary>解析清单文件的内容</summary>
+		public static Manifest Parse(byte[] data)
+		{
+			var obj = Values.AsObject(Json.Parse(data));
+			if (obj == null)
+			{
+				throw new FormatException("invalid manifest");
+			}
+			var m = new Manifest
+			{
+				Version = (int)Values.AsLong(Values.Get(obj, "version")),
+				BuildId = Values.AsString(Values.Get(obj, "build_id")),
+				Timestamp = Values.AsLong(Values.Get(obj, "timestamp")),
+				RootHash = Values.AsString(Values.Get(obj, "root_hash")),
+			};
+			foreach (var value in Values.AsList(Values.Get(obj, "files")))
+			{
+				var file = Values.AsObject(value);
+				m.Files.Add(new ManifestFile
+				{
+					Name = Values.AsString(Values.Get(file, "name")),
+					Checksum = Values.AsString(Values.Get(file, "checksum")),
+					Filename = Values.AsString(Values.Get(file, "filename")),
+					Size = Values.AsLong(Values.Get(file, "size")),
+					SchemaHash = Values.AsString(Values.Get(file, "schema_hash")),
+					Codec = Values.AsString(Values.Get(file, "codec")),
+					PlainChecksum = Values.AsString(Values.Get(file, "plain_checksum")),
+					PlainSize = Values.AsLong(Values.Get(file, "plain_size")),
+				});
+			}
+			return m;
+		}
+	}
+
+	// Values 将解析后的数据转换为字段的类型. 对象为 IDictionary<string, object>, 数组为 IList<object>,
+	// 整数为 long 或 ulong, 小数为 decimal 或 double
+	internal static class Values
+	{
+		private static readonly IList<object> emptyList = new object[0];
+
+		internal static object Get(IDictionary<string, object> obj, string key)
+		{
+			object value;
+			return obj != null && obj.TryGetValue(key, out value) ? value : null;
+		}
+
+		internal static IDictionary<string, object> AsObject(object v)
+		{
+			return v as IDictionary<string, object>;
+		}
+
+		internal static IList<object> AsList(object v)
+		{
+			return v as IList<object> ?? emptyList;
+		}
+
+		internal static T[] AsArray<T>(object v, Func<object, T> read)
+		{
+			var list = AsList(v);
+			var result = new T[list.Count];
+			for (var i = 0; i < result.Length; i++)
+			{
+				result[i] = read(list[i]);
+			}
+			return result;
+		}
+
+		internal static long AsLong(object v)
+		{
+			if (v == null) return 0;
+			if (v is long) return (long)v;
+			if (v is ulong) return unchecked((long)(ulong)v);
+			if (v is double) return (long)(double)v;
+			if (v is decimal) return (long)(decimal)v;
+			if (v is bool) return (bool)v ? 1 : 0;
+			if (v is string) return long.Parse((string)v, NumberStyles.Integer, CultureInfo.InvariantCulture);
+			throw new InvalidCastException("cannot convert " + v.GetType().Name + " to integer");
+		}
+
+		internal static ulong AsULong(object v)
+		{
+			if (v is ulong) return (ulong)v;
+			if (v is string) return ulong.Parse((string)v, NumberStyles.Integer, CultureInfo.InvariantCulture);
+			return unchecked((ulong)AsLong(v));
+		}
+
+		internal static double AsDouble(object v)
+		{
+			if (v == null) return 0;
+			if (v is double) return (double)v;
+			if (v is long) return (long)v;
+			if (v is ulong) return (ulong)v;
+			if (v is decimal) return (double)(decimal)v;
+			if (v is string) return double.Parse((string)v, NumberStyles.Float, CultureInfo.InvariantCulture);
+			throw new InvalidCastException("cannot convert " + v.GetType().Name + " to double");
+		}
+
+		internal static decimal AsDecimal(object v)
+		{
+			if (v == null) return 0;
+			if (v is decimal) return (decimal)v;
+			if (v is long) return (long)v;
+			if (v is ulong) return (ulong)v;
+			if (v is double) return (decimal)(double)v;
+			if (v is string) return decimal.Parse((string)v, NumberStyles.Float, CultureInfo.InvariantCulture);
+			throw new InvalidCastException("cannot convert " + v.GetType().Name + " to decimal");
+		}
+
+		internal static bool AsBool(object v)
+		{
+			if (v is bool) return (bool)v;
+			return AsLong(v) != 0;
+		}
+
+		internal static string AsString(object v)
+		{
+			return v == null ? null : v as string ?? Convert.ToString(v, CultureInfo.InvariantCulture);
+		}
+	}
+
+	// Json 解析 json 数据
+	internal sealed class Json
+	{
+		private readonly string s;
+		private int pos;
+
+		private Json(string s)
+		{
+			this.s = s;
+		}
+
+		internal static object Parse(byte[] data)
+		{
+			var p = new Json(Encoding.UTF8.GetString(data));
+			var value = p.ReadValue();
+			p.SkipSpace();
+			if (p.pos != p.s.Length)
+			{
+				throw p.Error("unexpected data after top-level value");
+			}
+			return value;
+		}
+
+		private FormatException Error(string message)
+		{
+			return new FormatException("json: " + message + " at offset " + pos);
+		}
+
+		private void SkipSpace()
+		{
+			while (pos < s.Length && (s[pos] == ' ' || s[pos] == '\t' || s[pos] == '\n' || s[pos] == '\r'))
+			{
+				pos++;
+			}
+		}
+
+		private void Expect(string literal)
+		{
+			if (string.CompareOrdinal(s, pos, literal, 0, literal.Length) != 0)
+			{
+				throw Error("invalid literal");
+			}
+			pos += literal.Length;
+		}
+
+		private object ReadValue()
+		{
+			SkipSpace();
+			if (pos >= s.Length)
+			{
+				throw Error("unexpected end of data");
+			}
+			switch (s[pos])
+			{
+				case '{':
+					return ReadObject();
+				case '[':
+					return ReadArray();
+				case '"':
+					return ReadString();
+				case 't':
+					Expect("true");
+					return true;
+				case 'f':
+					Expect("false");
+					return false;
+				case 'n':
+					Expect("null");
+					return null;
+				default:
+					return ReadNumber();
+			}
+		}
+
+		private Dictionary<string, object> ReadObject()
+		{
+			var obj = new Dictionary<string, object>();
+			pos++;
+			SkipSpace();
+			if (pos < s.Length && s[pos] == '}')
+			{
+				pos++;
+				return obj;
+			}
+			while (true)
+			{
+				SkipSpace();
+				if (pos >= s.Length || s[pos] != '"')
+				{
+					throw Error("expect string");
+				}
+				var key = ReadString();
+				SkipSpace();
+				if (pos >= s.Length || s[pos] != ':')
+				{
+					throw Error("expect ':'");
+				}
+				pos++;
+				obj[key] = ReadValue();
+				SkipSpace();
+				if (pos < s.Length && s[pos] == ',')
+				{
+					pos++;
+				}
+				else if (pos < s.Length && s[pos] == '}')
+				{
+					pos++;
+					return obj;
+				}
+				else
+				{
+					throw Error("expect ',' or '}'");
+				}
+			}
+		}
+
+		private List<object> ReadArray()
+		{
+			var list = new List<object>();
+			pos++;
+			SkipSpace();
+			if (pos < s.Length && s[pos] == ']')
+			{
+				pos++;
+				return list;
+			}
+			while (true)
+			{
+				list.Add(ReadValue());
+				SkipSpace();
+				if (pos < s.Length && s[pos] == ',')
+				{
+					pos++;
+				}
+				else if (pos < s.Length && s[pos] == ']')
+				{
+					pos++;
+					return list;
+				}
+				else
+				{
+					throw Error("expect ',' or ']'");
+				}
+			}
+		}
+
+		private string ReadString()
+		{
+			var sb = new StringBuilder();
+			pos++;
+			while (true)
+			{
+				if (pos >= s.Length)
+				{
+					throw Error("unterminated string");
+				}
+				var c = s[pos++];
+				if (c == '"')
+				{
+					return sb.ToString();
+				}
+				if (c != '\\')
+				{
+					sb.Append(c);
+					continue;
+				}
+				if (pos >= s.Length)
+				{
+					throw Error("unterminated string");
+				}
+				c = s[pos++];
+				switch (c)
+				{
+					case 'b': sb.Append('\b'); break;
+					case 'f': sb.Append('\f'); break;
+					case 'n': sb.Append('\n'); break;
+					case 'r': sb.Append('\r'); break;
+					case 't': sb.Append('\t'); break;
+					case 'u':
+						if (pos + 4 > s.Length)
+						{
+							throw Error("invalid escape");
+						}
+						sb.Append((char)int.Parse(s.Substring(pos, 4), NumberStyles.HexNumber, CultureInfo.InvariantCulture));
+						pos += 4;
+						break;
+					default: sb.Append(c); break;
+				}
+			}
+		}
+
+		// ReadNumber 整数解析为 long 或 ulong, 没有指数的小数解析为 decimal, 其他解析为 double
+		private object ReadNumber()
+		{
+			var start = pos;
+			var isInteger = true;
+			var hasExponent = false;
+			while (pos < s.Length)
+			{
+				var c = s[pos];
+				if (c == '.')
+				{
+					isInteger = false;
+				}
+				else if (c == 'e' || c == 'E')
+				{
+					isInteger = false;
+					hasExponent = true;
+				}
+				else if (!(c >= '0' && c <= '9') && c != '-' && c != '+')
+				{
+					break;
+				}
+				pos++;
+			}
+			var token = s.Substring(start, pos - start);
+			if (token.Length == 0)
+			{
+				throw Error("invalid character");
+			}
+			if (isInteger)
+			{
+				long l;
+				if (long.TryParse(token, NumberStyles.AllowLeadingSign, CultureInfo.InvariantCulture, out l))
+				{
+					return l;
+				}
+				ulong u;
+				if (ulong.TryParse(token, NumberStyles.None, CultureInfo.InvariantCulture, out u))
+				{
+					return u;
+				}
+			}
+			else if (!hasExponent)
+			{
+				decimal m;
+				if (decimal.TryParse(token, NumberStyles.Float, CultureInfo.InvariantCulture, out m))
+				{
+					return m;
+				}
+			}
+			double d;
+			if (!double.TryParse(token, NumberStyles.Float, CultureInfo.InvariantCulture, out d))
+			{
+				throw Error("invalid number");
+			}
+			return d;
+		}
+	}
+
+	// MsgPack 解析 msgpack 数据
+	internal sealed class MsgPack
+	{
+		private readonly byte[] b;
+		private int pos;
+
+		private MsgPack(byte[] b)
+		{
+			this.b = b;
+		}
+
+		internal static object Unpack(byte[] data)
+		{
+			var p = new MsgPack(data);
+			var value = p.Read();
+			if (p.pos != data.Length)
+			{
+				throw new FormatException("msgpack: unexpected data after top-level value");
+			}
+			return value;
+		}
+
+		private ulong ReadUInt(int n)
+		{
+			if (pos + n > b.Length)
+			{
+				throw new FormatException("msgpack: unexpected end of data");
+			}
+			ulong v = 0;
+			for (var i = 0; i < n; i++)
+			{
+				v = (v << 8) | b[pos++];
+			}
+			return v;
+		}
+
+		private object Read()
+		{
+			var c = (byte)ReadUInt(1);
+			if (c <= 0x7f) return (long)c;
+			if (c >= 0xe0) return (long)(sbyte)c;
+			if ((c & 0xf0) == 0x80) return ReadMap(c & 0x0f);
+			if ((c & 0xf0) == 0x90) return ReadArray(c & 0x0f);
+			if ((c & 0xe0) == 0xa0) return ReadString(c & 0x1f);
+			switch (c)
+			{
+				case 0xc0: return null;
+				case 0xc2: return false;
+				case 0xc3: return true;
+				case 0xc4: return ReadBytes((int)ReadUInt(1));
+				case 0xc5: return ReadBytes((int)ReadUInt(2));
+				case 0xc6: return ReadBytes((int)ReadUInt(4));
+				case 0xca: return (double)BitConverter.ToSingle(BitConverter.GetBytes((int)ReadUInt(4)), 0);
+				case 0xcb: return BitConverter.Int64BitsToDouble((long)ReadUInt(8));
+				case 0xcc: return (long)ReadUInt(1);
+				case 0xcd: return (long)ReadUInt(2);
+				case 0xce: return (long)ReadUInt(4);
+				case 0xcf:
+					var u = ReadUInt(8);
+					return u <= long.MaxValue ? (object)(long)u : u;
+				case 0xd0: return (long)(sbyte)ReadUInt(1);
+				case 0xd1: return (long)(short)ReadUInt(2);
+				case 0xd2: return (long)(int)ReadUInt(4);
+				case 0xd3: return (long)ReadUInt(8);
+				case 0xd9: return ReadString((int)ReadUInt(1));
+				case 0xda: return ReadString((int)ReadUInt(2));
+				case 0xdb: return ReadString((int)ReadUInt(4));
+				case 0xdc: return ReadArray((int)ReadUInt(2));
+				case 0xdd: return ReadArray((int)ReadUInt(4));
+				case 0xde: return ReadMap((int)ReadUInt(2));
+				case 0xdf: return ReadMap((int)ReadUInt(4));
+			}
+			throw new FormatException("msgpack: unsupported type 0x" + c.ToString("x2"));
+		}
+
+		private byte[] ReadBytes(int n)
+		{
+			if (pos + n > b.Length)
+			{
+				throw new FormatException("msgpack: unexpected end of data");
+			}
+			var data = new byte[n];
+			Array.Copy(b, pos, data, 0, n);
+			pos += n;
+			return data;
+		}
+
+		private string ReadString(int n)
+		{
+			return Encoding.UTF8.GetString(ReadBytes(n));
+		}
+
+		private List<object> ReadArray(int n)
+		{
+			var list = new List<object>(n);
+			for (var i = 0; i < n; i++)
+			{
+				list.Add(Read());
+			}
+			return list;
+		}
+
+		private Dictionary<string, object> ReadMap(int n)
+		{
+			var obj = new Dictionary<string, object>(n);
+			for (var i = 0; i < n; i++)
+			{
+				var key = Read() as string;
+				if (key == null)
+				{
+					throw new FormatException("msgpack: map key must be string");
+				}
+				obj[key] = Read();
+			}
+			return obj;
+		}
+	}
+`
+
+const csRegistryRuntime = `
+		private static readonly List<Action<Registry>> validators = new List<Action<Registry>>();
+
+		/// <summary>加载的清单, LoadDir 加载时为 null</summary>
+		public Manifest Manifest { get; private set; }
+
+		/// <summary>添加所有表格加载后调用的检查函数, 抛出异常时加载失败</summary>
+		public static void AddValidator(Action<Registry> v)
+		{
+			lock (validators)
+			{
+				validators.Add(v);
+			}
+		}
+
+		/// <summary>
+		/// 加载清单引用的所有表格, manifest 为清单文件的内容, read 读取表格文件, 文件不存在时返回 null.
+		/// 检查各文件的大小和 sha256, 清单中表格的结构与生成代码时不同时抛出异常
+		/// </summary>
+		public static Registry LoadManifest(byte[] manifest, Func<string, byte[]> read)
+		{
+			var m = Manifest.Parse(manifest);
+			var files = new Dictionary<string, ManifestFile>();
+			foreach (var info in m.Files)
+			{
+				files[info.Name] = info;
+			}
+			var r = new Registry { Manifest = m };
+			r.Load((name, schemaHash) =>
+			{
+				ManifestFile info;
+				if (!files.TryGetValue(name, out info))
+				{
+					return null;
+				}
+				if (!string.IsNullOrEmpty(info.SchemaHash) && info.SchemaHash != schemaHash)
+				{
+					throw new InvalidDataException("schema changed, regenerate the code");
+				}
+				var data = read(info.Filename);
+				if (data == null)
+				{
+					throw new FileNotFoundException("file " + info.Filename + " not found", info.Filename);
+				}
+				CheckFile(info.Filename, data, info.Checksum, info.Size);
+				if (string.IsNullOrEmpty(info.Codec))
+				{
+					return data;
+				}
+				data = Decode(info.Filename, data, info.Codec);
+				CheckFile(info.Filename + " (decoded)", data, info.PlainChecksum, info.PlainSize);
+				return data;
+			});
+			return r;
+		}
+
+		/// <summary>加载所有表格文件 &lt;Table&gt;.json, 用于没有配置清单的导出目标. read 读取表格文件, 文件不存在时返回 null</summary>
+		public static Registry LoadDir(Func<string, byte[]> read)
+		{
+			var r = new Registry();
+			r.Load((name, schemaHash) =>
+			{
+				var filename = name + fileExt;
+				var data = read(filename);
+				if (data == null || fileCodec == "")
+				{
+					return data;
+				}
+				return Decode(filename, data, fileCodec);
+			});
+			return r;
+		}
+
+		// Load 用 read 读取并加载所有表格, 然后调用检查函数. read 返回 null 表示表格没有导出
+		private void Load(Func<string, string, byte[]> read)
+		{
+			foreach (var t in tables)
+			{
+				try
+				{
+					var data = read(t[0], t[1]);
+					if (data != null)
+					{
+						LoadTable(t[0], Unmarshal(data));
+					}
+				}
+				catch (Exception e)
+				{
+					throw new InvalidDataException("load table " + t[0] + " error: " + e.Message, e);
+				}
+			}
+			ValidateRows();
+			Action<Registry>[] vs;
+			lock (validators)
+			{
+				vs = validators.ToArray();
+			}
+			foreach (var v in vs)
+			{
+				v(this);
+			}
+		}
+
+		private static void CheckFile(string name, byte[] data, string checksum, long size)
+		{
+			if (size > 0 && data.Length != size)
+			{
+				throw new InvalidDataException("file " + name + " size mismatch: expected " + size + ", got " + data.Length);
+			}
+			byte[] hash;
+			using (var sha256 = SHA256.Create())
+			{
+				hash = sha256.ComputeHash(data);
+			}
+			var sb = new StringBuilder(hash.Length * 2);
+			foreach (var x in hash)
+			{
+				sb.Append(x.ToString("x2"));
+			}
+			if (sb.ToString() != checksum)
+			{
+				throw new InvalidDataException("file " + name + " checksum mismatch");
+			}
+		}
+
+		// Decode 解码文件, 只支持 gzip
+		private static byte[] Decode(string name, byte[] data, string codec)
+		{
+			if (codec != "gzip")
+			{
+				throw new NotSupportedException("file " + name + ": unsupported codec " + codec);
+			}
+			using (var input = new GZipStream(new MemoryStream(data), CompressionMode.Decompress))
+			using (var output = new MemoryStream())
+			{
+				input.CopyTo(output);
+				return output.ToArray();
+			}
+		}
+	}
+`
@@ -0,0 +1,118 @@
+package xlsx
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const csProject = `<Project Sdk="Microsoft.NET.Sdk">
+  <PropertyGroup>
+    <OutputType>Exe</OutputType>
+    <TargetFramework>net8.0</TargetFramework>
+  </PropertyGroup>
+</Project>
+`
+
+// csLoadTest 用生成的代码加载导出的数据, 篡改的文件应该加载失败
+const csLoadTest = `using System;
+using System.IO;
+using Golden;
+
+var dir = args[0];
+var manifest = File.ReadAllBytes(Path.Combine(dir, "client.manifest.json"));
+Func<string, byte[]> read = f => File.ReadAllBytes(Path.Combine(dir, "client", f));
+var r = Registry.LoadManifest(manifest, read);
+Check(r.Item.Count == 5, "got " + r.Item.Count + " items");
+var item = r.Item.Get(9007199254740993);
+Check(item != null && item.Name == "<b>&\"q\"" && item.Color == Color.Blue && item.Attrs[1].B == "z" && item.Pos.X == -1 && item.Ratio == 1.5f, "unexpected item");
+Check(r.Global.Row.Level == 3 && r.Global.Row.Title == "hello", "unexpected global");
+
+string error = null;
+try
+{
+	Registry.LoadManifest(manifest, f =>
+	{
+		var data = read(f);
+		data[data.Length - 2] ^= 1;
+		return data;
+	});
+}
+catch (Exception e)
+{
+	error = e.Message;
+}
+Check(error != null && error.Contains("checksum mismatch"), "got error " + error);
+
+static void Check(bool ok, string message)
+{
+	if (!ok)
+	{
+		Console.Error.WriteLine(message);
+		Environment.Exit(1);
+	}
+}
+`
+
+// TestCSharp 生成 c# 代码, 检查声明, 有 dotnet 时编译并加载导出的 json 和 msgpack 数据
+func TestCSharp(t *testing.T) {
+	for _, format := range []string{"", formatMsgpack} {
+		outdir := t.TempDir()
+		csDir := filepath.Join(outdir, "cs")
+		cfg := &Config{
+			XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+			Outdir:  outdir,
+			Cache:   "-",
+			Exports: map[string]*ExportConfig{"client": {
+				Format:   format,
+				Manifest: filepath.Join(outdir, "client.manifest.json"),
+				CSharp:   &CSharpConfig{Dir: csDir},
+			}},
+		}
+		if err := ExportJSON(goldenPackage(), cfg); err != nil {
+			t.Fatalf("format %q: %v", format, err)
+		}
+		tables, err := os.ReadFile(filepath.Join(csDir, "Tables.cs"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range []string{
+			"namespace Golden\n",
+			"\t\tBlue = 3,\n",
+			"\t\tpublic long Id;\n",
+			"\t\tpublic Attr[] Attrs;\n",
+			"\t\tpublic float Ratio;\n",
+			"\t\tpublic Item Get(long key)\n",
+		} {
+			if !bytes.Contains(tables, []byte(line)) {
+				t.Errorf("format %q: Tables.cs does not contain %q", format, line)
+			}
+		}
+		for _, s := range []string{"Stone", "Hidden"} {
+			if bytes.Contains(tables, []byte(s)) {
+				t.Errorf("format %q: Tables.cs contains %s", format, s)
+			}
+		}
+
+		if testing.Short() {
+			continue
+		}
+		dotnet, err := exec.LookPath("dotnet")
+		if err != nil {
+			t.Skip("dotnet command not found")
+		}
+		for name, content := range map[string]string{"Check.csproj": csProject, "Program.cs": csLoadTest} {
+			if err := os.WriteFile(filepath.Join(csDir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cmd := exec.Command(dotnet, "run", "--", outdir)
+		cmd.Dir = csDir
+		cmd.Env = append(os.Environ(), "DOTNET_CLI_TELEMETRY_OPTOUT=1", "DOTNET_NOLOGO=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("format %q: dotnet run error: %v\n%s", format, err, out)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package xlsx
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/midlang/mid/src/mid/build"
+
+	"github.com/jokgame/tools/autoconf/codec"
+)
+
+// go 代码, 用于服务器加载导出目标的 json 数据. 配置了 go 的导出目标在 go.dir 中生成两个文件:
+//
+//	autoconf.go  加载函数 LoadManifest 和 LoadDir, 检查函数 AddValidator
+//	tables.go    枚举, 结构体, 各协议的表格类型和包含所有表格的 Registry
+//
+// 结构体的字段与导出的 json 一一对应: 字段名为首字母大写的字段名, json 名为 name 标签,
+// 继承的结构体为嵌入字段. 表格按 key 字段和 index 标签的字段建立索引.
+// 行数据类型实现了 Validate(*Registry) error 时, 所有表格加载后对每一行调用
+
+// goGenerator 生成一个导出目标的 go 代码
+type goGenerator struct {
+	pkg           *build.Package
+	export        string
+	int64AsString bool
+	tables        []*schemaTable
+	// 需要生成的枚举和结构体
+	beans []*build.Bean
+}
+
+// stageGoFiles 为配置了 go 的导出目标生成 go 代码
+func stageGoFiles(pkg *build.Package, cfg *Config, st *stage) error {
+	for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+		return exportConfig.Go != nil
+	}) {
+		exportConfig := cfg.Export(export)
+		g := &goGenerator{
+			pkg:           pkg,
+			export:        export,
+			int64AsString: exportConfig.Int64AsString,
+		}
+		var err error
+		if g.tables, g.beans, err = schemaTablesOf(pkg, export); err != nil {
+			return fmt.Errorf("generate go of %s error: %w", export, err)
+		}
+		for _, t := range g.tables {
+			if t.bean.Name == "Manifest" {
+				return fmt.Errorf("generate go of %s error: protocol name %s conflicts with Registry.Manifest", export, t.bean.Name)
+			}
+		}
+		codecName := codec.Join(exportConfig.Compress, exportConfig.EncryptionKey != "")
+		runtime, err := g.runtime(exportConfig.Go.packageName(), ".json"+codec.Extension(codecName), codecName)
+		if err != nil {
+			return fmt.Errorf("generate go of %s error: %w", export, err)
+		}
+		tables, err := g.generate(exportConfig.Go.packageName())
+		if err != nil {
+			return fmt.Errorf("generate go of %s error: %w", export, err)
+		}
+		if err := st.writeFile(filepath.Join(exportConfig.Go.Dir, "autoconf.go"), runtime, stageGenerated); err != nil {
+			return err
+		}
+		if err := st.writeFile(filepath.Join(exportConfig.Go.Dir, "tables.go"), tables, stageGenerated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goFieldName 返回字段在 go 结构体中的名字
+func goFieldName(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// goType 返回字段的 go 类型
+func (g *goGenerator) goType(f *schemaField) string {
+	var typ string
+	switch f.kind {
+	case kindEnum, kindStruct:
+		typ = f.bean.Name
+	case kindDecimal:
+		typ = "json.Number"
+	default:
+		typ = f.basic
+		if g.int64AsString && (typ == "int" || typ == "int64") {
+			typ = "Int64"
+		} else if g.int64AsString && (typ == "uint" || typ == "uint64") {
+			typ = "Uint64"
+		}
+	}
+	if f.size > 0 {
+		if sparseOf(f.field, optionalOf(f.field)) == sparseKeep {
+			return fmt.Sprintf("[%d]%s", f.size, typ)
+		}
+		return "[]" + typ
+	}
+	return typ
+}
+
+// generate 生成 tables.go
+func (g *goGenerator) generate(pkgName string) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, bean := range g.beans {
+		if bean.Kind == "enum" {
+			g.writeEnum(&buf, bean)
+		} else if err := g.writeStruct(&buf, bean); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range g.tables {
+		g.writeTable(&buf, t)
+	}
+	g.writeRegistry(&buf)
+
+	// 只导入用到的包
+	var imports []string
+	for _, path := range []string{"encoding/json", "fmt"} {
+		if bytes.Contains(buf.Bytes(), []byte(filepath.Base(path)+".")) {
+			imports = append(imports, path)
+		}
+	}
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "// Code generated by autoconf. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkgName)
+	for _, path := range imports {
+		fmt.Fprintf(&header, "\t%q\n", path)
+	}
+	header.WriteString("\n\t\"github.com/jokgame/tools/autoconf/manifest\"\n)\n")
+	return formatGo(append(header.Bytes(), buf.Bytes()...))
+}
+
+func formatGo(src []byte) ([]byte, error) {
+	content, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("format go source error: %w", err)
+	}
+	return content, nil
+}
+
+func writeGoComment(buf *bytes.Buffer, name, comment string) {
+	if comment = getCommentContent(comment); comment != "" {
+		fmt.Fprintf(buf, "// %s %s\n", name, comment)
+	}
+}
+
+func (g *goGenerator) writeEnum(buf *bytes.Buffer, bean *build.Bean) {
+	buf.WriteString("\n")
+	writeGoComment(buf, bean.Name, bean.Comment)
+	fmt.Fprintf(buf, "type %s int\n\nconst (\n", bean.Name)
+	values := enumValuesOf(bean)
+	for _, v := range values {
+		fmt.Fprintf(buf, "\t%s_%s %s = %d", bean.Name, v.name, bean.Name, v.value)
+		if v.desc != v.name {
+			fmt.Fprintf(buf, " // %s", v.desc)
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString(")\n\n")
+	fmt.Fprintf(buf, "func (x %s) String() string {\n\tswitch x {\n", bean.Name)
+	seen := make(map[int]bool)
+	for _, v := range values {
+		if seen[v.value] {
+			continue
+		}
+		seen[v.value] = true
+		fmt.Fprintf(buf, "\tcase %s_%s:\n\t\treturn %q\n", bean.Name, v.name, v.name)
+	}
+	fmt.Fprintf(buf, "\t}\n\treturn fmt.Sprintf(\"%s(%%d)\", int(x))\n}\n", bean.Name)
+}
+
+func (g *goGenerator) writeStruct(buf *bytes.Buffer, bean *build.Bean) error {
+	fields, err := schemaFieldsOfBean(g.pkg, bean, g.export)
+	if err != nil {
+		return err
+	}
+	own := make(map[*build.Field]bool, len(bean.Fields))
+	for _, f := range bean.Fields {
+		own[f] = true
+	}
+	buf.WriteString("\n")
+	writeGoComment(buf, bean.Name, bean.Comment)
+	fmt.Fprintf(buf, "type %s struct {\n", bean.Name)
+	for _, t := range bean.Extends {
+		fmt.Fprintf(buf, "\t%s\n", t.(*build.StructType).Name)
+	}
+	for _, f := range fields {
+		if !own[f.field] {
+			continue
+		}
+		fmt.Fprintf(buf, "\t%s %s `json:%q`", goFieldName(f.name), g.goType(f), f.key)
+		if comment := getCommentContent(f.field.Comment); comment != "" {
+			fmt.Fprintf(buf, " // %s", comment)
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+	return nil
+}
+
+func (g *goGenerator) writeTable(buf *bytes.Buffer, t *schemaTable) {
+	name := t.bean.Name
+	typ := name + "Table"
+	buf.WriteString("\n")
+	if t.singleton {
+		fmt.Fprintf(buf, "// %s 单例表 %s\ntype %s struct {\n\trow *%s\n}\n\n", typ, name, typ, name)
+		fmt.Fprintf(buf, "// Get 返回表格的数据\nfunc (t *%s) Get() *%s { return t.row }\n\n", typ, name)
+		fmt.Fprintf(buf, "func (t *%s) load(data []byte) error {\n", typ)
+		fmt.Fprintf(buf, "\tvar v struct {\n\t\tRow *%s `json:\"row\"`\n\t}\n", name)
+		buf.WriteString("\tif data != nil {\n\t\tif err := json.Unmarshal(data, &v); err != nil {\n\t\t\treturn err\n\t\t}\n\t}\n")
+		fmt.Fprintf(buf, "\tif v.Row == nil {\n\t\tv.Row = new(%s)\n\t}\n\tt.row = v.Row\n\treturn nil\n}\n\n", name)
+		fmt.Fprintf(buf, "func (t *%s) validate(r *Registry) error {\n", typ)
+		buf.WriteString("\tif v, ok := interface{}(t.row).(rowValidator); ok {\n\t\treturn v.Validate(r)\n\t}\n\treturn nil\n}\n")
+		return
+	}
+
+	fmt.Fprintf(buf, "// %s 表格 %s 的所有行\ntype %s struct {\n\trows []*%s\n", typ, name, typ, name)
+	if t.key != nil {
+		fmt.Fprintf(buf, "\tbyKey map[%s]*%s\n", g.goType(t.key), name)
+	}
+	for _, f := range t.indexes {
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "\tby%s map[%s]*%s\n", goFieldName(f.name), g.goType(f), name)
+		} else {
+			fmt.Fprintf(buf, "\tby%s map[%s][]*%s\n", goFieldName(f.name), g.goType(f), name)
+		}
+	}
+	buf.WriteString("}\n\n")
+
+	fmt.Fprintf(buf, "// Len 返回行数\nfunc (t *%s) Len() int { return len(t.rows) }\n\n", typ)
+	fmt.Fprintf(buf, "// Rows 返回按表格中的顺序排列的所有行\nfunc (t *%s) Rows() []*%s { return t.rows }\n\n", typ, name)
+	if t.key != nil {
+		fmt.Fprintf(buf, "// Get 返回 %s 为 key 的行, 不存在时返回 nil\nfunc (t *%s) Get(key %s) *%s { return t.byKey[key] }\n\n",
+			t.key.key, typ, g.goType(t.key), name)
+	}
+	for _, f := range t.indexes {
+		field := goFieldName(f.name)
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "// GetBy%s 返回 %s 为 v 的行, 不存在时返回 nil\nfunc (t *%s) GetBy%s(v %s) *%s { return t.by%s[v] }\n\n",
+				field, f.key, typ, field, g.goType(f), name, field)
+		} else {
+			fmt.Fprintf(buf, "// GetBy%s 返回 %s 为 v 的所有行\nfunc (t *%s) GetBy%s(v %s) []*%s { return t.by%s[v] }\n\n",
+				field, f.key, typ, field, g.goType(f), name, field)
+		}
+	}
+
+	fmt.Fprintf(buf, "func (t *%s) load(data []byte) error {\n", typ)
+	fmt.Fprintf(buf, "\tvar v struct {\n\t\tRows []*%s `json:\"rows\"`\n\t}\n", name)
+	buf.WriteString("\tif data != nil {\n\t\tif err := json.Unmarshal(data, &v); err != nil {\n\t\t\treturn err\n\t\t}\n\t}\n")
+	buf.WriteString("\tt.rows = v.Rows\n")
+	if t.key != nil {
+		fmt.Fprintf(buf, "\tt.byKey = make(map[%s]*%s, len(v.Rows))\n", g.goType(t.key), name)
+	}
+	for _, f := range t.indexes {
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "\tt.by%s = make(map[%s]*%s, len(v.Rows))\n", goFieldName(f.name), g.goType(f), name)
+		} else {
+			fmt.Fprintf(buf, "\tt.by%s = make(map[%s][]*%s)\n", goFieldName(f.name), g.goType(f), name)
+		}
+	}
+	buf.WriteString("\tfor _, row := range v.Rows {\n")
+	if t.key != nil {
+		fmt.Fprintf(buf, "\t\tif _, dup := t.byKey[row.%s]; dup {\n\t\t\treturn fmt.Errorf(\"%s %%v duplicated\", row.%s)\n\t\t}\n",
+			goFieldName(t.key.name), t.key.key, goFieldName(t.key.name))
+		fmt.Fprintf(buf, "\t\tt.byKey[row.%s] = row\n", goFieldName(t.key.name))
+	}
+	for _, f := range t.indexes {
+		field := goFieldName(f.name)
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "\t\tif _, dup := t.by%s[row.%s]; dup {\n\t\t\treturn fmt.Errorf(\"%s %%v duplicated\", row.%s)\n\t\t}\n",
+				field, field, f.key, field)
+			fmt.Fprintf(buf, "\t\tt.by%s[row.%s] = row\n", field, field)
+		} else {
+			fmt.Fprintf(buf, "\t\tt.by%s[row.%s] = append(t.by%s[row.%s], row)\n", field, field, field, field)
+		}
+	}
+	buf.WriteString("\t}\n\treturn nil\n}\n\n")
+
+	fmt.Fprintf(buf, "func (t *%s) validate(r *Registry) error {\n", typ)
+	buf.WriteString("\tfor i, row := range t.rows {\n\t\tif v, ok := interface{}(row).(rowValidator); ok {\n\t\t\tif err := v.Validate(r); err != nil {\n")
+	buf.WriteString("\t\t\t\treturn fmt.Errorf(\"row %d: %w\", i+1, err)\n\t\t\t}\n\t\t}\n\t}\n\treturn nil\n}\n")
+}
+
+func (g *goGenerator) writeRegistry(buf *bytes.Buffer) {
+	buf.WriteString("\n// Registry 所有表格, 没有导出的表格为空表\ntype Registry struct {\n")
+	buf.WriteString("\t// 加载的清单, LoadDir 加载时为 nil\n\tManifest *manifest.Manifest\n\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(buf, "\t%s *%sTable\n", t.bean.Name, t.bean.Name)
+	}
+	buf.WriteString("}\n\n// tables 返回所有表格的名字, 表格结构的哈希和表格\nfunc (r *Registry) tables() []registryTable {\n")
+	buf.WriteString("\treturn []registryTable{\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(buf, "\t\t{%q, %q, r.%s},\n", t.bean.Name, t.schemaHash, t.bean.Name)
+	}
+	buf.WriteString("\t}\n}\n\nfunc newRegistry() *Registry {\n\treturn &Registry{\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(buf, "\t\t%s: new(%sTable),\n", t.bean.Name, t.bean.Name)
+	}
+	buf.WriteString("\t}\n}\n")
+}
+
+// runtime 生成 autoconf.go, ext 和 codecName 为导出目标的数据文件的扩展名和编码
+func (g *goGenerator) runtime(pkgName, ext, codecName string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by autoconf. DO NOT EDIT.\n\npackage %s\n", pkgName)
+	imports := goRuntimeImports
+	if g.int64AsString {
+		imports = append(append([]string(nil), imports...), "strconv")
+		sort.Strings(imports)
+	}
+	buf.WriteString("\nimport (\n")
+	for _, path := range imports {
+		fmt.Fprintf(&buf, "\t%q\n", path)
+	}
+	buf.WriteString("\n\t\"github.com/jokgame/tools/autoconf/codec\"\n\t\"github.com/jokgame/tools/autoconf/manifest\"\n\t\"github.com/jokgame/tools/autoconf/verify\"\n)\n")
+	fmt.Fprintf(&buf, "\n// 表格文件的扩展名和编码\nconst (\n\tfileExt = %q\n\tfileCodec = %q\n)\n", ext, codecName)
+	buf.WriteString(goRuntime)
+	if g.int64AsString {
+		buf.WriteString(goInt64Runtime)
+	}
+	return formatGo(buf.Bytes())
+}
+
+var goRuntimeImports = []string{"crypto/ed25519", "fmt", "os", "path/filepath"}
+
+const goRuntime = `
+// Options 加载表格的选项
+type Options struct {
+	// 解密表格的 AES 密钥, 导出目标配置了 encryption_key 时需要
+	Key []byte
+	// 非 nil 时检查清单的签名 <manifest>.sig
+	PublicKey ed25519.PublicKey
+}
+
+// Validator 所有表格加载后检查数据, 返回错误时加载失败
+type Validator func(r *Registry) error
+
+var validators []Validator
+
+// AddValidator 添加所有表格加载后调用的检查函数, 通常在 init 中调用
+func AddValidator(v Validator) {
+	validators = append(validators, v)
+}
+
+// rowValidator 行数据类型可以实现的接口, 所有表格加载后对每一行调用
+type rowValidator interface {
+	Validate(r *Registry) error
+}
+
+type registryTable struct {
+	name       string
+	schemaHash string
+	table      interface {
+		load(data []byte) error
+		validate(r *Registry) error
+	}
+}
+
+// LoadManifest 加载清单 filename 引用的所有表格, 表格文件在目录 dir 中, dir 为空时为清单所在的目录.
+// 检查各文件的 checksum, 清单中表格的结构与生成代码时不同时返回错误
+func LoadManifest(filename, dir string, opts *Options) (*Registry, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var m *manifest.Manifest
+	if opts.PublicKey != nil {
+		sig, err := os.ReadFile(manifest.SignatureFilename(filename))
+		if err != nil {
+			return nil, err
+		}
+		m, err = verify.Manifest(opts.PublicKey, data, sig)
+	} else {
+		m, err = manifest.Parse(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load manifest %s error: %w", filename, err)
+	}
+	if dir == "" {
+		dir = filepath.Dir(filename)
+	}
+	files := make(map[string]*manifest.FileInfo, len(m.Files))
+	for _, info := range m.Files {
+		files[info.Name] = info
+	}
+	r := newRegistry()
+	r.Manifest = m
+	err = r.load(func(name, schemaHash string) ([]byte, error) {
+		info := files[name]
+		if info == nil {
+			return nil, nil
+		}
+		if info.SchemaHash != "" && info.SchemaHash != schemaHash {
+			return nil, fmt.Errorf("schema changed, regenerate the code")
+		}
+		data, err := os.ReadFile(filepath.Join(dir, info.Filename))
+		if err != nil {
+			return nil, err
+		}
+		return codec.DecodeFile(info, data, opts.Key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadDir 加载目录 dir 中的所有表格文件 <Table>.json, 用于没有配置清单的导出目标
+func LoadDir(dir string, opts *Options) (*Registry, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	r := newRegistry()
+	err := r.load(func(name, _ string) ([]byte, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name+fileExt))
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if fileCodec == "" {
+			return data, nil
+		}
+		return codec.Decode(fileCodec, data, opts.Key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load 用 read 读取并加载所有表格, 然后调用检查函数. read 返回 nil 表示表格没有导出
+func (r *Registry) load(read func(name, schemaHash string) ([]byte, error)) error {
+	tables := r.tables()
+	for _, t := range tables {
+		data, err := read(t.name, t.schemaHash)
+		if err == nil {
+			err = t.table.load(data)
+		}
+		if err != nil {
+			return fmt.Errorf("load table %s error: %w", t.name, err)
+		}
+	}
+	for _, t := range tables {
+		if err := t.table.validate(r); err != nil {
+			return fmt.Errorf("validate table %s error: %w", t.name, err)
+		}
+	}
+	for _, v := range validators {
+		if err := v(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+`
+
+const goInt64Runtime = `
+// Int64 int64_as_string 导出为字符串的 int64
+type Int64 int64
+
+func (i *Int64) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	*i = Int64(v)
+	return err
+}
+
+// Uint64 int64_as_string 导出为字符串的 uint64
+type Uint64 uint64
+
+func (i *Uint64) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	*i = Uint64(v)
+	return err
+}
+`
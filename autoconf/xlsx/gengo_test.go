@@ -0,0 +1,95 @@
+package xlsx
+
+import (
+	"bytes"
+	"go/format"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// goLoadTest 在生成的包中加载导出的数据
+const goLoadTest = `package server
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	r, err := LoadManifest("../out/server.manifest.json", "../out/server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := r.Item.Len(); n != 5 {
+		t.Errorf("got %d items, want 5", n)
+	}
+	if item := r.Item.Get(9007199254740993); item == nil || item.Name != "<b>&\"q\"" || item.Attrs[1].A != 5 || item.Color != Color_Blue {
+		t.Errorf("got item %+v", item)
+	}
+	if stone := r.Stone.Get(2003); stone == nil || stone.Shade != 9 || stone.Weight != 4294967295 {
+		t.Errorf("got stone %+v", stone)
+	}
+	if global := r.Global.Get(); global.Level != 3 || global.Title != "hello" {
+		t.Errorf("got global %+v", global)
+	}
+}
+`
+
+// TestGoCompile 导出 testdata/golden 中的表格并生成 go 代码, 检查生成的代码已经格式化,
+// 能通过 go vet, 并且能加载导出的数据
+func TestGoCompile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip compiling generated code in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	for _, tt := range []struct {
+		name          string
+		int64AsString bool
+		compress      string
+	}{
+		{"json", false, ""},
+		{"int64 as string", true, "gzip"},
+	} {
+		// 生成的包在当前模块中才能导入 autoconf 的包, 以 _ 开头的目录不属于 ./...
+		dir, err := os.MkdirTemp(".", "_gengo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		outdir := filepath.Join(dir, "out")
+		cfg := &Config{
+			XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+			Outdir:  outdir,
+			Cache:   "-",
+			Exports: map[string]*ExportConfig{"server": {
+				Manifest:      filepath.Join(outdir, "server.manifest.json"),
+				Int64AsString: tt.int64AsString,
+				Compress:      tt.compress,
+				Go:            &GoConfig{Dir: filepath.Join(dir, "server")},
+			}},
+		}
+		if err := ExportJSON(goldenPackage(), cfg); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, name := range []string{"autoconf.go", "tables.go"} {
+			src, err := os.ReadFile(filepath.Join(dir, "server", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if formatted, err := format.Source(src); err != nil || !bytes.Equal(formatted, src) {
+				t.Errorf("%s: %s is not formatted: %v", tt.name, name, err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, "server", "load_test.go"), []byte(goLoadTest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, args := range [][]string{{"vet"}, {"test", "-count=1"}} {
+			cmd := exec.Command(goBin, append(args, "./"+filepath.ToSlash(filepath.Join(dir, "server")))...)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("%s: go %s error: %v\n%s", tt.name, args[0], err, out)
+			}
+		}
+	}
+}
@@ -1,33 +1,58 @@
 package xlsx
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"html/template"
+	"hash"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
-	"github.com/360EntSecGroup-Skylar/excelize"
 	"github.com/midlang/mid/src/mid/build"
+
+	"github.com/jokgame/tools/autoconf/codec"
+	"github.com/jokgame/tools/autoconf/manifest"
 )
 
-type FileInfo struct {
-	Name     string `json:"name"`
-	Checksum string `json:"checksum"`
-	Filename string `json:"filename"`
-}
+// FileInfo 清单中的一个文件
+type FileInfo = manifest.FileInfo
 
+// GenerateJSON midc 插件入口, 加载配置后调用 ExportJSON
 func GenerateJSON(plugin build.Plugin, config build.PluginRuntimeConfig, pkg *build.Package) error {
-	var exportedFiles = make(map[string][]*FileInfo)
+	cfg, err := loadConfigWithEnv(config.Envvars)
+	if err != nil {
+		return err
+	}
+	if config.Outdir != "" {
+		cfg.Outdir = config.Outdir
+	}
+	return ExportJSON(pkg, cfg)
+}
+
+// ExportJSON 将 pkg 中所有协议的 excel 表格导出为 json 文件.
+// 各协议的表格由 cfg.Jobs 个 goroutine 并发导出, 各表格互不依赖, 导出时只检查表格本身,
+// 协议名重复等跨表格的检查在导出前完成.
+// 所有输出文件先写入暂存目录, 没有错误时才替换目标文件, 出错时不修改任何目标文件.
+// 出错时返回按协议声明顺序的第一个错误, cfg.KeepGoing 为 true 时返回包含所有错误的 ExportErrors
+func ExportJSON(pkg *build.Package, cfg *Config) error {
+	if err := checkCodegenTables(pkg, cfg); err != nil {
+		return err
+	}
+	logUnusedTemplates(cfg)
+	st := newStage()
+	defer st.discard()
+
+	var jobs []*exportJob
 	var names = make(map[string]bool)
-	var errorsTable = config.Getenv("errors-table")
-	var stringsTable = config.Getenv("strings-table")
 	for _, file := range pkg.Files {
-		dir := filepath.Join(config.Getenv("xlsxdir"), trimFilenameSuffix(filepath.Base(file.Filename)))
+		dir := filepath.Join(cfg.XlsxDir, trimFilenameSuffix(filepath.Base(file.Filename)))
 		for _, bean := range file.Beans {
 			if bean.Kind != "protocol" {
 				continue
@@ -39,191 +64,617 @@ func GenerateJSON(plugin build.Plugin, config build.PluginRuntimeConfig, pkg *bu
 				return fmt.Errorf("protocol %s duplicated", bean.Name)
 			}
 			names[bean.Name] = true
-			filename := filepath.Join(dir, bean.Name+excelSuffix)
-			sheetName := defaultSheetName
-			file, err := excelize.OpenFile(filename)
-			if err != nil {
-				if os.IsNotExist(err) {
-					log.Printf("excel file '%s' not found", filename)
-					continue
-				} else {
-					return err
-				}
-			}
+			jobs = append(jobs, &exportJob{
+				bean:     bean,
+				filename: filepath.Join(dir, bean.Name+excelSuffix),
+			})
+		}
+	}
 
-			tagExports := bean.GetTag("export")
-			exports := strings.Split(tagExports, ",")
-			if tagExports == "" {
-				exports = defaultExports
-			}
-			rows := file.GetRows(sheetName)
-			if len(rows) < 2 {
-				log.Printf("empty excel file '%s'", filename)
-				continue
+	// 并发导出各协议的表格, 没有变化的表格使用缓存的结果
+	var cache *exportCache
+	cacheFile := cfg.cacheFile()
+	if cacheFile != "" {
+		cache = loadCache(cacheFile)
+	}
+	runExportJobs(pkg, cfg, st, cache, jobs)
+	var errs ExportErrors
+	for _, job := range jobs {
+		if job.err != nil {
+			if !cfg.KeepGoing {
+				return job.err
 			}
+			errs = append(errs, job.err)
+			continue
+		}
+		if job.skipped != "" {
+			log.Print(job.skipped)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
 
-			comments := getComments(file, sheetName)
-
-			values := make([]interface{}, 0, len(rows)-2)
-			indexes := make(map[string]int)
-			nodes := buildJSONNodes(nil, pkg, bean, comments, rows[0])
-			nodes[0].sort(pkg)
-			for i := 2; i < len(rows); i++ {
-				var row = rows[i]
-				for j := 0; j+1 < len(nodes); j++ {
-					if j < len(row) {
-						nodes[j+1].data = row[j]
-					} else {
-						nodes[j+1].data = ""
-					}
-				}
-				key := strings.TrimSpace(nodes[0].Key(pkg))
-				value := nodes[0].Value(pkg, false)
-				if key != "" && value != nil {
-					if _, dup := indexes[key]; dup {
-						return fmt.Errorf("id %q duplicated in table %s", key, bean.Name)
-					}
-					indexes[key] = len(values)
-					values = append(values, value)
+	var exportedFiles = make(map[string][]*FileInfo)
+	for _, job := range jobs {
+		var schema string
+		for _, file := range job.exported {
+			if file.Manifest {
+				if schema == "" {
+					schema = schemaHash(pkg, job.bean)
 				}
+				exportedFiles[file.Export] = append(exportedFiles[file.Export], &FileInfo{
+					Name:          job.bean.Name,
+					Checksum:      file.Checksum,
+					Filename:      filepath.Base(file.Path),
+					Size:          file.Size,
+					SchemaHash:    schema,
+					Codec:         file.Codec,
+					PlainChecksum: file.PlainChecksum,
+					PlainSize:     file.PlainSize,
+				})
 			}
-			var singleton bool
-			var result interface{}
-			if tagSingleton := bean.GetTag("singleton"); tagSingleton != "" {
-				var err error
-				singleton, err = strconv.ParseBool(tagSingleton)
-				if err != nil {
-					return fmt.Errorf("invalid singleton tag of %s: %w", bean.Name, err)
-				}
+		}
+	}
+	// 根据所有协议生成的文件, 每个步骤只处理配置了相应选项的导出目标
+	steps := []func() error{
+		func() error { return stageProtoFiles(pkg, cfg, st) },
+		func() error { return stageSQLite(pkg, cfg, st, cache, jobs) },
+		func() error { return stageSQLScripts(pkg, cfg, st, cache, jobs) },
+		func() error { return stageGoFiles(pkg, cfg, st) },
+		func() error { return stageTypeScriptFiles(pkg, cfg, st) },
+		func() error { return stageCSharpFiles(pkg, cfg, st) },
+		func() error { return stageJSONSchemaFiles(pkg, cfg, st) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	packs, err := stagePacks(st, cfg, jobs)
+	if err != nil {
+		return err
+	}
+	if err := stageManifests(st, cfg, exportedFiles, packs); err != nil {
+		return err
+	}
+
+	if cache != nil {
+		cache.Tables = make(map[string]*cacheEntry, len(jobs))
+		for _, job := range jobs {
+			if job.entry != nil {
+				cache.Tables[job.bean.Name] = job.entry
 			}
-			if singleton {
-				if len(values) == 0 {
-					result = map[string]interface{}{
-						"row": map[string]interface{}{},
-					}
-				} else if len(values) > 1 {
-					return fmt.Errorf("singleton %s has more than one values", bean.Name)
-				} else {
-					result = map[string]interface{}{
-						"row": values[0],
-					}
+		}
+		if err := cache.save(st, cacheFile); err != nil {
+			return err
+		}
+	}
+	return st.commit()
+}
+
+// ExportErrors keep-going 模式下导出过程中的所有错误
+type ExportErrors []error
+
+func (errs ExportErrors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d errors occurred:", len(errs))
+	for _, err := range errs {
+		b.WriteString("\n\t* ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap 返回所有的错误
+func (errs ExportErrors) Unwrap() []error {
+	return errs
+}
+
+// exportJob 一个协议的导出任务
+type exportJob struct {
+	bean     *build.Bean
+	filename string
+
+	// 导出的 json 文件
+	exported []*exportedFile
+	// 根据 codegen 模板生成的文件
+	outputs []string
+	// 各导出目标的数据库文件中该协议的数据, 使用缓存时为 nil
+	dbRows map[string]*dbRowFiles
+	// 导出结果的缓存
+	entry *cacheEntry
+	// 是否使用了缓存的导出结果
+	cached bool
+	// 跳过该协议的原因
+	skipped string
+	err     error
+}
+
+func (job *exportJob) run(pkg *build.Package, cfg *Config, st *stage, cache *exportCache) {
+	var hash string
+	if cache != nil {
+		var err error
+		hash, err = tableHash(pkg, cfg, job.bean, job.filename)
+		if err != nil && !os.IsNotExist(err) {
+			job.err = fmt.Errorf("convert excel file '%s' error: %w", job.filename, err)
+			return
+		}
+		if entry := cache.lookup(job.bean.Name, hash); entry != nil {
+			entry.restore(job)
+			job.entry = entry
+			job.cached = true
+			return
+		}
+	}
+	sheet, err := openSheet(pkg, job.bean, job.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			job.skipped = fmt.Sprintf("excel file '%s' not found", job.filename)
+		} else if err == ErrEmptySheet {
+			job.skipped = fmt.Sprintf("empty excel file '%s'", job.filename)
+		} else {
+			job.err = fmt.Errorf("convert excel file '%s' error: %w", job.filename, err)
+		}
+		return
+	}
+	defer sheet.Close()
+	job.exported, job.outputs, job.dbRows, err = exportSheet(pkg, cfg, st, sheet, job.bean)
+	if err != nil {
+		job.err = fmt.Errorf("convert excel file '%s' error: %w", job.filename, err)
+		return
+	}
+	if cache != nil {
+		job.entry = newCacheEntry(hash, job)
+	}
+}
+
+// runExportJobs 使用 cfg.jobs() 个 goroutine 执行导出任务.
+// 某个任务出错后, 排在它之后的任务不再执行(keep-going 模式除外), 之前的任务仍然执行,
+// 以保证按顺序找到的第一个错误总是相同的
+func runExportJobs(pkg *build.Package, cfg *Config, st *stage, cache *exportCache, jobs []*exportJob) {
+	var (
+		wg     sync.WaitGroup
+		next   int64 = -1
+		failed       = int64(len(jobs))
+	)
+	n := cfg.jobs()
+	if n > len(jobs) {
+		n = len(jobs)
+	}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := atomic.AddInt64(&next, 1)
+				if i >= int64(len(jobs)) {
+					return
 				}
-			} else {
-				result = map[string]interface{}{
-					"rows": values,
+				if i > atomic.LoadInt64(&failed) {
+					continue
 				}
-				if errorsTable == bean.Name {
-					for _, export := range exports {
-						var templateFilename = config.Getenv("errors-" + export + "-template")
-						var outputFilename = config.Getenv("errors-" + export + "-output")
-						if templateFilename != "" && outputFilename != "" {
-							if err := generateFileByRows(values, templateFilename, outputFilename); err != nil {
-								return fmt.Errorf("generate errors error: %v", err)
-							}
-						}
-					}
+				job := jobs[i]
+				job.run(pkg, cfg, st, cache)
+				if job.err == nil || cfg.KeepGoing {
+					continue
 				}
-				if stringsTable == bean.Name {
-					for _, export := range exports {
-						var templateFilename = config.Getenv("strings-" + export + "-template")
-						var outputFilename = config.Getenv("strings-" + export + "-output")
-						if templateFilename != "" && outputFilename != "" {
-							if err := generateFileByRows(values, templateFilename, outputFilename); err != nil {
-								return fmt.Errorf("generate strings error: %v", err)
-							}
-						}
+				for {
+					old := atomic.LoadInt64(&failed)
+					if i >= old || atomic.CompareAndSwapInt64(&failed, old, i) {
+						break
 					}
 				}
 			}
+		}()
+	}
+	wg.Wait()
+}
+
+// tableOutput 协议在一个导出目标中的数据文件, 先写入临时文件, 全部写完后再重命名
+type tableOutput struct {
+	export        string
+	dir           string
+	manifest      bool
+	int64AsString bool
+	// 移除没有导出到该目标的字段, 为 nil 表示所有字段都导出
+	filter *fieldFilter
+	file   *os.File
+	buf    *bufio.Writer
+	// 写入文件的内容的哈希
+	hash hash.Hash
+	enc  tableEncoder
+	// 数据文件的扩展名, 如 .json
+	ext string
+
+	// 文件的编码, 为空时直接写入 json
+	codec string
+	// 压缩 json, 不压缩时直接写入下一步
+	compressor io.WriteCloser
+	// 加密的密钥, 压缩后的内容先写入 encrypted, 全部写完后再加密
+	key       []byte
+	encrypted *bytes.Buffer
+	// 编码前的 json 内容的哈希和大小
+	plainHash hash.Hash
+	plainSize countWriter
+}
 
-			var data []byte
-			prefix := config.Getenv("jsonpreifx")
-			indent := config.Getenv("jsonindent")
-			if prefix == "" && indent == "" {
-				data, err = json.Marshal(result)
-			} else {
-				data, err = json.MarshalIndent(result, prefix, indent)
+// newTableOutput 在暂存目录中创建协议在导出目标 export 中的数据文件
+func newTableOutput(pkg *build.Package, cfg *Config, st *stage, sheet *SheetReader, bean *build.Bean, export string) (*tableOutput, error) {
+	exportConfig := cfg.Export(export)
+	out := &tableOutput{
+		export:        export,
+		dir:           exportDir(cfg, export),
+		manifest:      exportConfig.Manifest != "",
+		int64AsString: exportConfig.Int64AsString,
+		hash:          sha256.New(),
+		codec:         codec.Join(exportConfig.Compress, exportConfig.EncryptionKey != ""),
+		ext:           formatExtension(exportConfig.Format),
+	}
+	filter, err := newFieldFilter(pkg, bean, export)
+	if err != nil {
+		return nil, err
+	}
+	out.filter = filter
+	if exportConfig.EncryptionKey != "" {
+		key, err := codec.LoadKey(exportConfig.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		out.key = key
+	}
+	file, err := st.create(out.dir, sheet.Name+out.ext+codec.Extension(out.codec))
+	if err != nil {
+		return nil, err
+	}
+	out.file = file
+	var w io.Writer = io.MultiWriter(file, out.hash)
+	if out.key != nil {
+		out.encrypted = new(bytes.Buffer)
+		w = out.encrypted
+	}
+	if out.compressor, err = codec.NewWriter(w, exportConfig.Compress); err != nil {
+		file.Close()
+		return nil, err
+	}
+	w = out.compressor
+	if out.codec != "" {
+		out.plainHash = sha256.New()
+		w = io.MultiWriter(w, out.plainHash, &out.plainSize)
+	}
+	out.buf = bufio.NewWriter(w)
+	switch exportConfig.Format {
+	case formatMsgpack:
+		var spill *os.File
+		if !sheet.Singleton {
+			if spill, err = st.create(out.dir, sheet.Name+out.ext+".rows"); err != nil {
+				file.Close()
+				return nil, err
 			}
-			if err != nil {
-				log.Printf("converting excel file '%s' to json error: %v", filename, err)
-				continue
+		}
+		out.enc = newMsgpackEncoder(out.buf, sheet.Singleton, spill)
+	case formatProtobuf:
+		if out.enc, err = newProtobufEncoder(out.buf, pkg, bean, export); err != nil {
+			file.Close()
+			return nil, err
+		}
+	case formatLua:
+		if out.enc, err = newLuaEncoder(out.buf, pkg, bean, sheet.Singleton, exportConfig.LuaDedup); err != nil {
+			file.Close()
+			return nil, err
+		}
+	default:
+		out.enc = newRowsEncoder(out.buf, cfg.JSON, sheet.Singleton)
+	}
+	return out, nil
+}
+
+// close 写完数据后完成压缩和加密并关闭文件
+func (out *tableOutput) close() error {
+	if err := out.enc.close(); err != nil {
+		return err
+	}
+	err := out.buf.Flush()
+	if err == nil {
+		err = out.compressor.Close()
+	}
+	if err == nil && out.key != nil {
+		var data []byte
+		if data, err = codec.Encrypt(out.key, out.encrypted.Bytes()); err == nil {
+			_, err = io.MultiWriter(out.file, out.hash).Write(data)
+		}
+	}
+	if e := out.file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return fmt.Errorf("write file %s error: %w", out.file.Name(), err)
+	}
+	return nil
+}
+
+// countWriter 统计写入的字节数
+type countWriter int64
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+	return len(p), nil
+}
+
+// exportedFile 协议在一个导出目标中导出的 json 文件
+type exportedFile struct {
+	Export   string `json:"export"`
+	Path     string `json:"path"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+	// 是否写入导出目标的清单
+	Manifest bool `json:"manifest,omitempty"`
+	// 文件的编码及编码前的 json 内容的哈希和大小
+	Codec         string `json:"codec,omitempty"`
+	PlainChecksum string `json:"plain_checksum,omitempty"`
+	PlainSize     int64  `json:"plain_size,omitempty"`
+}
+
+// exportDir 返回导出目标的目录
+func exportDir(cfg *Config, export string) string {
+	if dir := cfg.Export(export).Dir; dir != "" {
+		return dir
+	}
+	return filepath.Join(cfg.Outdir, export)
+}
+
+// exportSheet 逐行读取表格并按各导出目标的格式写入数据文件,
+// 返回导出的数据文件和根据 codegen 模板生成的文件
+func exportSheet(pkg *build.Package, cfg *Config, st *stage, sheet *SheetReader, bean *build.Bean) ([]*exportedFile, []string, map[string]*dbRowFiles, error) {
+	exports := exportsOfBean(bean)
+	var (
+		outputs   []*tableOutput
+		dbOutputs []*dbOutput
+		dbRows    = make(map[string]*dbRowFiles)
+	)
+	defer func() {
+		for _, out := range outputs {
+			if out.file != nil {
+				out.file.Close()
 			}
-			exported := map[string]bool{}
-			for _, export := range exports {
-				if exported[export] {
-					continue
-				}
-				exported[export] = true
-				if export == "-" || export == "" {
-					continue
-				}
-				dir := filepath.Join(config.Outdir, export)
-				if exportedDir := config.Getenv("exported-" + export + "-dir"); exportedDir != "" {
-					dir = exportedDir
-				}
-				if err := os.MkdirAll(dir, 0755); err != nil {
-					return fmt.Errorf("mkdirall %s error: %w", dir, err)
-				}
-				var manifest = config.Getenv("manifest-" + export)
-				filename = bean.Name
-				if manifest != "" {
-					checksum := fmt.Sprintf("%02x", sha256.Sum256(data))
-					filename += "." + checksum
-					file := &FileInfo{
-						Name:     bean.Name,
-						Checksum: checksum,
-						Filename: filename + ".json",
-					}
-					exportedFiles[export] = append(exportedFiles[export], file)
+		}
+		for _, out := range dbOutputs {
+			out.file.Close()
+		}
+	}()
+	exported := map[string]bool{}
+	for _, export := range exports {
+		if exported[export] {
+			continue
+		}
+		exported[export] = true
+		if export == "-" || export == "" {
+			continue
+		}
+		out, err := newTableOutput(pkg, cfg, st, sheet, bean, export)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		outputs = append(outputs, out)
+		// sqlite 数据库和 sql 脚本的数据与导出文件一起逐行写入, 不再重新读取表格
+		files := new(dbRowFiles)
+		outs, err := newDBOutputs(pkg, cfg, st, bean, export, files)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(outs) > 0 {
+			dbOutputs = append(dbOutputs, outs...)
+			dbRows[export] = files
+		}
+	}
+
+	// 生成代码的模板需要全部数据
+	var rows []interface{}
+	var templateOutputs []string
+	codegens := codegenOutputsOf(cfg, bean)
+	collect := len(codegens) > 0
+	for {
+		_, value, err := sheet.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, nil, err
+		}
+		if collect {
+			rows = append(rows, value)
+		}
+		// int64/uint64 输出为字符串的数据, 只在有导出目标需要时生成
+		var int64StringValue interface{}
+		for _, out := range outputs {
+			value := value
+			if out.filter != nil {
+				value = out.filter.apply(value)
+				if out.int64AsString {
+					value = int64ToString(value)
 				}
-				filename += ".json"
-				filename = filepath.Join(dir, filename)
-				if err := os.WriteFile(filename, data, 0666); err != nil {
-					return fmt.Errorf("write file %s error: %w", filename, err)
+			} else if out.int64AsString {
+				if int64StringValue == nil {
+					int64StringValue = int64ToString(value)
 				}
+				value = int64StringValue
+			}
+			if err := out.enc.encode(value); err != nil {
+				return nil, nil, nil, err
 			}
 		}
+		for _, out := range dbOutputs {
+			if err := out.enc.encode(value); err != nil {
+				return nil, nil, nil, fmt.Errorf("write %s rows of %s error: %w", out.export, bean.Name, err)
+			}
+		}
+	}
+	for _, out := range dbOutputs {
+		if err := out.close(); err != nil {
+			return nil, nil, nil, err
+		}
 	}
-	for export, files := range exportedFiles {
-		var manifest = config.Getenv("manifest-" + export)
-		var manifestData struct {
-			Files []*FileInfo `json:"files"`
+	for _, c := range codegens {
+		if err := generateCode(pkg, st, bean, c, rows); err != nil {
+			return nil, nil, nil, fmt.Errorf("generate %s error: %w", c.output, err)
 		}
-		manifestData.Files = files
-		var content, err = json.MarshalIndent(manifestData, "", "    ")
+		templateOutputs = append(templateOutputs, c.output)
+	}
+
+	var files []*exportedFile
+	for _, out := range outputs {
+		if err := out.close(); err != nil {
+			return nil, nil, nil, err
+		}
+		tmpfile := out.file.Name()
+		out.file = nil
+		info, err := os.Stat(tmpfile)
 		if err != nil {
-			return fmt.Errorf("marshal manifest error: %w", err)
+			return nil, nil, nil, err
 		}
-		if err := createDirForFile(manifest); err != nil {
-			return err
+		filename := sheet.Name
+		checksum := fmt.Sprintf("%02x", out.hash.Sum(nil))
+		if out.manifest {
+			filename += "." + checksum
+		}
+		filename = filepath.Join(out.dir, filename+out.ext+codec.Extension(out.codec))
+		st.add(tmpfile, filename, stageData)
+		exportedFile := &exportedFile{
+			Export:   out.export,
+			Path:     filename,
+			Checksum: checksum,
+			Size:     info.Size(),
+			Manifest: out.manifest,
+			Codec:    out.codec,
 		}
-		if err := os.WriteFile(manifest, content, 0666); err != nil {
-			return fmt.Errorf("write file %s error: %w", manifest, err)
+		if out.codec != "" {
+			exportedFile.PlainChecksum = fmt.Sprintf("%02x", out.plainHash.Sum(nil))
+			exportedFile.PlainSize = int64(out.plainSize)
 		}
+		files = append(files, exportedFile)
 	}
-	return nil
+	return files, templateOutputs, dbRows, nil
 }
 
-func createDirForFile(filename string) error {
-	dir, _ := filepath.Split(filename)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("mkdirall %s error: %w", dir, err)
+// exportsOfBean 返回协议的导出目标, 由 export 标签指定, 默认为 client 和 server
+func exportsOfBean(bean *build.Bean) []string {
+	tagExports := bean.GetTag("export")
+	if tagExports == "" {
+		return defaultExports
 	}
-	return nil
+	return strings.Split(tagExports, ",")
+}
+
+// protocolsOfExport 返回导出到 export 的所有协议
+func protocolsOfExport(pkg *build.Package, export string) []*build.Bean {
+	var beans []*build.Bean
+	for _, file := range pkg.Files {
+		for _, bean := range file.Beans {
+			if bean.Kind != "protocol" || bean.GetTag("excel") == "false" {
+				continue
+			}
+			for _, e := range exportsOfBean(bean) {
+				if e == export {
+					beans = append(beans, bean)
+					break
+				}
+			}
+		}
+	}
+	return beans
+}
+
+// rowsEncoder 逐行输出表格的 json 数据, 输出与 json.MarshalIndent(table.Value(), prefix, indent) 相同
+type rowsEncoder struct {
+	w         io.Writer
+	config    JSONConfig
+	singleton bool
+	count     int
+	// 单例表的数据, 结束时输出
+	row interface{}
+}
+
+func newRowsEncoder(w io.Writer, config JSONConfig, singleton bool) *rowsEncoder {
+	return &rowsEncoder{
+		w:         w,
+		config:    config,
+		singleton: singleton,
+	}
+}
+
+func (e *rowsEncoder) indented() bool {
+	return e.config.Prefix != "" || e.config.Indent != ""
 }
 
-func generateFileByRows(rows []interface{}, templateFilename, outputFilename string) error {
-	t, err := template.ParseFiles(templateFilename)
+// newline 返回换行并缩进到 depth 层
+func (e *rowsEncoder) newline(depth int) string {
+	if !e.indented() {
+		return ""
+	}
+	return "\n" + e.config.Prefix + strings.Repeat(e.config.Indent, depth)
+}
+
+// marshal 编码第 depth 层的值
+func (e *rowsEncoder) marshal(value interface{}, depth int) ([]byte, error) {
+	if !e.indented() {
+		return json.Marshal(value)
+	}
+	return json.MarshalIndent(value, e.config.Prefix+strings.Repeat(e.config.Indent, depth), e.config.Indent)
+}
+
+func (e *rowsEncoder) write(s string) error {
+	_, err := io.WriteString(e.w, s)
+	return err
+}
+
+func (e *rowsEncoder) encode(value interface{}) error {
+	e.count++
+	if e.singleton {
+		e.row = value
+		return nil
+	}
+	var sep = ","
+	if e.count == 1 {
+		sep = "{" + e.newline(1) + `"rows":`
+		if e.indented() {
+			sep += " "
+		}
+		sep += "["
+	}
+	data, err := e.marshal(value, 2)
 	if err != nil {
 		return err
 	}
-	if err := createDirForFile(outputFilename); err != nil {
+	if err := e.write(sep + e.newline(2)); err != nil {
 		return err
 	}
-	out, err := os.Create(outputFilename)
-	if err != nil {
-		return err
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *rowsEncoder) close() error {
+	var space string
+	if e.indented() {
+		space = " "
+	}
+	if e.singleton {
+		row := e.row
+		if row == nil {
+			row = map[string]interface{}{}
+		}
+		data, err := e.marshal(row, 1)
+		if err != nil {
+			return err
+		}
+		if err := e.write("{" + e.newline(1) + `"row":` + space); err != nil {
+			return err
+		}
+		if _, err := e.w.Write(data); err != nil {
+			return err
+		}
+		return e.write(e.newline(0) + "}")
+	}
+	if e.count == 0 {
+		return e.write("{" + e.newline(1) + `"rows":` + space + "[]" + e.newline(0) + "}")
 	}
-	defer out.Close()
-	return t.Execute(out, rows)
+	return e.write(e.newline(1) + "]" + e.newline(0) + "}")
 }
@@ -0,0 +1,260 @@
+package xlsx
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// json schema, 用于没有协议定义的使用方校验导出的 json 数据. 配置了 json_schema 的导出目标在该目录中
+// 为每个协议生成 <Protocol>.schema.json, 描述 {"rows": [...]} 或单例表的 {"row": {...}}.
+// 协议和结构体在 $defs 中声明(继承的字段展开), 枚举为整数的 enum 列表, 数组的 maxItems 为数组大小,
+// 没有 `optional:"true"` 标签的字段是 required 的
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchemaGenerator 生成一个导出目标的 json schema
+type jsonSchemaGenerator struct {
+	pkg           *build.Package
+	export        string
+	int64AsString bool
+}
+
+// stageJSONSchemaFiles 为配置了 json_schema 的导出目标生成各协议的 json schema
+func stageJSONSchemaFiles(pkg *build.Package, cfg *Config, st *stage) error {
+	for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+		return exportConfig.JSONSchema != ""
+	}) {
+		exportConfig := cfg.Export(export)
+		g := &jsonSchemaGenerator{
+			pkg:           pkg,
+			export:        export,
+			int64AsString: exportConfig.Int64AsString,
+		}
+		tables, _, err := schemaTablesOf(pkg, export)
+		if err != nil {
+			return fmt.Errorf("generate json schema of %s error: %w", export, err)
+		}
+		for _, t := range tables {
+			data, err := g.generate(t)
+			if err != nil {
+				return fmt.Errorf("generate json schema of %s.%s error: %w", export, t.bean.Name, err)
+			}
+			filename := filepath.Join(exportConfig.JSONSchema, t.bean.Name+".schema.json")
+			if err := st.writeFile(filename, data, stageGenerated); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// generate 生成表格 t 的 json schema
+func (g *jsonSchemaGenerator) generate(t *schemaTable) ([]byte, error) {
+	used := make(map[*build.Bean]bool)
+	if err := useSchemaBean(g.pkg, t.bean, g.export, used); err != nil {
+		return nil, err
+	}
+	defs := newObject()
+	for _, file := range g.pkg.Files {
+		for _, bean := range file.Beans {
+			if !used[bean] {
+				continue
+			}
+			def, err := g.beanSchema(bean)
+			if err != nil {
+				return nil, err
+			}
+			defs.set(bean.Name, def)
+		}
+	}
+
+	root := newObject()
+	root.set("$schema", jsonSchemaDraft)
+	root.set("title", t.bean.Name)
+	if comment := getCommentContent(t.bean.Comment); comment != "" {
+		root.set("description", comment)
+	}
+	root.set("type", "object")
+	properties := newObject()
+	if t.singleton {
+		// 没有数据的单例表输出空对象
+		properties.set("row", map[string]interface{}{
+			"anyOf": []interface{}{
+				jsonSchemaRef(t.bean.Name),
+				map[string]interface{}{"type": "object", "maxProperties": 0},
+			},
+		})
+		root.set("properties", properties)
+		root.set("required", []string{"row"})
+	} else {
+		rows := newObject()
+		rows.set("type", "array")
+		rows.set("items", jsonSchemaRef(t.bean.Name))
+		properties.set("rows", rows)
+		root.set("properties", properties)
+		root.set("required", []string{"rows"})
+	}
+	root.set("$defs", defs)
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func jsonSchemaRef(name string) *object {
+	ref := newObject()
+	ref.set("$ref", "#/$defs/"+name)
+	return ref
+}
+
+// beanSchema 返回枚举, 协议或结构体在 $defs 中的定义
+func (g *jsonSchemaGenerator) beanSchema(bean *build.Bean) (*object, error) {
+	def := newObject()
+	if comment := getCommentContent(bean.Comment); comment != "" {
+		def.set("description", comment)
+	}
+	if bean.Kind == "enum" {
+		// 导出时不检查枚举值, 未声明的数字原样输出, 未知的枚举描述输出 0.
+		// schema 只允许声明的枚举值和零值, 用 schema 校验导出数据时可以发现这些单元格
+		var (
+			values = []int{}
+			descs  = []string{}
+		)
+		hasZero := false
+		for _, v := range enumValuesOf(bean) {
+			// 没有值的枚举项不会出现在数据中
+			if v.hasValue {
+				values = append(values, v.value)
+				descs = append(descs, v.desc)
+				hasZero = hasZero || v.value == 0
+			}
+		}
+		if !hasZero {
+			// 未设置的枚举字段输出零值
+			values = append(values, 0)
+			descs = append(descs, "")
+		}
+		def.set("type", "integer")
+		def.set("enum", values)
+		def.set("enumDescriptions", descs)
+		return def, nil
+	}
+	fields, err := schemaFieldsOfBean(g.pkg, bean, g.export)
+	if err != nil {
+		return nil, err
+	}
+	def.set("type", "object")
+	properties := newObject()
+	required := []string{}
+	for _, f := range fields {
+		properties.set(f.key, g.fieldSchema(f))
+		if optionalOf(f.field) != optionalOmit {
+			required = append(required, f.key)
+		}
+	}
+	def.set("properties", properties)
+	def.set("required", required)
+	return def, nil
+}
+
+// fieldSchema 返回字段的 schema
+func (g *jsonSchemaGenerator) fieldSchema(f *schemaField) *object {
+	optional := optionalOf(f.field)
+	var schema *object
+	if f.size > 0 {
+		items := g.valueSchema(f, optional == notOptional)
+		// 可选字段的数组中未设置的元素为 null
+		if optional != notOptional && sparseOf(f.field, optional) != sparseCompact {
+			items = jsonSchemaNullable(items)
+		}
+		schema = newObject()
+		schema.set("type", "array")
+		schema.set("items", items)
+		schema.set("maxItems", f.size)
+	} else {
+		schema = g.valueSchema(f, optional == notOptional)
+	}
+	if optional == optionalNull {
+		schema = jsonSchemaNullable(schema)
+	}
+	if comment := getCommentContent(f.field.Comment); comment != "" {
+		schema.set("description", comment)
+	}
+	return schema
+}
+
+// jsonSchemaIntRanges 整数类型的取值范围, 没有列出的类型不限制
+var jsonSchemaIntRanges = map[string][2]int64{
+	"int8":   {math.MinInt8, math.MaxInt8},
+	"int16":  {math.MinInt16, math.MaxInt16},
+	"int32":  {math.MinInt32, math.MaxInt32},
+	"uint8":  {0, math.MaxUint8},
+	"byte":   {0, math.MaxUint8},
+	"uint16": {0, math.MaxUint16},
+	"uint32": {0, math.MaxUint32},
+}
+
+// valueSchema 返回字段的值(数组为元素)的 schema, zero 表示未设置时输出零值
+func (g *jsonSchemaGenerator) valueSchema(f *schemaField, zero bool) *object {
+	schema := newObject()
+	switch f.kind {
+	case kindStruct:
+		return jsonSchemaRef(f.bean.Name)
+	case kindEnum:
+		ref := jsonSchemaRef(f.bean.Name)
+		if !zero {
+			return ref
+		}
+		for _, v := range enumValuesOf(f.bean) {
+			if v.hasValue && v.value == 0 {
+				return ref
+			}
+		}
+		// 未设置的枚举输出 0
+		zeroValue := newObject()
+		zeroValue.set("const", 0)
+		schema.set("anyOf", []interface{}{ref, zeroValue})
+	case kindBool:
+		schema.set("type", "boolean")
+	case kindString:
+		schema.set("type", "string")
+	case kindFloat, kindDecimal:
+		schema.set("type", "number")
+	case kindInteger:
+		if g.int64AsString && is64BitInteger(f.basic) {
+			schema.set("type", "string")
+			if f.basic == "int" || f.basic == "int64" {
+				schema.set("pattern", "^-?[0-9]+$")
+			} else {
+				schema.set("pattern", "^[0-9]+$")
+			}
+			break
+		}
+		schema.set("type", "integer")
+		if r, ok := jsonSchemaIntRanges[f.basic]; ok {
+			schema.set("minimum", r[0])
+			schema.set("maximum", r[1])
+		} else if f.basic == "uint" || f.basic == "uint64" {
+			schema.set("minimum", 0)
+		}
+	}
+	return schema
+}
+
+// jsonSchemaNullable 返回允许 null 的 schema
+func jsonSchemaNullable(schema *object) *object {
+	if typ, ok := schema.values["type"].(string); ok {
+		schema.set("type", []string{typ, "null"})
+		return schema
+	}
+	null := newObject()
+	null.set("type", "null")
+	nullable := newObject()
+	nullable.set("anyOf", []interface{}{schema, null})
+	return nullable
+}
@@ -0,0 +1,255 @@
+package xlsx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// schemaValidator 校验 json 数据, 只支持生成的 schema 用到的关键字
+type schemaValidator struct {
+	root   map[string]interface{}
+	errors []string
+}
+
+func decodeJSONNumber(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// validateJSONSchema 返回 value 不满足 schema 的所有位置
+func validateJSONSchema(schema, value interface{}) []string {
+	v := &schemaValidator{root: schema.(map[string]interface{})}
+	v.validate(v.root, value, "$")
+	return v.errors
+}
+
+func (v *schemaValidator) errorf(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+func jsonSchemaNumber(value interface{}) *big.Rat {
+	n, ok := value.(json.Number)
+	if !ok {
+		return nil
+	}
+	r, _ := new(big.Rat).SetString(string(n))
+	return r
+}
+
+func jsonSchemaTypeOf(value interface{}) []string {
+	switch x := value.(type) {
+	case nil:
+		return []string{"null"}
+	case bool:
+		return []string{"boolean"}
+	case string:
+		return []string{"string"}
+	case json.Number:
+		if r := jsonSchemaNumber(x); r != nil && r.IsInt() {
+			return []string{"number", "integer"}
+		}
+		return []string{"number"}
+	case []interface{}:
+		return []string{"array"}
+	case map[string]interface{}:
+		return []string{"object"}
+	}
+	return nil
+}
+
+func jsonSchemaEqual(x, y interface{}) bool {
+	if a, b := jsonSchemaNumber(x), jsonSchemaNumber(y); a != nil && b != nil {
+		return a.Cmp(b) == 0
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func (v *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, _ := v.root["$defs"].(map[string]interface{})[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+		if def == nil {
+			v.errorf(path, "unresolved $ref %s", ref)
+		} else {
+			v.validate(def, value, path)
+		}
+	}
+	if typ, ok := schema["type"]; ok {
+		var types []interface{}
+		if s, ok := typ.(string); ok {
+			types = []interface{}{s}
+		} else {
+			types = typ.([]interface{})
+		}
+		matched := false
+		for _, want := range types {
+			for _, got := range jsonSchemaTypeOf(value) {
+				matched = matched || want == got
+			}
+		}
+		if !matched {
+			v.errorf(path, "type %v expected", typ)
+			return
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, x := range enum {
+			found = found || jsonSchemaEqual(x, value)
+		}
+		if !found {
+			v.errorf(path, "value %v not in enum", value)
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonSchemaEqual(c, value) {
+		v.errorf(path, "value %v expected", c)
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range anyOf {
+			errors := v.errors
+			v.validate(sub.(map[string]interface{}), value, path)
+			matched = matched || len(v.errors) == len(errors)
+			v.errors = errors
+		}
+		if !matched {
+			v.errorf(path, "no schema of anyOf matched")
+		}
+	}
+	if n := jsonSchemaNumber(value); n != nil {
+		if min := jsonSchemaNumber(schema["minimum"]); min != nil && n.Cmp(min) < 0 {
+			v.errorf(path, "value %v less than minimum", value)
+		}
+		if max := jsonSchemaNumber(schema["maximum"]); max != nil && n.Cmp(max) > 0 {
+			v.errorf(path, "value %v greater than maximum", value)
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if s, ok := value.(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			v.errorf(path, "value %q does not match %s", s, pattern)
+		}
+	}
+	if array, ok := value.([]interface{}); ok {
+		if max := jsonSchemaNumber(schema["maxItems"]); max != nil && big.NewRat(int64(len(array)), 1).Cmp(max) > 0 {
+			v.errorf(path, "more than %v items", schema["maxItems"])
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, x := range array {
+				v.validate(items, x, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+	if obj, ok := value.(map[string]interface{}); ok {
+		if max := jsonSchemaNumber(schema["maxProperties"]); max != nil && big.NewRat(int64(len(obj)), 1).Cmp(max) > 0 {
+			v.errorf(path, "more than %v properties", schema["maxProperties"])
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, key := range required {
+				if _, ok := obj[key.(string)]; !ok {
+					v.errorf(path, "property %s required", key)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if sub, ok := properties[key].(map[string]interface{}); ok {
+				v.validate(sub, obj[key], path+"."+key)
+			}
+		}
+	}
+}
+
+// TestJSONSchema 用生成的 schema 校验导出的数据, 未声明的枚举值应该校验失败
+func TestJSONSchema(t *testing.T) {
+	outdir := t.TempDir()
+	cfg := &Config{
+		XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+		Outdir:  outdir,
+		Cache:   "-",
+		Exports: map[string]*ExportConfig{
+			"server": {JSONSchema: filepath.Join(outdir, "schema", "server")},
+			"client": {Int64AsString: true, JSONSchema: filepath.Join(outdir, "schema", "client")},
+		},
+	}
+	if err := ExportJSON(goldenPackage(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	readJSON := func(elem ...string) interface{} {
+		data, err := os.ReadFile(filepath.Join(append([]string{outdir}, elem...)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decodeJSONNumber(t, data)
+	}
+	for _, tt := range []struct {
+		export string
+		table  string
+		errors []string
+	}{
+		{"server", "Item", nil},
+		{"server", "Global", nil},
+		// Stone 的 shade 9 不是声明的 Color 值, 也不是零值
+		{"server", "Stone", []string{"$.rows[2].shade: no schema of anyOf matched"}},
+		{"client", "Item", nil},
+		{"client", "Global", nil},
+	} {
+		schema := readJSON("schema", tt.export, tt.table+".schema.json")
+		errors := validateJSONSchema(schema, readJSON(tt.export, tt.table+".json"))
+		if !reflect.DeepEqual(errors, tt.errors) {
+			t.Errorf("%s.%s: got errors %q, want %q", tt.export, tt.table, errors, tt.errors)
+		}
+	}
+	// Stone 只导出到 server
+	if _, err := os.Stat(filepath.Join(outdir, "schema", "client", "Stone.schema.json")); !os.IsNotExist(err) {
+		t.Errorf("client Stone.schema.json: got error %v, want not exist", err)
+	}
+
+	// 不符合 schema 的数据
+	for _, tt := range []struct {
+		export string
+		table  string
+		data   string
+		error  string
+	}{
+		{"server", "Item", `{}`, "$: property rows required"},
+		{"server", "Item", `{"rows":[{"attrs":[],"color":1,"flags":[],"id":1,"name":"","pos":{"x":0,"y":0},"price":0,"ratio":0}]}`, "$.rows[0]: property values required"},
+		{"server", "Item", `{"rows":[{"attrs":[],"color":1,"flags":[],"id":"1","name":"","pos":{"x":0,"y":0},"price":0,"ratio":0,"values":[]}]}`, "$.rows[0].id: type integer expected"},
+		{"server", "Item", `{"rows":[{"attrs":[],"color":1,"flags":[],"id":1,"name":"","pos":{"x":0,"y":0},"price":0,"ratio":0,"values":[1,2,3,4]}]}`, "$.rows[0].values: more than 3 items"},
+		{"server", "Item", `{"rows":[{"attrs":[],"color":1,"flags":[],"id":1,"name":"","pos":{"x":0,"y":0},"price":0,"ratio":0,"values":[2147483648]}]}`, "$.rows[0].values[0]: value 2147483648 greater than maximum"},
+		{"server", "Item", `{"rows":[{"attrs":[],"color":1,"flags":[],"id":1,"name":"","pos":{"x":0.5,"y":0},"price":0,"ratio":0,"values":[]}]}`, "$.rows[0].pos.x: type integer expected"},
+		{"server", "Stone", `{"rows":[{"id":1,"name":"","shade":0,"weight":-1}]}`, "$.rows[0].weight: value -1 less than minimum"},
+		{"server", "Global", `{"row":{"title":""}}`, "$.row: no schema of anyOf matched"},
+		{"client", "Global", `{"row":{"level":3,"title":""}}`, "$.row: no schema of anyOf matched"},
+		{"client", "Global", `{"row":{"level":"3.5","title":""}}`, "$.row: no schema of anyOf matched"},
+	} {
+		schema := readJSON("schema", tt.export, tt.table+".schema.json")
+		errors := validateJSONSchema(schema, decodeJSONNumber(t, []byte(tt.data)))
+		if len(errors) != 1 || errors[0] != tt.error {
+			t.Errorf("%s.%s %s: got errors %q, want %q", tt.export, tt.table, tt.data, errors, tt.error)
+		}
+	}
+	// 空的单例表
+	if errors := validateJSONSchema(readJSON("schema", "server", "Global.schema.json"), decodeJSONNumber(t, []byte(`{"row":{}}`))); len(errors) != 0 {
+		t.Errorf("empty Global: got errors %q", errors)
+	}
+}
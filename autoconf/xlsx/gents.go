@@ -0,0 +1,432 @@
+package xlsx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/midlang/mid/src/mid/build"
+
+	"github.com/jokgame/tools/autoconf/codec"
+)
+
+// typescript 代码, 用于客户端加载导出目标的 json 数据. 配置了 typescript 的导出目标在该目录中生成两个文件:
+//
+//	tables.d.ts  枚举, 结构体, 各协议的表格和加载函数的类型声明
+//	tables.js    枚举对象, 表格和加载函数 loadManifest, loadDir 的实现(ES module)
+//
+// 接口的属性与导出的 json 一一对应: 属性名为 name 标签, 只包含导出到该目标的字段, 继承的结构体用 extends 声明.
+// 枚举为只读的常量对象, 同名的类型为所有枚举值的联合类型. 表格按 key 字段和 index 标签的字段建立索引
+
+// tsGenerator 生成一个导出目标的 typescript 代码
+type tsGenerator struct {
+	pkg           *build.Package
+	export        string
+	int64AsString bool
+	tables        []*schemaTable
+	// 需要生成的枚举和结构体
+	beans []*build.Bean
+}
+
+// stageTypeScriptFiles 为配置了 typescript 的导出目标生成 typescript 代码
+func stageTypeScriptFiles(pkg *build.Package, cfg *Config, st *stage) error {
+	for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+		return exportConfig.TypeScript != ""
+	}) {
+		exportConfig := cfg.Export(export)
+		g := &tsGenerator{
+			pkg:           pkg,
+			export:        export,
+			int64AsString: exportConfig.Int64AsString,
+		}
+		var err error
+		if g.tables, g.beans, err = schemaTablesOf(pkg, export); err != nil {
+			return fmt.Errorf("generate typescript of %s error: %w", export, err)
+		}
+		codecName := codec.Join(exportConfig.Compress, exportConfig.EncryptionKey != "")
+		dir := exportConfig.TypeScript
+		if err := st.writeFile(filepath.Join(dir, "tables.d.ts"), g.declarations(), stageGenerated); err != nil {
+			return err
+		}
+		code := g.code(".json"+codec.Extension(codecName), codecName)
+		if err := st.writeFile(filepath.Join(dir, "tables.js"), code, stageGenerated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// jsString 返回字符串的 javascript 字面量
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// tsProperty 返回对象字面量或接口中的属性名, 不是合法标识符的属性名加引号
+func tsProperty(key string) string {
+	if tsIdentifier.MatchString(key) {
+		return key
+	}
+	return jsString(key)
+}
+
+// jsAccess 返回访问对象 obj 的属性 key 的表达式
+func jsAccess(obj, key string) string {
+	if tsIdentifier.MatchString(key) {
+		return obj + "." + key
+	}
+	return obj + "[" + jsString(key) + "]"
+}
+
+func writeTSComment(buf *bytes.Buffer, indent, comment string) {
+	if comment = getCommentContent(comment); comment != "" {
+		fmt.Fprintf(buf, "%s/** %s */\n", indent, strings.ReplaceAll(comment, "*/", "* /"))
+	}
+}
+
+// tsType 返回字段的值的 typescript 类型
+func (g *tsGenerator) tsType(f *schemaField) string {
+	var typ string
+	switch f.kind {
+	case kindEnum, kindStruct:
+		typ = f.bean.Name
+	case kindBool:
+		typ = "boolean"
+	case kindString:
+		typ = "string"
+	case kindInteger:
+		typ = "number"
+		if g.int64AsString && is64BitInteger(f.basic) {
+			typ = "string"
+		}
+	default:
+		typ = "number"
+	}
+	optional := optionalOf(f.field)
+	if f.size > 0 {
+		// 可选字段的数组中未设置的元素为 null
+		if optional != notOptional && sparseOf(f.field, optional) != sparseCompact {
+			typ = "(" + typ + " | null)"
+		}
+		typ += "[]"
+	}
+	if optional == optionalNull {
+		typ += " | null"
+	}
+	return typ
+}
+
+// tsMethodName 返回按字段 f 查询的方法名
+func tsMethodName(f *schemaField) string {
+	return "getBy" + goFieldName(f.name)
+}
+
+// declarations 生成 tables.d.ts
+func (g *tsGenerator) declarations() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by autoconf. DO NOT EDIT.\n")
+	for _, bean := range g.beans {
+		buf.WriteString("\n")
+		writeTSComment(&buf, "", bean.Comment)
+		if bean.Kind == "enum" {
+			fmt.Fprintf(&buf, "export declare const %s: {\n", bean.Name)
+			for _, v := range enumValuesOf(bean) {
+				if v.desc != v.name {
+					fmt.Fprintf(&buf, "\t/** %s */\n", strings.ReplaceAll(v.desc, "*/", "* /"))
+				}
+				fmt.Fprintf(&buf, "\treadonly %s: %d;\n", tsProperty(v.name), v.value)
+			}
+			fmt.Fprintf(&buf, "};\nexport type %s = (typeof %s)[keyof typeof %s];\n", bean.Name, bean.Name, bean.Name)
+			continue
+		}
+		g.writeInterface(&buf, bean)
+	}
+	for _, t := range g.tables {
+		g.writeTableDeclaration(&buf, t)
+	}
+	buf.WriteString(tsDeclarations)
+	buf.WriteString("\n/** 所有表格, 没有导出的表格为空表 */\nexport declare class Registry {\n")
+	buf.WriteString("\t/** 加载的清单, loadDir 加载时为 undefined */\n\treadonly manifest?: Manifest;\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(&buf, "\treadonly %s: %sTable;\n", tsProperty(t.bean.Name), t.bean.Name)
+	}
+	buf.WriteString("}\n")
+	buf.WriteString(tsLoaderDeclarations)
+	return buf.Bytes()
+}
+
+func (g *tsGenerator) writeInterface(buf *bytes.Buffer, bean *build.Bean) {
+	// schemaTablesOf 已经检查过字段的类型
+	fields, _ := schemaFieldsOfBean(g.pkg, bean, g.export)
+	own := make(map[*build.Field]bool, len(bean.Fields))
+	for _, f := range bean.Fields {
+		own[f] = true
+	}
+	fmt.Fprintf(buf, "export interface %s", bean.Name)
+	for i, t := range bean.Extends {
+		if i == 0 {
+			buf.WriteString(" extends ")
+		} else {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(t.(*build.StructType).Name)
+	}
+	buf.WriteString(" {\n")
+	for _, f := range fields {
+		if !own[f.field] {
+			continue
+		}
+		writeTSComment(buf, "\t", f.field.Comment)
+		name := tsProperty(f.key)
+		if optionalOf(f.field) == optionalOmit {
+			name += "?"
+		}
+		fmt.Fprintf(buf, "\t%s: %s;\n", name, g.tsType(f))
+	}
+	buf.WriteString("}\n")
+}
+
+func (g *tsGenerator) writeTableDeclaration(buf *bytes.Buffer, t *schemaTable) {
+	name := t.bean.Name
+	if t.singleton {
+		fmt.Fprintf(buf, "\n/** 单例表 %s */\nexport declare class %sTable {\n", name, name)
+		fmt.Fprintf(buf, "\t/** 表格的数据 */\n\treadonly row: %s;\n}\n", name)
+		return
+	}
+	fmt.Fprintf(buf, "\n/** 表格 %s 的所有行 */\nexport declare class %sTable {\n", name, name)
+	fmt.Fprintf(buf, "\t/** 按表格中的顺序排列的所有行 */\n\treadonly rows: readonly %s[];\n", name)
+	if t.key != nil {
+		fmt.Fprintf(buf, "\t/** 返回 %s 为 key 的行 */\n\tget(key: %s): %s | undefined;\n", t.key.key, g.tsType(t.key), name)
+	}
+	for _, f := range t.indexes {
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "\t/** 返回 %s 为 value 的行 */\n\t%s(value: %s): %s | undefined;\n", f.key, tsMethodName(f), g.tsType(f), name)
+		} else {
+			fmt.Fprintf(buf, "\t/** 返回 %s 为 value 的所有行 */\n\t%s(value: %s): readonly %s[];\n", f.key, tsMethodName(f), g.tsType(f), name)
+		}
+	}
+	buf.WriteString("}\n")
+}
+
+// code 生成 tables.js, ext 和 codecName 为导出目标的数据文件的扩展名和编码
+func (g *tsGenerator) code(ext, codecName string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by autoconf. DO NOT EDIT.\n")
+	for _, bean := range g.beans {
+		if bean.Kind != "enum" {
+			continue
+		}
+		buf.WriteString("\n")
+		writeTSComment(&buf, "", bean.Comment)
+		fmt.Fprintf(&buf, "export const %s = Object.freeze({\n", bean.Name)
+		for _, v := range enumValuesOf(bean) {
+			fmt.Fprintf(&buf, "\t%s: %d,\n", tsProperty(v.name), v.value)
+		}
+		buf.WriteString("});\n")
+	}
+	for _, t := range g.tables {
+		g.writeTableClass(&buf, t)
+	}
+
+	buf.WriteString("\n/** 所有表格, 没有导出的表格为空表 */\nexport class Registry {\n\tconstructor(manifest, data) {\n\t\tthis.manifest = manifest;\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(&buf, "\t\t%s = new %sTable(%s);\n", jsAccess("this", t.bean.Name), t.bean.Name, jsAccess("data", t.bean.Name))
+	}
+	buf.WriteString("\t}\n}\n\n// 所有表格的名字和表格结构的哈希\nconst tables = [\n")
+	for _, t := range g.tables {
+		fmt.Fprintf(&buf, "\t[%s, %s],\n", jsString(t.bean.Name), jsString(t.schemaHash))
+	}
+	buf.WriteString("];\n")
+	fmt.Fprintf(&buf, "\n// 表格文件的扩展名和编码\nconst fileExt = %s;\nconst fileCodec = %s;\n", jsString(ext), jsString(codecName))
+	buf.WriteString(jsRuntime)
+	return buf.Bytes()
+}
+
+func (g *tsGenerator) writeTableClass(buf *bytes.Buffer, t *schemaTable) {
+	name := t.bean.Name
+	if t.singleton {
+		fmt.Fprintf(buf, "\n/** 单例表 %s */\nexport class %sTable {\n\tconstructor(data) {\n", name, name)
+		buf.WriteString("\t\tthis.row = (data && data.row) || {};\n\t}\n}\n")
+		return
+	}
+	fmt.Fprintf(buf, "\n/** 表格 %s 的所有行 */\nexport class %sTable {\n\tconstructor(data) {\n", name, name)
+	buf.WriteString("\t\tthis.rows = (data && data.rows) || [];\n")
+	if t.key != nil {
+		buf.WriteString("\t\tthis._key = new Map();\n")
+	}
+	for _, f := range t.indexes {
+		fmt.Fprintf(buf, "\t\tthis._by%s = new Map();\n", goFieldName(f.name))
+	}
+	buf.WriteString("\t\tfor (const row of this.rows) {\n")
+	if t.key != nil {
+		value := jsAccess("row", t.key.key)
+		fmt.Fprintf(buf, "\t\t\tif (this._key.has(%s)) {\n", value)
+		fmt.Fprintf(buf, "\t\t\t\tthrow new Error(%s + %s + \" duplicated\");\n", jsString("load table "+name+" error: "+t.key.key+" "), value)
+		fmt.Fprintf(buf, "\t\t\t}\n\t\t\tthis._key.set(%s, row);\n", value)
+	}
+	for _, f := range t.indexes {
+		value := jsAccess("row", f.key)
+		index := "this._by" + goFieldName(f.name)
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "\t\t\tif (%s.has(%s)) {\n", index, value)
+			fmt.Fprintf(buf, "\t\t\t\tthrow new Error(%s + %s + \" duplicated\");\n", jsString("load table "+name+" error: "+f.key+" "), value)
+			fmt.Fprintf(buf, "\t\t\t}\n\t\t\t%s.set(%s, row);\n", index, value)
+		} else {
+			fmt.Fprintf(buf, "\t\t\tif (!%s.has(%s)) {\n\t\t\t\t%s.set(%s, []);\n\t\t\t}\n", index, value, index, value)
+			fmt.Fprintf(buf, "\t\t\t%s.get(%s).push(row);\n", index, value)
+		}
+	}
+	buf.WriteString("\t\t}\n\t}\n")
+	if t.key != nil {
+		buf.WriteString("\n\tget(key) {\n\t\treturn this._key.get(key);\n\t}\n")
+	}
+	for _, f := range t.indexes {
+		fmt.Fprintf(buf, "\n\t%s(value) {\n", tsMethodName(f))
+		if indexOf(f.field) == indexUnique {
+			fmt.Fprintf(buf, "\t\treturn this._by%s.get(value);\n\t}\n", goFieldName(f.name))
+		} else {
+			fmt.Fprintf(buf, "\t\treturn this._by%s.get(value) || [];\n\t}\n", goFieldName(f.name))
+		}
+	}
+	buf.WriteString("}\n")
+}
+
+const tsDeclarations = `
+/** 清单中的一个文件 */
+export interface ManifestFile {
+	name: string;
+	/** 文件内容的 sha256 */
+	checksum: string;
+	filename: string;
+	size?: number;
+	/** 协议定义的哈希 */
+	schema_hash?: string;
+	/** 文件的编码, 为空表示未编码的 json */
+	codec?: string;
+	plain_checksum?: string;
+	plain_size?: number;
+}
+
+/** 导出目标的清单 */
+export interface Manifest {
+	version?: number;
+	build_id?: string;
+	timestamp?: number;
+	root_hash?: string;
+	files: ManifestFile[];
+}
+`
+
+const tsLoaderDeclarations = `
+/** 读取文件的函数, 文件不存在时返回 null 或 undefined */
+export type ReadFile = (filename: string) => Promise<Uint8Array | string | null | undefined>;
+
+/** 所有表格加载后检查数据, 抛出异常时加载失败 */
+export type Validator = (r: Registry) => void;
+
+/** 添加所有表格加载后调用的检查函数 */
+export declare function addValidator(v: Validator): void;
+
+/**
+ * 加载清单引用的所有表格, manifest 为清单或清单文件的内容, 用 read 读取表格文件.
+ * 检查各文件的大小和 sha256(需要 crypto.subtle), 清单中表格的结构与生成代码时不同时抛出异常
+ */
+export declare function loadManifest(manifest: Manifest | string, read: ReadFile): Promise<Registry>;
+
+/** 加载所有表格文件 <Table>.json, 用于没有配置清单的导出目标 */
+export declare function loadDir(read: ReadFile): Promise<Registry>;
+`
+
+const jsRuntime = `
+const validators = [];
+
+export function addValidator(v) {
+	validators.push(v);
+}
+
+function toBytes(data) {
+	return typeof data === "string" ? new TextEncoder().encode(data) : data;
+}
+
+// sha256 返回 data 的 sha256, 不支持 crypto.subtle 时返回 null
+async function sha256(data) {
+	const subtle = globalThis.crypto && globalThis.crypto.subtle;
+	if (!subtle) {
+		return null;
+	}
+	const digest = new Uint8Array(await subtle.digest("SHA-256", data));
+	return Array.from(digest, (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+async function checkFile(info, data) {
+	if (info.size && data.length !== info.size) {
+		throw new Error("file " + info.filename + " size mismatch: expected " + info.size + ", got " + data.length);
+	}
+	const checksum = await sha256(data);
+	if (checksum !== null && checksum !== info.checksum) {
+		throw new Error("file " + info.filename + " checksum mismatch");
+	}
+}
+
+function parse(filename, data, codec) {
+	if (codec) {
+		throw new Error("file " + filename + ": unsupported codec " + codec);
+	}
+	return JSON.parse(typeof data === "string" ? data : new TextDecoder().decode(data));
+}
+
+// load 用 read 读取所有表格, 然后调用检查函数. read 返回 undefined 表示表格没有导出
+async function load(manifest, read) {
+	const data = {};
+	for (const [name, schemaHash] of tables) {
+		data[name] = await read(name, schemaHash);
+	}
+	const r = new Registry(manifest, data);
+	for (const v of validators) {
+		v(r);
+	}
+	return r;
+}
+
+export async function loadManifest(manifest, read) {
+	if (typeof manifest === "string") {
+		manifest = JSON.parse(manifest);
+	}
+	const files = new Map();
+	for (const info of manifest.files) {
+		files.set(info.name, info);
+	}
+	return load(manifest, async (name, schemaHash) => {
+		const info = files.get(name);
+		if (!info) {
+			return undefined;
+		}
+		if (info.schema_hash && info.schema_hash !== schemaHash) {
+			throw new Error("load table " + name + " error: schema changed, regenerate the code");
+		}
+		const content = await read(info.filename);
+		if (content === null || content === undefined) {
+			throw new Error("load table " + name + " error: file " + info.filename + " not found");
+		}
+		const data = toBytes(content);
+		await checkFile(info, data);
+		return parse(info.filename, data, info.codec);
+	});
+}
+
+export async function loadDir(read) {
+	return load(undefined, async (name) => {
+		const filename = name + fileExt;
+		const content = await read(filename);
+		if (content === null || content === undefined) {
+			return undefined;
+		}
+		return parse(filename, content, fileCodec);
+	});
+}
+`
@@ -0,0 +1,104 @@
+package xlsx
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// jsLoadTest 用生成的 tables.js 加载导出的数据, 篡改的文件应该加载失败, 再用 loadDir 加载 testdata/golden/json/client
+const jsLoadTest = `import { readFile } from "node:fs/promises";
+import * as tables from "./ts/tables.js";
+
+function check(ok, message) {
+	if (!ok) {
+		throw new Error(message);
+	}
+}
+
+let validated = false;
+tables.addValidator((r) => {
+	validated = r.Global.row.title === "hello";
+});
+const manifest = await readFile("client.manifest.json", "utf8");
+const read = (filename) => readFile("client/" + filename).catch(() => null);
+const r = await tables.loadManifest(manifest, read);
+check(validated, "validator not called");
+check(r.Item.rows.length === 5, "got " + r.Item.rows.length + " items");
+const item = r.Item.get("9007199254740993");
+check(item && item.color === tables.Color.Blue && item.attrs[1].b === "z" && item.pos.x === "-1", "got item " + JSON.stringify(item));
+check(r.Global.row.level === "3", "got global " + JSON.stringify(r.Global.row));
+
+let error = null;
+await tables.loadManifest(manifest, async (filename) => {
+	const data = await read(filename);
+	data[data.length - 2] ^= 1;
+	return data;
+}).catch((e) => (error = e));
+check(error && error.message.includes("checksum mismatch"), "got error " + error);
+
+const dir = await tables.loadDir((filename) => readFile(process.argv[2] + "/" + filename).catch(() => null));
+check(dir.manifest === undefined && dir.Item.get(1001).name === "剑", "got item " + JSON.stringify(dir.Item.get(1001)));
+`
+
+// TestTypeScript 生成 typescript 代码, 检查声明并用 node 运行生成的 tables.js
+func TestTypeScript(t *testing.T) {
+	outdir := t.TempDir()
+	cfg := &Config{
+		XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+		Outdir:  outdir,
+		Cache:   "-",
+		Exports: map[string]*ExportConfig{"client": {
+			Int64AsString: true,
+			Manifest:      filepath.Join(outdir, "client.manifest.json"),
+			TypeScript:    filepath.Join(outdir, "ts"),
+		}},
+	}
+	if err := ExportJSON(goldenPackage(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	declarations, err := os.ReadFile(filepath.Join(outdir, "ts", "tables.d.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"\treadonly Blue: 3;\n",
+		"export type Color = (typeof Color)[keyof typeof Color];\n",
+		"\tid: string;\n",
+		"\tattrs: Attr[];\n",
+		"\tget(key: string): Item | undefined;\n",
+		"\treadonly row: Global;\n",
+	} {
+		if !bytes.Contains(declarations, []byte(line)) {
+			t.Errorf("tables.d.ts does not contain %q", line)
+		}
+	}
+	// Stone 只导出到 server, hidden 字段不导出
+	for _, s := range []string{"Stone", "hidden"} {
+		if bytes.Contains(declarations, []byte(s)) {
+			t.Errorf("tables.d.ts contains %s", s)
+		}
+	}
+
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node command not found")
+	}
+	if err := os.WriteFile(filepath.Join(outdir, "ts", "package.json"), []byte(`{"type": "module"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outdir, "load.mjs"), []byte(jsLoadTest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	golden, err := filepath.Abs(filepath.Join("testdata", "golden", "json", "client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(node, "load.mjs", golden)
+	cmd.Dir = outdir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("node error: %v\n%s", err, out)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/midlang/mid/src/mid/build"
 )
 
+// GenerateXlsx midc 插件入口, 根据协议创建或更新 <outdir>/<file>/<protocol>.xlsx 的表头
 func GenerateXlsx(plugin build.Plugin, config build.PluginRuntimeConfig, pkg *build.Package) error {
 	for _, file := range pkg.Files {
 		dir := filepath.Join(config.Outdir, trimFilenameSuffix(filepath.Base(file.Filename)))
@@ -28,7 +29,6 @@ func GenerateXlsx(plugin build.Plugin, config build.PluginRuntimeConfig, pkg *bu
 			}
 			// 打开 excel 文件，如果文件不存在则新建一个
 			filename := filepath.Join(dir, bean.Name+excelSuffix)
-			sheetName := defaultSheetName
 			isNew := false
 			file, err := excelize.OpenFile(filename)
 			if err != nil {
@@ -40,102 +40,12 @@ func GenerateXlsx(plugin build.Plugin, config build.PluginRuntimeConfig, pkg *bu
 					return err
 				}
 			}
-			modified := true
-
-			headers, err := makeHeader(pkg, bean, "", "", "")
+			plan, err := SyncHeaders(pkg, bean, file)
 			if err != nil {
 				return err
 			}
-			rows := file.GetRows(sheetName)
-			if len(rows) < 2 {
-				for i := 0; i < len(rows); i++ {
-					file.RemoveRow(sheetName, i)
-				}
-				file.InsertRow(sheetName, 0)
-				file.InsertRow(sheetName, 1)
-				for col, header := range headers {
-					setHeader(file, sheetName, header, col)
-				}
-			} else {
-				// 调整表结构
-
-				// 取出当前的所有 comments
-				comments := getComments(file, sheetName)
-
-				headerMap := make(map[string]xlsxHeader)
-				for _, header := range headers {
-					headerMap[header.Comment] = header
-				}
-
-				// 根据已有的表头建立节点数
-				nodes := buildJSONNodes(headerMap, pkg, bean, comments, rows[0])
-				for i := 1; i < len(nodes); i++ {
-					// nodes 从 1 开始的都标记为叶子节点,这些节点直接对应 excel 的各列
-					nodes[i].userdata.s = columnName(i - 1)
-					nodes[i].userdata.i = int64(i)
-				}
-
-				// 根据最新表头加入节点并记录下哪些是新的表头
-				var newHeaders = map[*Node]int{}
-				for i, header := range headers {
-					contents := strings.Split(header.Comment, ".")
-					next := nodes[0]
-					for j := 0; j < len(contents); j++ {
-						next = next.addChild(pkg, contents[j])
-					}
-					// 旧的节点都被标记了非 0 值
-					// userdata.i 还等于 0 的是新节点
-					if next.userdata.i == 0 {
-						newHeaders[next] = i
-						next.header = &header
-						log.Debug().Printf("new header %v", header)
-					}
-				}
-				nodes[0].sort(pkg)
-
-				modified = isAnyHeaderChanged(file, sheetName, newHeaders, headers, nodes)
-				if modified {
-
-					moved := make(map[int]int)
-
-					// 清空原来的表头
-					file.RemoveRow(sheetName, 0)
-					file.InsertRow(sheetName, 0)
-					file.RemoveRow(sheetName, 1)
-					file.InsertRow(sheetName, 1)
-					rows = file.GetRows(sheetName)
-
-					// 遍历节点,添加新表头到合适的位置
-					nodes[0].visit(func(index int, node *Node) {
-						if i, ok := newHeaders[node]; ok {
-							log.Debug().Printf("insert new column for new header %v before %s", headers[i], columnName(index))
-							setHeader(file, sheetName, headers[i], index)
-						} else if node.header != nil {
-							moved[int(node.userdata.i)-1] = index
-							setHeader(file, sheetName, *(node.header), index)
-						} else {
-							log.Debug().Printf("header of node '%s' is nil", node.text)
-						}
-					})
-
-					// 移动原来的数据
-					for i := 2; i < len(rows); i++ {
-						for j := len(rows[i]) - 1; j >= 0; j-- {
-							if targetIndex, ok := moved[j]; ok && j != targetIndex {
-								if n, err := strconv.ParseInt(rows[i][j], 10, 64); err == nil {
-									file.SetCellValue(sheetName, cellName(i, targetIndex), n)
-								} else if f, err := strconv.ParseFloat(rows[i][j], 64); err == nil {
-									file.SetCellValue(sheetName, cellName(i, targetIndex), f)
-								} else {
-									file.SetCellStr(sheetName, cellName(i, targetIndex), rows[i][j])
-								}
-								file.SetCellStr(sheetName, cellName(i, j), "")
-							}
-						}
-					}
-				}
-			}
-			if modified {
+			if plan.Modified {
+				plan.Apply(file)
 				if isNew {
 					log.Debug().Printf("create new excel file '%s'", filename)
 				} else {
@@ -151,34 +61,181 @@ func GenerateXlsx(plugin build.Plugin, config build.PluginRuntimeConfig, pkg *bu
 	return nil
 }
 
-func isAnyHeaderChanged(file *excelize.File, sheetName string, newHeaders map[*Node]int, headers []xlsxHeader, nodes []*Node) bool {
-	moved := make(map[int]int)
-	modified := len(newHeaders) > 0
-	// 遍历节点,添加新表头到合适的位置
+// Column 同步后表格的一列
+type Column struct {
+	// 列的标注, 即字段路径, 如 attrs(Attr[]).0.a(int32)
+	Path string
+	// 列的标题
+	Title string
+	// 该列原来的位置, -1 表示新增的列
+	From int
+
+	header *xlsxHeader
+}
+
+// Plan 同步表头的计划, 由 SyncHeaders 生成, 调用 Apply 执行
+type Plan struct {
+	// 表格是否需要修改
+	Modified bool
+	// 同步后的各列, 不再属于协议的旧列 Path 和 Title 都为空
+	Columns []Column
+
+	sheetName string
+	// 表格为空, 直接写入表头
+	empty bool
+}
+
+// SyncHeaders 根据协议 bean 的最新定义计算 excel 表头的同步计划, 不修改 file
+func SyncHeaders(pkg *build.Package, bean *build.Bean, file *excelize.File) (*Plan, error) {
+	if err := checkBean(pkg, bean); err != nil {
+		return nil, err
+	}
+	headers, err := makeHeader(pkg, bean, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{sheetName: defaultSheetName}
+	sheetName := plan.sheetName
+	rows := file.GetRows(sheetName)
+	if len(rows) < 2 {
+		plan.Modified = true
+		plan.empty = true
+		for i := range headers {
+			plan.Columns = append(plan.Columns, Column{
+				Path:   headers[i].Comment,
+				Title:  headers[i].Name,
+				From:   -1,
+				header: &headers[i],
+			})
+		}
+		return plan, nil
+	}
+
+	// 调整表结构
+
+	// 取出当前的所有 comments
+	comments := getComments(file, sheetName)
+
+	headerMap := make(map[string]xlsxHeader)
+	for _, header := range headers {
+		headerMap[header.Comment] = header
+	}
+
+	// 根据已有的表头建立节点数
+	nodes := buildJSONNodes(headerMap, pkg, bean, comments, rows[0])
+	for i := 1; i < len(nodes); i++ {
+		// nodes 从 1 开始的都标记为叶子节点,这些节点直接对应 excel 的各列
+		nodes[i].userdata.s = columnName(i - 1)
+		nodes[i].userdata.i = int64(i)
+	}
+
+	// 根据最新表头加入节点并记录下哪些是新的表头
+	var newHeaders = map[*Node]int{}
+	for i, header := range headers {
+		contents := strings.Split(header.Comment, ".")
+		next := nodes[0]
+		for j := 0; j < len(contents); j++ {
+			next = next.addChild(pkg, contents[j])
+		}
+		// 旧的节点都被标记了非 0 值
+		// userdata.i 还等于 0 的是新节点
+		if next.userdata.i == 0 {
+			newHeaders[next] = i
+			next.header = &headers[i]
+			log.Debug().Printf("new header %v", header)
+		}
+	}
+	nodes[0].sort(pkg)
+
+	// 遍历节点,确定每一列的新位置
+	plan.Modified = len(newHeaders) > 0
 	nodes[0].visit(func(index int, node *Node) {
 		if i, ok := newHeaders[node]; ok {
+			plan.Columns = append(plan.Columns, Column{
+				Path:   headers[i].Comment,
+				Title:  headers[i].Name,
+				From:   -1,
+				header: &headers[i],
+			})
 			if isHeaderChanged(file, sheetName, headers[i], index) {
-				modified = true
+				plan.Modified = true
 			}
 		} else if node.header != nil {
-			moved[int(node.userdata.i)-1] = index
-			if isHeaderChanged(file, sheetName, *(node.header), index) {
-				modified = true
+			from := int(node.userdata.i) - 1
+			plan.Columns = append(plan.Columns, Column{
+				Path:   node.header.Comment,
+				Title:  node.header.Name,
+				From:   from,
+				header: node.header,
+			})
+			if from != index || isHeaderChanged(file, sheetName, *(node.header), index) {
+				plan.Modified = true
 			}
 		} else {
-			modified = true
+			log.Debug().Printf("header of node '%s' is nil", node.text)
+			plan.Columns = append(plan.Columns, Column{From: -1})
+			plan.Modified = true
 		}
 	})
+	return plan, nil
+}
+
+// Apply 执行同步计划, 写入新的表头并移动原有的数据
+func (plan *Plan) Apply(file *excelize.File) {
+	sheetName := plan.sheetName
+	if plan.empty {
+		rows := file.GetRows(sheetName)
+		for i := 0; i < len(rows); i++ {
+			file.RemoveRow(sheetName, i)
+		}
+		file.InsertRow(sheetName, 0)
+		file.InsertRow(sheetName, 1)
+		for col, column := range plan.Columns {
+			setHeader(file, sheetName, *column.header, col)
+		}
+		return
+	}
+	if !plan.Modified {
+		return
+	}
+
+	moved := make(map[int]int)
+
+	// 清空原来的表头
+	file.RemoveRow(sheetName, 0)
+	file.InsertRow(sheetName, 0)
+	file.RemoveRow(sheetName, 1)
+	file.InsertRow(sheetName, 1)
+	rows := file.GetRows(sheetName)
 
-	if modified == false {
-		for from, to := range moved {
-			if from != to {
-				modified = true
-				break
+	// 添加表头到新的位置
+	for index, column := range plan.Columns {
+		if column.header == nil {
+			continue
+		}
+		if column.From < 0 {
+			log.Debug().Printf("insert new column for new header %v before %s", *column.header, columnName(index))
+		} else {
+			moved[column.From] = index
+		}
+		setHeader(file, sheetName, *column.header, index)
+	}
+
+	// 移动原来的数据
+	for i := 2; i < len(rows); i++ {
+		for j := len(rows[i]) - 1; j >= 0; j-- {
+			if targetIndex, ok := moved[j]; ok && j != targetIndex {
+				if n, err := strconv.ParseInt(rows[i][j], 10, 64); err == nil {
+					file.SetCellValue(sheetName, cellName(i, targetIndex), n)
+				} else if f, err := strconv.ParseFloat(rows[i][j], 64); err == nil {
+					file.SetCellValue(sheetName, cellName(i, targetIndex), f)
+				} else {
+					file.SetCellStr(sheetName, cellName(i, targetIndex), rows[i][j])
+				}
+				file.SetCellStr(sheetName, cellName(i, j), "")
 			}
 		}
 	}
-	return modified
 }
 
 func isHeaderChanged(file *excelize.File, sheetName string, header xlsxHeader, index int) bool {
@@ -186,6 +243,9 @@ func isHeaderChanged(file *excelize.File, sheetName string, header xlsxHeader, i
 		file.GetCellValue(sheetName, cellName(1, index)) != header.Name
 }
 
+// 是否为枚举列添加下拉列表
+const enableDropList = false
+
 func setHeader(file *excelize.File, sheetName string, header xlsxHeader, index int) {
 	// 首行用作标注
 	file.SetCellStr(sheetName, cellName(0, index), header.Comment)
@@ -193,10 +253,8 @@ func setHeader(file *excelize.File, sheetName string, header xlsxHeader, index i
 	// 第二行开始做标题行
 	file.SetCellStr(sheetName, cellName(1, index), header.Name)
 
-	return
-
-	//FIXME
-	if len(header.Enums) > 0 {
+	//FIXME: 下拉列表暂未启用
+	if enableDropList && len(header.Enums) > 0 {
 		dv := excelize.NewDataValidation(true)
 		cname := columnName(index)
 		dv.Sqref = fmt.Sprintf("%s%d:%s%d", cname, 3, cname, (1<<15)-2)
@@ -0,0 +1,151 @@
+package xlsx
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jokgame/tools/autoconf/manifest"
+)
+
+// stageManifests 暂存各导出目标的新清单. 清单的文件列表变化时才生成新的清单, 旧清单保存为历史清单,
+// 配置了私钥时同时暂存清单的签名. 只保留最近的 History 个历史清单, 并删除导出目录中不再被当前清单和保留的历史清单引用的 json 文件
+func stageManifests(st *stage, cfg *Config, exportedFiles map[string][]*FileInfo, packs map[string]*manifest.PackInfo) error {
+	// 导出目录中仍被引用的文件, 多个导出目标可能共用一个目录
+	var referenced = make(map[string]map[string]bool)
+	for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+		return exportConfig.Manifest != ""
+	}) {
+		if len(exportedFiles[export]) == 0 {
+			continue
+		}
+		exportConfig := cfg.Export(export)
+		filename := exportConfig.Manifest
+		m := &manifest.Manifest{
+			Version:   manifest.FormatVersion,
+			BuildID:   cfg.BuildID,
+			Timestamp: time.Now().Unix(),
+			RootHash:  manifest.RootHash(exportedFiles[export]),
+			Files:     exportedFiles[export],
+			Pack:      packs[export],
+		}
+		var key ed25519.PrivateKey
+		if exportConfig.SigningKey != "" {
+			var err error
+			if key, err = manifest.LoadPrivateKey(exportConfig.SigningKey); err != nil {
+				return err
+			}
+		}
+		history, err := manifest.History(filename)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var current *manifest.Manifest
+		if err == nil {
+			// 无法解析的旧清单直接被替换
+			current, _ = manifest.Parse(data)
+		}
+		sigFilename := manifest.SignatureFilename(filename)
+		sameFiles := current != nil && current.SameFiles(m)
+		if sameFiles && (key == nil || validSignature(key, data, sigFilename)) {
+			// 文件没有变化时保留原来的清单
+			m = current
+		} else {
+			// 文件没有变化但签名无效时只重新生成清单
+			if current != nil && !sameFiles && exportConfig.History > 0 {
+				n := manifest.NextHistory(history)
+				historyFilename := manifest.HistoryFilename(filename, n)
+				if err := st.writeFile(historyFilename, data, stageHistory); err != nil {
+					return err
+				}
+				sig, err := os.ReadFile(sigFilename)
+				if err == nil {
+					err = st.writeFile(manifest.SignatureFilename(historyFilename), sig, stageHistory)
+				} else if os.IsNotExist(err) {
+					err = nil
+				}
+				if err != nil {
+					return err
+				}
+				history = append(history, manifest.Version{N: n, Manifest: current})
+			}
+			if data, err = m.Marshal(); err != nil {
+				return err
+			}
+			if err := st.writeFile(filename, data, stageManifest); err != nil {
+				return err
+			}
+		}
+		if key != nil {
+			if err := st.writeFile(sigFilename, manifest.Sign(key, data), stageManifest); err != nil {
+				return err
+			}
+		} else {
+			// 不签名时删除之前的签名, 即使清单没有变化
+			st.remove(sigFilename, stageManifest)
+		}
+		for len(history) > exportConfig.History {
+			if history[0].Filename != "" {
+				st.remove(history[0].Filename, stagePrune)
+				st.remove(manifest.SignatureFilename(history[0].Filename), stagePrune)
+			}
+			history = history[1:]
+		}
+
+		dir := filepath.Clean(exportDir(cfg, export))
+		if referenced[dir] == nil {
+			referenced[dir] = make(map[string]bool)
+		}
+		for _, name := range filenames(m) {
+			referenced[dir][name] = true
+		}
+		for _, version := range history {
+			for _, name := range filenames(version.Manifest) {
+				referenced[dir][name] = true
+			}
+		}
+	}
+
+	for dir, files := range referenced {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.Type().IsRegular() && manifest.IsChecksumFilename(name) && !files[name] {
+				st.remove(filepath.Join(dir, name), stagePrune)
+			}
+		}
+	}
+	return nil
+}
+
+// validSignature 判断签名文件 sigFilename 是否为清单内容 data 的有效签名
+func validSignature(key ed25519.PrivateKey, data []byte, sigFilename string) bool {
+	sig, err := os.ReadFile(sigFilename)
+	if err != nil {
+		return false
+	}
+	return manifest.VerifySignature(key.Public().(ed25519.PublicKey), data, sig) == nil
+}
+
+// filenames 返回清单引用的所有文件
+func filenames(m *manifest.Manifest) []string {
+	var names []string
+	for _, file := range m.Files {
+		names = append(names, file.Filename)
+	}
+	if m.Pack != nil {
+		names = append(names, m.Pack.Filename)
+	}
+	return names
+}
@@ -0,0 +1,248 @@
+package xlsx
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jokgame/tools/autoconf/manifest"
+	"github.com/jokgame/tools/autoconf/verify"
+)
+
+// writeExportedFiles 在导出目录 dir 中写入带有 checksum 的数据文件, contents 为协议名到文件内容的映射
+func writeExportedFiles(t *testing.T, dir string, contents map[string]string) []*FileInfo {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var files []*FileInfo
+	for name, content := range contents {
+		checksum := fmt.Sprintf("%02x", sha256.Sum256([]byte(content)))
+		file := &FileInfo{Name: name, Checksum: checksum, Filename: name + "." + checksum + ".json", Size: int64(len(content))}
+		if err := os.WriteFile(filepath.Join(dir, file.Filename), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+var checksumSuffix = regexp.MustCompile(`\.[0-9a-f]{64}\.json$`)
+
+// treeNames 返回目录 dir 中所有文件的相对路径, 带有 checksum 的文件名中的 checksum 和扩展名替换为文件内容
+func treeNames(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	for name, content := range readTree(t, dir) {
+		names = append(names, checksumSuffix.ReplaceAllLiteralString(name, "."+content))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestStageManifests(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "client.manifest.json")
+	cfg := &Config{
+		Outdir:  dir,
+		Exports: map[string]*ExportConfig{"client": {Manifest: filename, History: 1}},
+	}
+	exportDir := filepath.Join(dir, "client")
+	// 不被引用的旧文件, 名称中带有导出目标名允许的字符
+	writeExportedFiles(t, exportDir, map[string]string{"my-item_2": "stale"})
+	os.WriteFile(filepath.Join(exportDir, "readme.txt"), []byte("kept"), 0644)
+
+	var previous []byte
+	for _, tt := range []struct {
+		name     string
+		contents map[string]string
+		// 清单是否保持不变
+		keep bool
+		// 保存上一次导出的清单的历史清单
+		rotated string
+		want    []string
+	}{
+		{
+			"first export",
+			map[string]string{"Item": "a", "Stone": "s"},
+			false,
+			"",
+			[]string{"client.manifest.json", "client/Item.a", "client/Stone.s", "client/readme.txt"},
+		},
+		{
+			"unchanged",
+			map[string]string{"Item": "a", "Stone": "s"},
+			true,
+			"",
+			[]string{"client.manifest.json", "client/Item.a", "client/Stone.s", "client/readme.txt"},
+		},
+		{
+			// 旧清单保存为历史清单, 历史清单引用的文件保留
+			"rotate",
+			map[string]string{"Item": "b", "Stone": "s"},
+			false,
+			"client.manifest.1.json",
+			[]string{"client.manifest.1.json", "client.manifest.json", "client/Item.a", "client/Item.b", "client/Stone.s", "client/readme.txt"},
+		},
+		{
+			// 只保留 1 个历史清单, 不再被引用的文件被删除
+			"prune",
+			map[string]string{"Item": "c", "Stone": "s"},
+			false,
+			"client.manifest.2.json",
+			[]string{"client.manifest.2.json", "client.manifest.json", "client/Item.b", "client/Item.c", "client/Stone.s", "client/readme.txt"},
+		},
+	} {
+		files := writeExportedFiles(t, exportDir, tt.contents)
+		st := newStage()
+		if err := stageManifests(st, cfg, map[string][]*FileInfo{"client": files}, nil); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := st.commit(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := treeNames(t, dir); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got files %v, want %v", tt.name, got, tt.want)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keep := string(data) == string(previous); keep != tt.keep {
+			t.Errorf("%s: manifest kept %v, want %v", tt.name, keep, tt.keep)
+		}
+		if tt.rotated != "" {
+			if history, err := os.ReadFile(filepath.Join(dir, tt.rotated)); err != nil || string(history) != string(previous) {
+				t.Errorf("%s: history manifest mismatch: %v", tt.name, err)
+			}
+		}
+		previous = data
+	}
+}
+
+func TestStageManifestsSignature(t *testing.T) {
+	dir := t.TempDir()
+	privateKey, publicKey, err := manifest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := manifest.ParsePublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "client.key")
+	if err := os.WriteFile(keyFile, privateKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "client.manifest.json")
+	sigFilename := manifest.SignatureFilename(filename)
+	exportConfig := &ExportConfig{Manifest: filename, History: 1}
+	cfg := &Config{Outdir: dir, Exports: map[string]*ExportConfig{"client": exportConfig}}
+	files := writeExportedFiles(t, filepath.Join(dir, "client"), map[string]string{"Item": "a"})
+
+	var previous []byte
+	for _, tt := range []struct {
+		name    string
+		key     string
+		prepare func()
+		// 清单是否保持不变, 重新生成的清单在同一秒内与原来的清单相同, 因此只检查保持不变的情况
+		keep bool
+		// 是否有有效的签名, 为 false 时签名文件不存在
+		signed bool
+	}{
+		{"sign", keyFile, func() {}, false, true},
+		{"unchanged", keyFile, func() {}, true, true},
+		// 签名无效时重新生成清单并签名, 文件列表没有变化, 不生成历史清单
+		{"invalid signature", keyFile, func() { os.WriteFile(sigFilename, []byte("invalid\n"), 0644) }, false, true},
+		// 不再签名时删除之前的签名, 清单保持不变
+		{"unsigned", "", func() {}, true, false},
+	} {
+		exportConfig.SigningKey = tt.key
+		tt.prepare()
+		st := newStage()
+		if err := stageManifests(st, cfg, map[string][]*FileInfo{"client": files}, nil); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := st.commit(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.keep && string(data) != string(previous) {
+			t.Errorf("%s: manifest changed", tt.name)
+		}
+		previous = data
+		sig, err := os.ReadFile(sigFilename)
+		if tt.signed {
+			if _, err := verify.Manifest(pub, data, sig); err != nil {
+				t.Errorf("%s: verify manifest error: %v", tt.name, err)
+			}
+		} else if !os.IsNotExist(err) {
+			t.Errorf("%s: got signature error %v, want not exist", tt.name, err)
+		}
+		if _, err := os.Stat(manifest.HistoryFilename(filename, 1)); !os.IsNotExist(err) {
+			t.Errorf("%s: unexpected history manifest", tt.name)
+		}
+	}
+}
+
+// TestExportManifest 检查导出 testdata/golden 中的表格生成的清单记录的文件信息
+func TestExportManifest(t *testing.T) {
+	outdir := t.TempDir()
+	filename := filepath.Join(outdir, "server.manifest.json")
+	cfg := &Config{
+		XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+		Outdir:  outdir,
+		Cache:   "-",
+		BuildID: "build-42",
+		Exports: map[string]*ExportConfig{"server": {Manifest: filename}},
+	}
+	pkg := goldenPackage()
+	start := time.Now().Unix()
+	if err := ExportJSON(pkg, cfg); err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != manifest.FormatVersion || m.BuildID != "build-42" || m.Timestamp < start || m.Timestamp > time.Now().Unix() {
+		t.Errorf("got version %d, build id %q, timestamp %d", m.Version, m.BuildID, m.Timestamp)
+	}
+	if m.RootHash != manifest.RootHash(m.Files) {
+		t.Errorf("root hash %s mismatch", m.RootHash)
+	}
+	var names []string
+	for _, file := range m.Files {
+		names = append(names, file.Name)
+		path := filepath.Join(outdir, "server", file.Filename)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checksum, err := fileChecksum(path); err != nil || checksum != file.Checksum {
+			t.Errorf("%s: checksum %s mismatch", file.Name, file.Checksum)
+		}
+		if info.Size() != file.Size || file.Filename != file.Name+"."+file.Checksum+".json" {
+			t.Errorf("%s: got file %s of size %d, file size %d", file.Name, file.Filename, file.Size, info.Size())
+		}
+		for _, bean := range pkg.Files[0].Beans {
+			if want := schemaHash(pkg, bean); bean.Name == file.Name && file.SchemaHash != want {
+				t.Errorf("%s: got schema hash %s, want %s", file.Name, file.SchemaHash, want)
+			}
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"Global", "Item", "Stone"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %v, want %v", names, want)
+	}
+}
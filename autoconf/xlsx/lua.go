@@ -0,0 +1,309 @@
+package xlsx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// luaEncoder 逐行输出表格的 lua 模块, 以 key 字段的值为索引:
+//
+//	return {
+//		[1001] = {id = 1001, name = "...", attrs = {{a = 1}, {a = 2}}},
+//	}
+//
+// 单例表为 return {...}. 结构体按字段声明顺序输出, 未设置的字段不输出. 包含 null 元素的数组
+// 不输出 null 元素, 其他元素显式指定下标, 如 {[1] = 1, [3] = 3}. 超出 lua 整数范围的 uint64 报错.
+// dedup 为 true 时多次出现的相同的子表只创建一次, 所有行先缓存, 结束时再输出:
+//
+//	local _t = {}
+//	_t[1] = {a = 0, b = 0}
+//	return {
+//		[1001] = {id = 1001, attrs = {_t[1], _t[1]}},
+//	}
+type luaEncoder struct {
+	w         io.Writer
+	singleton bool
+	dedup     bool
+	// key 字段在导出数据中的字段名
+	key   string
+	count int
+	// 单例表或 dedup 时缓存的行
+	rows []interface{}
+}
+
+func newLuaEncoder(w io.Writer, pkg *build.Package, bean *build.Bean, singleton, dedup bool) (*luaEncoder, error) {
+	e := &luaEncoder{
+		w:         w,
+		singleton: singleton,
+		dedup:     dedup,
+	}
+	if !singleton {
+		field := keyFieldOfBean(pkg, bean)
+		if field == nil || field.GetTag("name") == "-" {
+			return nil, fmt.Errorf("key field of %s is not exported", bean.Name)
+		}
+		e.key = fieldName(field)
+		if tag := field.GetTag("name"); tag != "" {
+			e.key = tag
+		}
+	}
+	return e, nil
+}
+
+func (e *luaEncoder) encode(value interface{}) error {
+	e.count++
+	if e.singleton || e.dedup {
+		e.rows = append(e.rows, value)
+		return nil
+	}
+	if e.count == 1 {
+		if _, err := io.WriteString(e.w, "return {\n"); err != nil {
+			return err
+		}
+	}
+	key, err := e.rowKey(value)
+	if err != nil {
+		return err
+	}
+	s, _, err := (*luaDedup)(nil).expr(value, true)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(e.w, "\t["+key+"] = "+s+",\n")
+	return err
+}
+
+// rowKey 返回行的 key 字段的 lua 字面量
+func (e *luaEncoder) rowKey(value interface{}) (string, error) {
+	if obj, ok := value.(*object); ok {
+		switch v := obj.values[e.key].(type) {
+		case int, int64, int64Value, uint64Value, string:
+			s, _, err := (*luaDedup)(nil).expr(v, true)
+			return s, err
+		}
+	}
+	return "", fmt.Errorf("invalid key field %s", e.key)
+}
+
+func (e *luaEncoder) close() error {
+	if !e.singleton && !e.dedup {
+		if e.count == 0 {
+			_, err := io.WriteString(e.w, "return {}\n")
+			return err
+		}
+		_, err := io.WriteString(e.w, "}\n")
+		return err
+	}
+
+	var d *luaDedup
+	if e.dedup {
+		d = &luaDedup{counts: make(map[string]int), counting: true}
+		for _, row := range e.rows {
+			if _, _, err := d.expr(row, true); err != nil {
+				return err
+			}
+		}
+		d.counting = false
+		d.ids = make(map[string]int)
+	}
+	var body bytes.Buffer
+	if e.singleton {
+		var row interface{} = newObject()
+		if len(e.rows) > 0 {
+			row = e.rows[0]
+		}
+		s, _, err := d.expr(row, true)
+		if err != nil {
+			return err
+		}
+		body.WriteString("return " + s + "\n")
+	} else if len(e.rows) == 0 {
+		body.WriteString("return {}\n")
+	} else {
+		body.WriteString("return {\n")
+		for _, row := range e.rows {
+			key, err := e.rowKey(row)
+			if err != nil {
+				return err
+			}
+			s, _, err := d.expr(row, true)
+			if err != nil {
+				return err
+			}
+			body.WriteString("\t[" + key + "] = " + s + ",\n")
+		}
+		body.WriteString("}\n")
+	}
+	if d != nil && len(d.defs) > 0 {
+		// lua 函数的局部变量数量有限, 共享的子表都保存在一个局部变量中
+		var buf bytes.Buffer
+		buf.WriteString("local _t = {}\n")
+		for i, def := range d.defs {
+			fmt.Fprintf(&buf, "_t[%d] = %s\n", i+1, def)
+		}
+		if _, err := e.w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	_, err := e.w.Write(body.Bytes())
+	return err
+}
+
+// luaDedup 查找并共享多次出现的子表. counting 为 true 时统计各子表出现的次数,
+// 之后生成的表达式中出现多次的子表替换为 _t[id], 子表的定义按依赖顺序保存在 defs 中
+type luaDedup struct {
+	counting bool
+	counts   map[string]int
+	ids      map[string]int
+	defs     []string
+}
+
+// expr 返回值的 lua 表达式和完全展开(不共享子表)的表达式, d 为 nil 时不共享子表. root 表示值为一行的数据
+func (d *luaDedup) expr(value interface{}, root bool) (string, string, error) {
+	var exprs, expanded []string
+	switch v := value.(type) {
+	case nil:
+		return "nil", "nil", nil
+	case bool:
+		s := strconv.FormatBool(v)
+		return s, s, nil
+	case int:
+		s := strconv.Itoa(v)
+		return s, s, nil
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return s, s, nil
+	case int64Value:
+		s := strconv.FormatInt(int64(v), 10)
+		return s, s, nil
+	case uint64Value:
+		// lua 5.3 的整数为 64 位有符号整数, 更大的字面量会被解析为浮点数
+		if uint64(v) > math.MaxInt64 {
+			return "", "", fmt.Errorf("lua: uint64 value %d out of range of lua integer", uint64(v))
+		}
+		s := strconv.FormatUint(uint64(v), 10)
+		return s, s, nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", "", fmt.Errorf("lua: unsupported value %v", v)
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		return s, s, nil
+	case decimal:
+		return string(v), string(v), nil
+	case string:
+		s := quoteLuaString(v)
+		return s, s, nil
+	case *object:
+		for _, key := range v.keys {
+			if v.values[key] == nil {
+				continue
+			}
+			x, y, err := d.expr(v.values[key], false)
+			if err != nil {
+				return "", "", err
+			}
+			name := luaFieldName(key)
+			exprs = append(exprs, name+" = "+x)
+			expanded = append(expanded, name+" = "+y)
+		}
+	case []interface{}:
+		// 包含 null 元素时 # 和 ipairs 的结果不确定, 显式输出非 null 元素的下标
+		sparse := false
+		for _, elem := range v {
+			if elem == nil {
+				sparse = true
+				break
+			}
+		}
+		for i, elem := range v {
+			if sparse && elem == nil {
+				continue
+			}
+			x, y, err := d.expr(elem, false)
+			if err != nil {
+				return "", "", err
+			}
+			if sparse {
+				index := "[" + strconv.Itoa(i+1) + "] = "
+				x, y = index+x, index+y
+			}
+			exprs = append(exprs, x)
+			expanded = append(expanded, y)
+		}
+	default:
+		return "", "", fmt.Errorf("lua: unsupported value type %T", value)
+	}
+	s := "{" + strings.Join(exprs, ", ") + "}"
+	full := "{" + strings.Join(expanded, ", ") + "}"
+	if d == nil || root || len(exprs) == 0 {
+		return s, full, nil
+	}
+	if d.counting {
+		d.counts[full]++
+		return full, full, nil
+	}
+	if d.counts[full] < 2 {
+		return s, full, nil
+	}
+	id, ok := d.ids[full]
+	if !ok {
+		d.defs = append(d.defs, s)
+		id = len(d.defs)
+		d.ids[full] = id
+	}
+	return fmt.Sprintf("_t[%d]", id), full, nil
+}
+
+var luaIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "goto": true, "if": true, "in": true,
+	"local": true, "nil": true, "not": true, "or": true, "repeat": true, "return": true,
+	"then": true, "true": true, "until": true, "while": true,
+}
+
+// luaFieldName 返回表构造器中的字段名, 不是合法标识符的字段名为 ["..."]
+func luaFieldName(key string) string {
+	if luaIdentifier.MatchString(key) && !luaKeywords[key] {
+		return key
+	}
+	return "[" + quoteLuaString(key) + "]"
+}
+
+// quoteLuaString 返回字符串的 lua 字面量, 控制字符转义为 \ddd, 其他字节原样输出
+func quoteLuaString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\%03d`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package xlsx
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func encodeLua(singleton, dedup bool, rows []interface{}) (string, error) {
+	pkg, item := testProtoPackage(`pb:"2"`)
+	var buf bytes.Buffer
+	e, err := newLuaEncoder(&buf, pkg, item, singleton, dedup)
+	if err != nil {
+		return "", err
+	}
+	for _, row := range rows {
+		if err := e.encode(row); err != nil {
+			return "", err
+		}
+	}
+	if err := e.close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func TestLuaEncoder(t *testing.T) {
+	attr := func() *object { return objectOf("a", int64(0), "b", "") }
+	rows := []interface{}{
+		objectOf("id", int64Value(1001), "name", "剑", "price", decimal("12.50"), "values", []interface{}{int64(1), nil, int64(3)}, "attrs", []interface{}{attr(), attr()}),
+		objectOf("id", int64Value(1002), "name", nil, "rate", 0.5, "ok", true, "attr", attr(), "big", uint64Value(math.MaxInt64)),
+	}
+	for _, tt := range []struct {
+		name      string
+		singleton bool
+		dedup     bool
+		rows      []interface{}
+		want      string
+	}{
+		{
+			"rows", false, false, rows,
+			"return {\n" +
+				"\t[1001] = {id = 1001, name = \"剑\", price = 12.50, values = {[1] = 1, [3] = 3}, attrs = {{a = 0, b = \"\"}, {a = 0, b = \"\"}}},\n" +
+				"\t[1002] = {id = 1002, rate = 0.5, ok = true, attr = {a = 0, b = \"\"}, big = 9223372036854775807},\n" +
+				"}\n",
+		},
+		{
+			"dedup", false, true, rows,
+			"local _t = {}\n" +
+				"_t[1] = {a = 0, b = \"\"}\n" +
+				"return {\n" +
+				"\t[1001] = {id = 1001, name = \"剑\", price = 12.50, values = {[1] = 1, [3] = 3}, attrs = {_t[1], _t[1]}},\n" +
+				"\t[1002] = {id = 1002, rate = 0.5, ok = true, attr = _t[1], big = 9223372036854775807},\n" +
+				"}\n",
+		},
+		{"no rows", false, false, nil, "return {}\n"},
+		{"no rows dedup", false, true, nil, "return {}\n"},
+		{"singleton", true, false, rows[1:], "return {id = 1002, rate = 0.5, ok = true, attr = {a = 0, b = \"\"}, big = 9223372036854775807}\n"},
+		{"empty singleton", true, false, nil, "return {}\n"},
+		{
+			"field names", true, false,
+			[]interface{}{objectOf("end", int64(1), "a-b", "\"\\\n\x01", "_ok", false)},
+			"return {[\"end\"] = 1, [\"a-b\"] = \"\\\"\\\\\\n\\001\", _ok = false}\n",
+		},
+	} {
+		got, err := encodeLua(tt.singleton, tt.dedup, tt.rows)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLuaEncoderErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		row  interface{}
+		err  string
+	}{
+		{"uint64 out of range", objectOf("id", int64Value(1), "big", uint64Value(math.MaxInt64+1)), "out of range of lua integer"},
+		{"nan", objectOf("id", int64Value(1), "rate", math.NaN()), "unsupported value NaN"},
+		{"missing key", objectOf("name", "x"), "invalid key field id"},
+		{"invalid key", objectOf("id", 1.5), "invalid key field id"},
+	} {
+		if _, err := encodeLua(false, false, []interface{}{tt.row}); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package xlsx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// msgpackEncoder 逐行输出表格的 MessagePack 数据, 结构与 json 相同: {"rows": [...]} 或 {"row": ...},
+// 结构体按字段声明顺序输出为 map, 定点小数输出为字符串.
+// 数组长度需要写在元素之前, 多行的表格先把各行写入暂存目录中的临时文件 spill, 结束时再复制到输出,
+// 内存中只保留一行数据
+type msgpackEncoder struct {
+	w         io.Writer
+	singleton bool
+	count     int
+	row       bytes.Buffer
+	spill     *os.File
+	rows      *bufio.Writer
+}
+
+// newMsgpackEncoder 返回输出到 w 的编码器, 多行的表格需要提供临时文件 spill, close 后删除
+func newMsgpackEncoder(w io.Writer, singleton bool, spill *os.File) *msgpackEncoder {
+	e := &msgpackEncoder{
+		w:         w,
+		singleton: singleton,
+		spill:     spill,
+	}
+	if spill != nil {
+		e.rows = bufio.NewWriter(spill)
+	}
+	return e
+}
+
+func (e *msgpackEncoder) encode(value interface{}) error {
+	e.count++
+	e.row.Reset()
+	if e.singleton {
+		writeMsgpackMapHeader(&e.row, 1)
+		writeMsgpackString(&e.row, "row")
+	}
+	if err := writeMsgpack(&e.row, value); err != nil {
+		return err
+	}
+	if e.singleton {
+		_, err := e.w.Write(e.row.Bytes())
+		return err
+	}
+	_, err := e.rows.Write(e.row.Bytes())
+	return err
+}
+
+func (e *msgpackEncoder) close() error {
+	if e.singleton {
+		if e.count > 0 {
+			return nil
+		}
+		var buf bytes.Buffer
+		writeMsgpackMapHeader(&buf, 1)
+		writeMsgpackString(&buf, "row")
+		writeMsgpackMapHeader(&buf, 0)
+		_, err := e.w.Write(buf.Bytes())
+		return err
+	}
+	defer os.Remove(e.spill.Name())
+	err := e.rows.Flush()
+	if err == nil {
+		_, err = e.spill.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		var buf bytes.Buffer
+		writeMsgpackMapHeader(&buf, 1)
+		writeMsgpackString(&buf, "rows")
+		writeMsgpackArrayHeader(&buf, e.count)
+		if _, err = e.w.Write(buf.Bytes()); err == nil {
+			_, err = io.Copy(e.w, e.spill)
+		}
+	}
+	if e := e.spill.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// writeMsgpack 编码 plan 生成的值
+func writeMsgpack(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int:
+		writeMsgpackInt(buf, int64(v))
+	case int64:
+		writeMsgpackInt(buf, v)
+	case int64Value:
+		writeMsgpackInt(buf, int64(v))
+	case uint64Value:
+		writeMsgpackUint(buf, uint64(v))
+	case float64:
+		writeMsgpackFloat(buf, v)
+	case decimal:
+		writeMsgpackString(buf, string(v))
+	case string:
+		writeMsgpackString(buf, v)
+	case *object:
+		writeMsgpackMapHeader(buf, len(v.keys))
+		for _, key := range v.keys {
+			writeMsgpackString(buf, key)
+			if err := writeMsgpack(buf, v.values[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		writeMsgpackArrayHeader(buf, len(v))
+		for _, x := range v {
+			if err := writeMsgpack(buf, x); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported value type %T", value)
+	}
+	return nil
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, v int64) {
+	switch {
+	case v >= 0:
+		writeMsgpackUint(buf, uint64(v))
+	case v >= -32:
+		buf.WriteByte(byte(v))
+	case v >= math.MinInt8:
+		buf.Write([]byte{0xd0, byte(v)})
+	case v >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(v))
+	case v >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(v))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+func writeMsgpackUint(buf *bytes.Buffer, v uint64) {
+	switch {
+	case v <= 0x7f:
+		buf.WriteByte(byte(v))
+	case v <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(v)})
+	case v <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(v))
+	case v <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(v))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+func writeMsgpackFloat(buf *bytes.Buffer, v float64) {
+	buf.WriteByte(0xcb)
+	binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+}
+
+func writeMsgpackString(buf *bytes.Buffer, s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{0xd9, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.WriteString(s)
+}
+
+func writeMsgpackArrayHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 15:
+		buf.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xdc)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdd)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackMapHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 15:
+		buf.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xde)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdf)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
@@ -0,0 +1,200 @@
+package xlsx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// objectOf 按顺序创建结构体的值, kvs 为交替的字段名和值
+func objectOf(kvs ...interface{}) *object {
+	obj := newObject()
+	for i := 0; i < len(kvs); i += 2 {
+		obj.set(kvs[i].(string), kvs[i+1])
+	}
+	return obj
+}
+
+// msgpackDecoder 测试用的 MessagePack 解码器, 只支持 writeMsgpack 输出的类型.
+// map 解码为 *object, 整数解码为 int64, 超出 int64 范围的整数解码为 uint64
+type msgpackDecoder struct {
+	r *bytes.Reader
+}
+
+func (d *msgpackDecoder) uint(n int) uint64 {
+	var buf [8]byte
+	if _, err := io.ReadFull(d.r, buf[8-n:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+func (d *msgpackDecoder) length(c byte, fix, fixMask, base byte) int {
+	if c&^fixMask == fix {
+		return int(c & fixMask)
+	}
+	return int(d.uint(2 << (c - base)))
+}
+
+func (d *msgpackDecoder) decode() interface{} {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		panic(err)
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c)
+	case c >= 0xe0:
+		return int64(int8(c))
+	case c == 0xc0:
+		return nil
+	case c == 0xc2, c == 0xc3:
+		return c == 0xc3
+	case c >= 0xcc && c <= 0xcf:
+		v := d.uint(1 << (c - 0xcc))
+		if v > math.MaxInt64 {
+			return v
+		}
+		return int64(v)
+	case c == 0xd0:
+		return int64(int8(d.uint(1)))
+	case c == 0xd1:
+		return int64(int16(d.uint(2)))
+	case c == 0xd2:
+		return int64(int32(d.uint(4)))
+	case c == 0xd3:
+		return int64(d.uint(8))
+	case c == 0xcb:
+		return math.Float64frombits(d.uint(8))
+	case c&0xe0 == 0xa0 || c == 0xd9 || c == 0xda || c == 0xdb:
+		var n int
+		switch c {
+		case 0xd9:
+			n = int(d.uint(1))
+		case 0xda:
+			n = int(d.uint(2))
+		case 0xdb:
+			n = int(d.uint(4))
+		default:
+			n = int(c & 0x1f)
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(d.r, buf); err != nil {
+			panic(err)
+		}
+		return string(buf)
+	case c&0xf0 == 0x90 || c == 0xdc || c == 0xdd:
+		n := d.length(c, 0x90, 0x0f, 0xdc)
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i] = d.decode()
+		}
+		return values
+	case c&0xf0 == 0x80 || c == 0xde || c == 0xdf:
+		n := d.length(c, 0x80, 0x0f, 0xde)
+		obj := newObject()
+		for i := 0; i < n; i++ {
+			key, ok := d.decode().(string)
+			if !ok {
+				panic("non-string map key")
+			}
+			obj.set(key, d.decode())
+		}
+		return obj
+	}
+	panic(fmt.Sprintf("unsupported msgpack byte 0x%02x", c))
+}
+
+func decodeMsgpack(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	d := &msgpackDecoder{r: bytes.NewReader(data)}
+	value := d.decode()
+	if d.r.Len() != 0 {
+		t.Fatalf("%d trailing bytes", d.r.Len())
+	}
+	return value
+}
+
+func encodeMsgpack(t *testing.T, singleton bool, rows []interface{}) []byte {
+	t.Helper()
+	var spill *os.File
+	if !singleton {
+		var err error
+		if spill, err = os.CreateTemp(t.TempDir(), "rows"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	e := newMsgpackEncoder(&buf, singleton, spill)
+	for _, row := range rows {
+		if err := e.encode(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.close(); err != nil {
+		t.Fatal(err)
+	}
+	if spill != nil {
+		if _, err := os.Stat(spill.Name()); !os.IsNotExist(err) {
+			t.Errorf("spill file %s is not removed", spill.Name())
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	long := strings.Repeat("长", 100)
+	var rows, want []interface{}
+	for i := 0; i < 20; i++ {
+		rows = append(rows, objectOf(
+			"id", int64Value(1000+i),
+			"name", "剑",
+			"desc", long,
+			"price", decimal("12.50"),
+			"big", uint64Value(math.MaxUint64-uint64(i)),
+			"neg", int64(-200*i),
+			"color", i%3,
+			"rate", 0.5,
+			"ok", i%2 == 0,
+			"values", []interface{}{int64(1), nil, int64(70000), int64(-40000)},
+			"attr", objectOf("a", int64(i), "b", nil),
+		))
+		want = append(want, objectOf(
+			"id", int64(1000+i),
+			"name", "剑",
+			"desc", long,
+			"price", "12.50",
+			"big", uint64(math.MaxUint64-uint64(i)),
+			"neg", int64(-200*i),
+			"color", int64(i%3),
+			"rate", 0.5,
+			"ok", i%2 == 0,
+			"values", []interface{}{int64(1), nil, int64(70000), int64(-40000)},
+			"attr", objectOf("a", int64(i), "b", nil),
+		))
+	}
+
+	tests := []struct {
+		name      string
+		singleton bool
+		rows      []interface{}
+		want      interface{}
+	}{
+		{"rows", false, rows, objectOf("rows", want)},
+		{"no rows", false, nil, objectOf("rows", []interface{}{})},
+		{"singleton", true, rows[:1], objectOf("row", want[0])},
+		{"empty singleton", true, nil, objectOf("row", newObject())},
+	}
+	for _, tt := range tests {
+		got := decodeMsgpack(t, encodeMsgpack(t, tt.singleton, tt.rows))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decoded value mismatch\ngot:  %#v\nwant: %#v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package xlsx
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// excel 中数字的有效位数
+const excelDigits = 15
+
+// int64Value 64 位有符号整数, 可以按导出目标的配置输出为字符串
+type int64Value int64
+
+// uint64Value 64 位无符号整数, 可以按导出目标的配置输出为字符串
+type uint64Value uint64
+
+// decimal 定点小数, 以 json 数字字面量输出, 没有二进制浮点误差
+type decimal string
+
+func (d decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d), nil
+}
+
+func parseDecimal(s string) (*big.Rat, bool) {
+	return new(big.Rat).SetString(strings.TrimSpace(s))
+}
+
+// makeDecimal 将单元格内容转换为 scale 位小数, 无法解析时为 0
+func makeDecimal(data string, scale int) decimal {
+	r, ok := parseDecimal(data)
+	if !ok {
+		r = new(big.Rat)
+	}
+	return decimal(r.FloatString(scale))
+}
+
+// is64BitInteger 判断整数类型是否为 64 位, int 和 uint 按 64 位处理
+func is64BitInteger(nodeType string) bool {
+	switch nodeType {
+	case "int", "int64", "uint", "uint64":
+		return true
+	}
+	return false
+}
+
+// parseInteger 按整数类型解析单元格内容, 64 位整数(见 is64BitInteger)返回 int64Value 和 uint64Value
+func parseInteger(nodeType, data string) interface{} {
+	data = strings.TrimSpace(data)
+	switch nodeType {
+	case "int", "int64":
+		i, _ := strconv.ParseInt(data, 10, 64)
+		return int64Value(i)
+	case "uint", "uint64":
+		u, _ := strconv.ParseUint(data, 10, 64)
+		return uint64Value(u)
+	case "uint8", "uint16", "uint32", "byte":
+		u, _ := strconv.ParseUint(data, 10, 64)
+		return int64(u)
+	}
+	i, _ := strconv.ParseInt(data, 10, 64)
+	return i
+}
+
+// parseFloat 解析浮点数: float32 保留能还原该 float32 值的最短表示,
+// precision >= 0 时先按 excel 的 15 位有效数字去掉二进制误差, 再四舍五入到指定的小数位数, 0.5 向远离零的方向舍入
+func parseFloat(nodeType, data string, precision int) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(data), 64)
+	if err != nil {
+		return 0
+	}
+	if nodeType == "float32" {
+		f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', -1, 32), 64)
+	}
+	if precision >= 0 {
+		// 按十进制四舍五入(与 excel 的 ROUND 相同), 直接按二进制值舍入时 1.005 会舍入为 1.00
+		if r, ok := parseDecimal(strconv.FormatFloat(f, 'g', excelDigits, 64)); ok {
+			f, _ = strconv.ParseFloat(r.FloatString(precision), 64)
+		}
+	}
+	return f
+}
+
+// int64ToString 将值中的 64 位整数转换为字符串, 用于不能精确表示 64 位整数的客户端(如 javascript)
+func int64ToString(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int64Value:
+		return strconv.FormatInt(int64(v), 10)
+	case uint64Value:
+		return strconv.FormatUint(uint64(v), 10)
+	case *object:
+		obj := newObject()
+		for _, key := range v.keys {
+			obj.set(key, int64ToString(v.values[key]))
+		}
+		return obj
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = int64ToString(v[i])
+		}
+		return values
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, x := range v {
+			m[key] = int64ToString(x)
+		}
+		return m
+	default:
+		return value
+	}
+}
@@ -0,0 +1,96 @@
+package xlsx
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseFloat(t *testing.T) {
+	for _, tt := range []struct {
+		nodeType  string
+		data      string
+		precision int
+		want      float64
+	}{
+		{"float64", "0.30000000000000004", -1, 0.30000000000000004},
+		{"float64", "0.30000000000000004", 2, 0.3},
+		{"float64", " 1.005 ", 2, 1.01},
+		{"float64", "2.675", 2, 2.68},
+		{"float64", "-2.5", 0, -3},
+		{"float64", "1234.5678", 0, 1235},
+		{"float64", "0.1", 20, 0.1},
+		{"float64", "abc", 2, 0},
+		{"float64", "", -1, 0},
+		{"float32", "0.1", -1, 0.1},
+		{"float32", "16777217", -1, 16777216},
+		{"float32", "3.14159", 2, 3.14},
+	} {
+		if got := parseFloat(tt.nodeType, tt.data, tt.precision); got != tt.want {
+			t.Errorf("parseFloat(%q, %q, %d) = %v, want %v", tt.nodeType, tt.data, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestMakeDecimal(t *testing.T) {
+	for _, tt := range []struct {
+		data  string
+		scale int
+		want  decimal
+	}{
+		{"12.5", 2, "12.50"},
+		{" 0.1 ", 1, "0.1"},
+		{"0.30000000000000004", 2, "0.30"},
+		{"1.005", 2, "1.01"},
+		{"-1.005", 2, "-1.01"},
+		{"1e3", 0, "1000"},
+		{"3/4", 2, "0.75"},
+		{"12345678901234567890.123", 1, "12345678901234567890.1"},
+		{"", 2, "0.00"},
+		{"abc", 0, "0"},
+	} {
+		if got := makeDecimal(tt.data, tt.scale); got != tt.want {
+			t.Errorf("makeDecimal(%q, %d) = %s, want %s", tt.data, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestParseInteger(t *testing.T) {
+	for _, tt := range []struct {
+		nodeType string
+		data     string
+		want     interface{}
+	}{
+		{"int64", " 9007199254740993 ", int64Value(9007199254740993)},
+		{"int", "-1", int64Value(-1)},
+		{"uint64", "18446744073709551615", uint64Value(math.MaxUint64)},
+		{"uint32", "4294967295", int64(math.MaxUint32)},
+		{"int32", "-7", int64(-7)},
+		{"int32", "1.5", int64(0)},
+		{"int64", "", int64Value(0)},
+	} {
+		if got := parseInteger(tt.nodeType, tt.data); got != tt.want {
+			t.Errorf("parseInteger(%q, %q) = %#v, want %#v", tt.nodeType, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestInt64ToString(t *testing.T) {
+	value := objectOf(
+		"id", int64Value(9007199254740993),
+		"big", uint64Value(math.MaxUint64),
+		"small", int64(1),
+		"values", []interface{}{int64Value(-1), nil},
+		"attr", map[string]interface{}{"a": uint64Value(2)},
+	)
+	want := objectOf(
+		"id", "9007199254740993",
+		"big", "18446744073709551615",
+		"small", int64(1),
+		"values", []interface{}{"-1", nil},
+		"attr", map[string]interface{}{"a": "2"},
+	)
+	if got := int64ToString(value); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
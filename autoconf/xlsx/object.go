@@ -0,0 +1,67 @@
+package xlsx
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// object 按字段声明顺序输出的 json 对象
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newObject() *object {
+	return &object{values: make(map[string]interface{})}
+}
+
+// set 设置字段值, 已存在的字段保持原来的位置
+func (obj *object) set(key string, value interface{}) {
+	if _, ok := obj.values[key]; !ok {
+		obj.keys = append(obj.keys, key)
+	}
+	obj.values[key] = value
+}
+
+func (obj *object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range obj.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(obj.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// plainValue 将 object 转换为 map, 用于模板等需要按字段名访问的场景
+func plainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *object:
+		m := make(map[string]interface{}, len(v.keys))
+		for _, key := range v.keys {
+			m[key] = plainValue(v.values[key])
+		}
+		return m
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = plainValue(v[i])
+		}
+		return values
+	default:
+		return value
+	}
+}
@@ -0,0 +1,71 @@
+package xlsx
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jokgame/tools/autoconf/manifest"
+	"github.com/jokgame/tools/autoconf/pack"
+)
+
+// stagePacks 将配置了 pack 的导出目标的所有表格打包为一个文件,
+// 返回配置了 manifest 的导出目标的打包文件, 用于写入清单
+func stagePacks(st *stage, cfg *Config, jobs []*exportJob) (map[string]*manifest.PackInfo, error) {
+	var sources = make(map[string][]pack.Source)
+	for _, job := range jobs {
+		for _, file := range job.exported {
+			if cfg.Export(file.Export).Pack {
+				sources[file.Export] = append(sources[file.Export], pack.Source{
+					Name:     job.bean.Name,
+					Codec:    file.Codec,
+					Filename: st.source(file.Path),
+				})
+			}
+		}
+	}
+	var packs = make(map[string]*manifest.PackInfo)
+	for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+		return exportConfig.Pack
+	}) {
+		if len(sources[export]) == 0 {
+			continue
+		}
+		dir := exportDir(cfg, export)
+		file, err := st.create(dir, export+".pack")
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		w := bufio.NewWriter(io.MultiWriter(file, h))
+		err = pack.Write(w, sources[export])
+		if err == nil {
+			err = w.Flush()
+		}
+		if e := file.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return nil, fmt.Errorf("write pack of %s error: %w", export, err)
+		}
+		info, err := os.Stat(file.Name())
+		if err != nil {
+			return nil, err
+		}
+		checksum := fmt.Sprintf("%02x", h.Sum(nil))
+		filename := export
+		if cfg.Export(export).Manifest != "" {
+			filename += "." + checksum
+			packs[export] = &manifest.PackInfo{
+				Filename: filename + ".pack",
+				Checksum: checksum,
+				Size:     info.Size(),
+			}
+		}
+		st.add(file.Name(), filepath.Join(dir, filename+".pack"), stageData)
+	}
+	return packs, nil
+}
@@ -0,0 +1,442 @@
+package xlsx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// protobuf 格式的导出数据, 每个协议的表格编码为消息 <Bean>Table:
+//
+//	message <Bean>Table { repeated <Bean> rows = 1; }  // 单例表为 <Bean> row = 1;
+//
+// 协议和结构体的每个字段(包括继承的字段)都必须用 pb 标签指定编号, 如 `pb:"1"`, 同一消息中的编号不能重复,
+// 修改字段顺序或增删字段不会改变其他字段的编号.
+// 整数和浮点数类型保持不变(int 为 int64, uint 为 uint64), 定点小数为 string, 避免二进制浮点误差.
+// 未设置的字段不输出, 数组中的 null 元素输出为零值
+
+// protobuf 字段编号的最大值, 19000 到 19999 为保留编号
+const maxProtoFieldNumber = 1<<29 - 1
+
+// protoSchema 由协议生成的 protobuf 消息和枚举
+type protoSchema struct {
+	pkg *build.Package
+	// 导出目标, 只包含导出到该目标的字段
+	export   string
+	messages map[*build.Bean]*protoMessage
+	// 按生成顺序排列的消息和枚举
+	beans []*build.Bean
+}
+
+// protoMessage 由协议或结构体生成的消息
+type protoMessage struct {
+	name   string
+	fields []*protoField
+}
+
+type protoField struct {
+	*schemaField
+	number int
+	// protobuf 类型名
+	typ     string
+	message *protoMessage
+}
+
+func newProtoSchema(pkg *build.Package, export string) *protoSchema {
+	return &protoSchema{
+		pkg:      pkg,
+		export:   export,
+		messages: make(map[*build.Bean]*protoMessage),
+	}
+}
+
+// message 返回 bean 对应的消息, 同时生成它依赖的消息和枚举
+func (s *protoSchema) message(bean *build.Bean) (*protoMessage, error) {
+	if m, ok := s.messages[bean]; ok {
+		return m, nil
+	}
+	m := &protoMessage{name: bean.Name}
+	s.messages[bean] = m
+	s.beans = append(s.beans, bean)
+	// 检查所有字段(包括没有导出到该目标的字段)的编号, 保证各导出目标的编号一致
+	all, err := schemaFieldsOfBean(s.pkg, bean, "")
+	if err != nil {
+		return nil, err
+	}
+	numbers := make(map[int]string, len(all))
+	for _, f := range all {
+		number, ok := pbOf(f.field)
+		if !ok {
+			return nil, fmt.Errorf("protobuf: field %s.%s: pb tag is required", bean.Name, f.name)
+		}
+		if name, dup := numbers[number]; dup {
+			return nil, fmt.Errorf("protobuf: field %s.%s: pb number %d duplicated with field %s", bean.Name, f.name, number, name)
+		}
+		numbers[number] = f.name
+	}
+	fields, err := schemaFieldsOfBean(s.pkg, bean, s.export)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		number, _ := pbOf(f.field)
+		pf := &protoField{
+			schemaField: f,
+			number:      number,
+			typ:         protoType(f),
+		}
+		switch f.kind {
+		case kindStruct:
+			if pf.message, err = s.message(f.bean); err != nil {
+				return nil, err
+			}
+		case kindEnum:
+			if _, ok := s.messages[f.bean]; !ok {
+				s.messages[f.bean] = nil
+				s.beans = append(s.beans, f.bean)
+			}
+		}
+		m.fields = append(m.fields, pf)
+	}
+	return m, nil
+}
+
+func protoType(f *schemaField) string {
+	switch f.kind {
+	case kindStruct, kindEnum:
+		return f.bean.Name
+	case kindDecimal:
+		return "string"
+	case kindBool, kindString:
+		return f.basic
+	case kindFloat:
+		if f.basic == "float32" {
+			return "float"
+		}
+		return "double"
+	}
+	switch f.basic {
+	case "int8", "int16", "int32":
+		return "int32"
+	case "uint8", "uint16", "uint32", "byte":
+		return "uint32"
+	case "uint", "uint64":
+		return "uint64"
+	}
+	return "int64"
+}
+
+// generateProto 生成导出目标中所有协议的表格的 .proto 文件
+func generateProto(pkg *build.Package, beans []*build.Bean, export string) ([]byte, error) {
+	s := newProtoSchema(pkg, export)
+	names := make(map[string]bool)
+	for _, bean := range beans {
+		if _, err := s.message(bean); err != nil {
+			return nil, err
+		}
+		names[bean.Name] = true
+	}
+	for _, bean := range beans {
+		if names[bean.Name+"Table"] {
+			return nil, fmt.Errorf("table message of protocol %s conflicts with %sTable", bean.Name, bean.Name)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by autoconf. DO NOT EDIT.\n\nsyntax = \"proto3\";\n\npackage %s;\n", pkg.Name)
+	for _, bean := range s.beans {
+		buf.WriteString("\n")
+		if bean.Kind == "enum" {
+			writeProtoEnum(&buf, bean)
+			continue
+		}
+		fmt.Fprintf(&buf, "message %s {\n", bean.Name)
+		for _, f := range s.messages[bean].fields {
+			if comment := getCommentContent(f.field.Comment); comment != "" {
+				fmt.Fprintf(&buf, "\t// %s\n", comment)
+			}
+			var repeated string
+			if f.size > 0 {
+				repeated = "repeated "
+			}
+			fmt.Fprintf(&buf, "\t%s%s %s = %d;\n", repeated, f.typ, f.name, f.number)
+		}
+		buf.WriteString("}\n")
+	}
+	for _, bean := range beans {
+		singleton, err := isSingleton(bean)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n")
+		fmt.Fprintf(&buf, "message %sTable {\n", bean.Name)
+		if singleton {
+			fmt.Fprintf(&buf, "\t%s row = 1;\n", bean.Name)
+		} else {
+			fmt.Fprintf(&buf, "\trepeated %s rows = 1;\n", bean.Name)
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// writeProtoEnum 生成枚举, 枚举项以枚举名为前缀避免冲突, 没有值为 0 的枚举项时增加 <Enum>_Unspecified = 0
+func writeProtoEnum(buf *bytes.Buffer, bean *build.Bean) {
+	values := enumValuesOf(bean)
+	seen := make(map[int]bool)
+	alias := false
+	for _, v := range values {
+		if seen[v.value] {
+			alias = true
+		}
+		seen[v.value] = true
+	}
+	fmt.Fprintf(buf, "enum %s {\n", bean.Name)
+	if alias {
+		buf.WriteString("\toption allow_alias = true;\n")
+	}
+	if !seen[0] {
+		fmt.Fprintf(buf, "\t%s_Unspecified = 0;\n", bean.Name)
+	}
+	// proto3 要求第一个枚举项的值为 0
+	sorted := make([]schemaEnumValue, len(values))
+	copy(sorted, values)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].value == 0 && sorted[j].value != 0
+	})
+	for _, v := range sorted {
+		fmt.Fprintf(buf, "\t%s_%s = %d;", bean.Name, v.name, v.value)
+		if v.desc != v.name {
+			fmt.Fprintf(buf, " // %s", v.desc)
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+}
+
+// protobufEncoder 逐行输出表格的 protobuf 数据, 每一行都是 <Bean>Table 的字段 1, 可以直接追加
+type protobufEncoder struct {
+	w       io.Writer
+	message *protoMessage
+	buf     []byte
+}
+
+func newProtobufEncoder(w io.Writer, pkg *build.Package, bean *build.Bean, export string) (*protobufEncoder, error) {
+	message, err := newProtoSchema(pkg, export).message(bean)
+	if err != nil {
+		return nil, err
+	}
+	return &protobufEncoder{
+		w:       w,
+		message: message,
+	}, nil
+}
+
+func (e *protobufEncoder) encode(value interface{}) error {
+	obj, ok := value.(*object)
+	if !ok {
+		return fmt.Errorf("protobuf: unexpected row type %T", value)
+	}
+	data, err := e.message.marshal(nil, obj)
+	if err != nil {
+		return err
+	}
+	e.buf = appendProtoTag(e.buf[:0], 1, protoBytes)
+	e.buf = appendProtoVarint(e.buf, uint64(len(data)))
+	e.buf = append(e.buf, data...)
+	_, err = e.w.Write(e.buf)
+	return err
+}
+
+func (e *protobufEncoder) close() error {
+	return nil
+}
+
+// protobuf wire type
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+func appendProtoVarint(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
+
+func appendProtoTag(b []byte, number, wireType int) []byte {
+	return appendProtoVarint(b, uint64(number)<<3|uint64(wireType))
+}
+
+// marshal 编码结构体的值
+func (m *protoMessage) marshal(b []byte, obj *object) ([]byte, error) {
+	for _, f := range m.fields {
+		value, ok := obj.values[f.key]
+		if !ok || value == nil {
+			continue
+		}
+		var err error
+		if f.size == 0 {
+			b, err = f.appendValue(b, value, true)
+		} else if values, ok := value.([]interface{}); !ok {
+			err = fmt.Errorf("unexpected value type %T", value)
+		} else {
+			b, err = f.appendRepeated(b, values)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("protobuf: field %s.%s: %w", m.name, f.name, err)
+		}
+	}
+	return b, nil
+}
+
+// packed 判断字段是否为 packed 编码的基础类型
+func (f *protoField) packed() bool {
+	return f.kind != kindStruct && f.kind != kindString && f.kind != kindDecimal
+}
+
+func (f *protoField) appendRepeated(b []byte, values []interface{}) ([]byte, error) {
+	if len(values) == 0 {
+		return b, nil
+	}
+	if !f.packed() {
+		for _, value := range values {
+			if value == nil {
+				if f.kind == kindStruct {
+					value = newObject()
+				} else {
+					value = ""
+				}
+			}
+			var err error
+			if b, err = f.appendValue(b, value, false); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	var packed []byte
+	for _, value := range values {
+		data, err := f.scalar(value)
+		if err != nil {
+			return nil, err
+		}
+		packed = append(packed, data...)
+	}
+	b = appendProtoTag(b, f.number, protoBytes)
+	b = appendProtoVarint(b, uint64(len(packed)))
+	return append(b, packed...), nil
+}
+
+// appendValue 编码字段的一个值, omitZero 为 true 时不输出基础类型的零值
+func (f *protoField) appendValue(b []byte, value interface{}, omitZero bool) ([]byte, error) {
+	switch f.kind {
+	case kindStruct:
+		obj, ok := value.(*object)
+		if !ok {
+			return nil, fmt.Errorf("unexpected value type %T", value)
+		}
+		data, err := f.message.marshal(nil, obj)
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtoTag(b, f.number, protoBytes)
+		b = appendProtoVarint(b, uint64(len(data)))
+		return append(b, data...), nil
+	case kindString, kindDecimal:
+		var s string
+		switch x := value.(type) {
+		case string:
+			s = x
+		case decimal:
+			s = string(x)
+		default:
+			return nil, fmt.Errorf("unexpected value type %T", value)
+		}
+		if omitZero && s == "" {
+			return b, nil
+		}
+		b = appendProtoTag(b, f.number, protoBytes)
+		b = appendProtoVarint(b, uint64(len(s)))
+		return append(b, s...), nil
+	}
+	data, err := f.scalar(value)
+	if err != nil {
+		return nil, err
+	}
+	if omitZero && isZeroBytes(data) {
+		return b, nil
+	}
+	switch f.typ {
+	case "float":
+		b = appendProtoTag(b, f.number, protoFixed32)
+	case "double":
+		b = appendProtoTag(b, f.number, protoFixed64)
+	default:
+		b = appendProtoTag(b, f.number, protoVarint)
+	}
+	return append(b, data...), nil
+}
+
+// scalar 编码数字, 布尔和枚举类型的值, nil 编码为零值
+func (f *protoField) scalar(value interface{}) ([]byte, error) {
+	switch f.typ {
+	case "float", "double":
+		var v float64
+		switch x := value.(type) {
+		case nil:
+		case float64:
+			v = x
+		default:
+			return nil, fmt.Errorf("unexpected value type %T", value)
+		}
+		if f.typ == "float" {
+			return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(v))), nil
+		}
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)), nil
+	case "bool":
+		v, _ := value.(bool)
+		if v {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	}
+	var v uint64
+	switch x := value.(type) {
+	case nil:
+	case int:
+		v = uint64(x)
+	case int64:
+		v = uint64(x)
+	case int64Value:
+		v = uint64(x)
+	case uint64Value:
+		v = uint64(x)
+	default:
+		return nil, fmt.Errorf("unexpected value type %T", value)
+	}
+	if f.typ == "int32" || f.kind == kindEnum {
+		// int32 和枚举的负数按 64 位符号扩展编码
+		if i := int64(v); i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, fmt.Errorf("value %d out of range of %s", i, f.typ)
+		}
+	} else if f.typ == "uint32" && v > math.MaxUint32 {
+		return nil, fmt.Errorf("value %d out of range of %s", v, f.typ)
+	}
+	return appendProtoVarint(nil, v), nil
+}
+
+// isZeroBytes 判断编码后的值是否为零值
+func isZeroBytes(data []byte) bool {
+	for _, c := range data {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
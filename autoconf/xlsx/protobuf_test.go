@@ -0,0 +1,221 @@
+package xlsx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/midlang/mid/src/mid/build"
+	"github.com/midlang/mid/src/mid/lexer"
+)
+
+func testField(name string, t build.Type, tag string) *build.Field {
+	return &build.Field{Names: []string{name}, Type: t, Tag: build.Tag(tag)}
+}
+
+func testEnumValue(name string, value int) *build.Field {
+	return &build.Field{Names: []string{name}, Default: &build.BasicLit{Kind: lexer.INT, Value: fmt.Sprint(value)}}
+}
+
+func testArray(t build.Type, size int) build.Type {
+	return &build.ArrayType{T: t, Size: &build.BasicLit{Kind: lexer.INT, Value: fmt.Sprint(size)}}
+}
+
+// testProtoPackage 返回包含协议 Item 的包, nameTag 为 Item 的 name 字段的标签
+func testProtoPackage(nameTag string) (*build.Package, *build.Bean) {
+	basic := func(name string) build.Type { return &build.BasicType{Name: name} }
+	item := &build.Bean{Kind: "protocol", Name: "Item", Fields: []*build.Field{
+		testField("id", basic("int"), `pb:"1"`),
+		testField("name", basic("string"), nameTag),
+		testField("price", basic("float64"), `decimal:"2" pb:"3"`),
+		testField("values", testArray(basic("int32"), 3), `pb:"4"`),
+		testField("attr", &build.StructType{Name: "Attr"}, `pb:"5"`),
+		testField("attrs", testArray(&build.StructType{Name: "Attr"}, 2), `pb:"6"`),
+		testField("color", &build.StructType{Name: "Color"}, `pb:"7"`),
+		testField("rate", basic("float32"), `pb:"8"`),
+		testField("ok", basic("bool"), `pb:"9"`),
+		testField("big", basic("uint64"), `pb:"10"`),
+		testField("neg", basic("int32"), `pb:"20000"`),
+	}}
+	beans := []*build.Bean{
+		{Kind: "enum", Name: "Color", Fields: []*build.Field{testEnumValue("Red", 1), testEnumValue("Blue", 2)}},
+		{Kind: "struct", Name: "Attr", Fields: []*build.Field{
+			testField("a", basic("int32"), `pb:"1"`),
+			testField("b", basic("string"), `pb:"2"`),
+		}},
+		item,
+	}
+	return &build.Package{Name: "demo", Files: []*build.File{{Filename: "demo.mid", Package: "demo", Beans: beans}}}, item
+}
+
+// protoWire 测试中解码的一个 protobuf 字段, varint 和定长类型的值为 uint64, 其他为 []byte
+type protoWire struct {
+	number int
+	value  interface{}
+}
+
+func decodeProto(t *testing.T, data []byte) []protoWire {
+	t.Helper()
+	var fields []protoWire
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatalf("invalid tag")
+		}
+		data = data[n:]
+		field := protoWire{number: int(tag >> 3)}
+		switch tag & 7 {
+		case protoVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				t.Fatalf("invalid varint of field %d", field.number)
+			}
+			field.value, data = v, data[n:]
+		case protoFixed32:
+			field.value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case protoFixed64:
+			field.value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				t.Fatalf("invalid length of field %d", field.number)
+			}
+			field.value, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// decodePackedVarints 解码 packed 编码的整数
+func decodePackedVarints(data []byte) []uint64 {
+	var values []uint64
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		values = append(values, v)
+		data = data[n:]
+	}
+	return values
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	pkg, item := testProtoPackage(`pb:"2"`)
+	var buf bytes.Buffer
+	e, err := newProtobufEncoder(&buf, pkg, item, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []*object{
+		objectOf(
+			"id", int64Value(1001),
+			"name", "剑",
+			"price", decimal("12.50"),
+			"values", []interface{}{int64(1), nil, int64(-3)},
+			"attr", objectOf("a", int64(5), "b", "x"),
+			"attrs", []interface{}{nil, objectOf("a", int64(0), "b", "")},
+			"color", 2,
+			"rate", 0.25,
+			"ok", true,
+			"big", uint64Value(math.MaxUint64),
+			"neg", int64(-5),
+		),
+		// 零值不输出
+		objectOf("id", int64Value(0), "name", "", "ok", false, "attr", objectOf()),
+	}
+	for _, row := range rows {
+		if err := e.encode(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	table := decodeProto(t, buf.Bytes())
+	if len(table) != len(rows) {
+		t.Fatalf("got %d rows, want %d", len(table), len(rows))
+	}
+	for _, row := range table {
+		if row.number != 1 {
+			t.Fatalf("got table field %d, want 1", row.number)
+		}
+	}
+
+	attr := func(a uint64, b string) []byte {
+		var data []byte
+		if a != 0 {
+			data = appendProtoVarint(appendProtoTag(data, 1, protoVarint), a)
+		}
+		if b != "" {
+			data = append(appendProtoVarint(appendProtoTag(data, 2, protoBytes), uint64(len(b))), b...)
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		return data
+	}
+	want := []protoWire{
+		{1, uint64(1001)},
+		{2, []byte("剑")},
+		{3, []byte("12.50")},
+		{4, []uint64{1, 0, uint64(math.MaxUint64 - 2)}},
+		{5, attr(5, "x")},
+		{6, attr(0, "")},
+		{6, attr(0, "")},
+		{7, uint64(2)},
+		{8, uint64(math.Float32bits(0.25))},
+		{9, uint64(1)},
+		{10, uint64(math.MaxUint64)},
+		{20000, uint64(math.MaxUint64 - 4)},
+	}
+	got := decodeProto(t, table[0].value.([]byte))
+	for i := range got {
+		if got[i].number == 4 {
+			got[i].value = decodePackedVarints(got[i].value.([]byte))
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("row 0 mismatch\ngot:  %v\nwant: %v", got, want)
+	}
+	if got := decodeProto(t, table[1].value.([]byte)); !reflect.DeepEqual(got, []protoWire{{5, []byte{}}}) {
+		t.Errorf("row 1: got %v, want only empty attr", got)
+	}
+}
+
+func TestProtobufFieldNumbers(t *testing.T) {
+	for _, tt := range []struct {
+		tag string
+		err string
+	}{
+		{``, "pb tag is required"},
+		{`pb:"3"`, "pb number 3 duplicated"},
+	} {
+		pkg, item := testProtoPackage(tt.tag)
+		if _, err := generateProto(pkg, []*build.Bean{item}, ""); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("tag %q: got error %v, want %q", tt.tag, err, tt.err)
+		}
+	}
+
+	pkg, item := testProtoPackage(`pb:"2"`)
+	content, err := generateProto(pkg, []*build.Bean{item}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"\tint64 id = 1;\n",
+		"\tstring price = 3;\n",
+		"\trepeated int32 values = 4;\n",
+		"\tColor_Unspecified = 0;\n",
+		"\trepeated Item rows = 1;\n",
+	} {
+		if !bytes.Contains(content, []byte(line)) {
+			t.Errorf("generated proto does not contain %q:\n%s", line, content)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rowReader 流式读取 xlsx 文件中的一个工作表, 每次只解析一行.
+// 共享字符串表和单元格样式在打开时读入, 工作表本身不会整体加载到内存,
+// 单元格的值与 excelize 的 GetRows 一致
+type rowReader struct {
+	strings []sharedString
+	numFmts []int
+	rc      io.ReadCloser
+	decoder *xml.Decoder
+	// 没有 r 属性时下一行的行号
+	next int
+}
+
+type sharedString struct {
+	text string
+	// 富文本不应用单元格的数字格式
+	rich bool
+}
+
+type xlsxRow struct {
+	R int        `xml:"r,attr"`
+	C []xlsxCell `xml:"c"`
+}
+
+type xlsxCell struct {
+	R  string `xml:"r,attr"`
+	S  int    `xml:"s,attr"`
+	T  string `xml:"t,attr"`
+	V  string `xml:"v"`
+	IS struct {
+		T string `xml:"t"`
+	} `xml:"is"`
+}
+
+// openRowReader 打开 xlsx 文件中名为 sheetName 的工作表, 工作表不存在时没有任何行
+func openRowReader(r io.ReaderAt, size int64, sheetName string) (*rowReader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	reader := new(rowReader)
+	if err := reader.readSharedStrings(files); err != nil {
+		return nil, err
+	}
+	if err := reader.readStyles(files); err != nil {
+		return nil, err
+	}
+	name, err := sheetPath(files, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := files[name]; ok {
+		reader.rc, err = f.Open()
+		if err != nil {
+			return nil, err
+		}
+		reader.decoder = xml.NewDecoder(reader.rc)
+	}
+	return reader, nil
+}
+
+// Close 关闭工作表
+func (reader *rowReader) Close() error {
+	if reader.rc == nil {
+		return nil
+	}
+	return reader.rc.Close()
+}
+
+// Next 读取下一行, 返回行号(从 0 开始)和各列的值, 读完时返回 io.EOF.
+// 行中缺少的单元格为空字符串, 工作表中没有出现的行被跳过
+func (reader *rowReader) Next() (int, []string, error) {
+	if reader.decoder == nil {
+		return 0, nil, io.EOF
+	}
+	for {
+		token, err := reader.decoder.Token()
+		if err != nil {
+			return 0, nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		var row xlsxRow
+		if err := reader.decoder.DecodeElement(&row, &start); err != nil {
+			return 0, nil, err
+		}
+		index := reader.next
+		if row.R > 0 {
+			index = row.R - 1
+		}
+		reader.next = index + 1
+
+		var values []string
+		col := -1
+		for i := range row.C {
+			cell := &row.C[i]
+			if cell.R != "" {
+				col = columnIndex(cell.R)
+			} else {
+				col++
+			}
+			if col < 0 {
+				return 0, nil, fmt.Errorf("invalid cell reference %q", cell.R)
+			}
+			for len(values) <= col {
+				values = append(values, "")
+			}
+			values[col] = reader.value(cell)
+		}
+		return index, values, nil
+	}
+}
+
+func (reader *rowReader) value(cell *xlsxCell) string {
+	switch cell.T {
+	case "s":
+		i, _ := strconv.Atoi(cell.V)
+		if i < 0 || i >= len(reader.strings) {
+			return ""
+		}
+		if reader.strings[i].rich {
+			return reader.strings[i].text
+		}
+		return reader.format(cell.S, reader.strings[i].text)
+	case "inlineStr":
+		return reader.format(cell.S, cell.IS.T)
+	default:
+		return reader.format(cell.S, cell.V)
+	}
+}
+
+// format 按单元格样式的内置数字格式格式化单元格的值
+func (reader *rowReader) format(style int, v string) string {
+	if style <= 0 || style >= len(reader.numFmts) {
+		return v
+	}
+	return formatNumber(reader.numFmts[style], v)
+}
+
+func (reader *rowReader) readSharedStrings(files map[string]*zip.File) error {
+	f, ok := files["xl/sharedStrings.xml"]
+	if !ok {
+		if f, ok = files["xl/SharedStrings.xml"]; !ok {
+			return nil
+		}
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read shared strings error: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "si" {
+			continue
+		}
+		var si struct {
+			T string `xml:"t"`
+			R []struct {
+				T string `xml:"t"`
+			} `xml:"r"`
+		}
+		if err := decoder.DecodeElement(&si, &start); err != nil {
+			return fmt.Errorf("read shared strings error: %w", err)
+		}
+		s := sharedString{text: si.T}
+		if len(si.R) > 0 {
+			var b strings.Builder
+			for _, r := range si.R {
+				b.WriteString(r.T)
+			}
+			s = sharedString{text: b.String(), rich: true}
+		}
+		reader.strings = append(reader.strings, s)
+	}
+}
+
+func (reader *rowReader) readStyles(files map[string]*zip.File) error {
+	var styles struct {
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := decodePart(files, "xl/styles.xml", &styles); err != nil {
+		return fmt.Errorf("read styles error: %w", err)
+	}
+	reader.numFmts = make([]int, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		reader.numFmts[i] = xf.NumFmtID
+	}
+	return nil
+}
+
+// sheetPath 根据 workbook 及其关系文件查找工作表在压缩包中的路径
+func sheetPath(files map[string]*zip.File, sheetName string) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodePart(files, "xl/workbook.xml", &workbook); err != nil {
+		return "", fmt.Errorf("read workbook error: %w", err)
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodePart(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", fmt.Errorf("read workbook relationships error: %w", err)
+	}
+	for _, sheet := range workbook.Sheets {
+		if sheet.Name != sheetName {
+			continue
+		}
+		for _, attr := range sheet.Attrs {
+			if attr.Name.Local != "id" {
+				continue
+			}
+			for _, rel := range rels.Relationships {
+				if rel.ID != attr.Value {
+					continue
+				}
+				if strings.HasPrefix(rel.Target, "/") {
+					return rel.Target[1:], nil
+				}
+				return path.Join("xl", rel.Target), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// decodePart 解析压缩包中的 xml 文件, 文件不存在时不做任何事
+func decodePart(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// columnIndex 将单元格引用(如 AB12)的列转换为从 0 开始的序号
+func columnIndex(ref string) int {
+	col := 0
+	for _, c := range ref {
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c < 'A' || c > 'Z' {
+			continue
+		}
+		col = col*26 + int(c-'A') + 1
+	}
+	return col - 1
+}
+
+// excel 内置的日期时间格式
+var builtinTimeFormats = map[int]string{
+	14: "mm-dd-yy",
+	15: "d-mmm-yy",
+	16: "d-mmm",
+	17: "mmm-yy",
+	18: "h:mm am/pm",
+	19: "h:mm:ss am/pm",
+	20: "h:mm",
+	21: "h:mm:ss",
+	22: "m/d/yy h:mm",
+	45: "mm:ss",
+	46: "[h]:mm:ss",
+	47: "mmss.0",
+}
+
+// formatNumber 按 excel 内置数字格式格式化数字, 非数字或其他格式原样返回
+func formatNumber(numFmtID int, v string) string {
+	switch numFmtID {
+	case 1, 2, 3, 4, 9, 10, 11, 37, 38, 39, 40, 48:
+	default:
+		if _, ok := builtinTimeFormats[numFmtID]; !ok {
+			return v
+		}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return v
+	}
+	switch numFmtID {
+	case 1, 3:
+		return fmt.Sprintf("%d", int(f))
+	case 2, 4:
+		return fmt.Sprintf("%.2f", f)
+	case 9:
+		return fmt.Sprintf("%d%%", int(f*100))
+	case 10:
+		return fmt.Sprintf("%.2f%%", f*100)
+	case 11, 48:
+		return fmt.Sprintf("%.e", f)
+	case 37, 38:
+		if f < 0 {
+			return fmt.Sprintf("(%d)", int(math.Abs(f)))
+		}
+		return fmt.Sprintf("%d", int(f))
+	case 39, 40:
+		if f < 0 {
+			return fmt.Sprintf("(%.2f)", f)
+		}
+		return fmt.Sprintf("%.2f", f)
+	}
+	return formatTime(builtinTimeFormats[numFmtID], timeFromExcelTime(f))
+}
+
+// formatTime 将 excel 的日期时间格式转换为 go 的格式后格式化时间
+func formatTime(format string, t time.Time) string {
+	replacements := []struct{ xltime, gotime string }{
+		{"yyyy", "2006"},
+		{"yy", "06"},
+		{"mmmm", "%%%%"},
+		{"dddd", "&&&&"},
+		{"dd", "02"},
+		{"d", "2"},
+		{"mmm", "Jan"},
+		{"mmss", "0405"},
+		{"ss", "05"},
+		{"mm:", "04:"},
+		{":mm", ":04"},
+		{"mm", "01"},
+		{"am/pm", "pm"},
+		{"m/", "1/"},
+		{"%%%%", "January"},
+		{"&&&&", "Monday"},
+	}
+	if strings.Contains(format, "am/pm") {
+		format = strings.Replace(format, "hh", "03", 1)
+		format = strings.Replace(format, "h", "3", 1)
+	} else {
+		format = strings.Replace(format, "hh", "15", 1)
+		format = strings.Replace(format, "h", "15", 1)
+	}
+	for _, repl := range replacements {
+		format = strings.Replace(format, repl.xltime, repl.gotime, 1)
+	}
+	// 小时可省略时去掉小时部分
+	if t.Hour() < 1 {
+		format = strings.Replace(format, "]:", "]", 1)
+		format = strings.Replace(format, "[03]", "", 1)
+		format = strings.Replace(format, "[3]", "", 1)
+		format = strings.Replace(format, "[15]", "", 1)
+	} else {
+		format = strings.Replace(format, "[3]", "3", 1)
+		format = strings.Replace(format, "[15]", "15", 1)
+	}
+	return t.Format(format)
+}
+
+// timeFromExcelTime 将 excel 的日期数值(1900 日期系统)转换为时间,
+// 1900 年 3 月 1 日之前使用儒略历
+func timeFromExcelTime(excelTime float64) time.Time {
+	const maxDurationDays int64 = 106750
+	intPart := int64(excelTime)
+	if intPart <= 61 {
+		const offset1900 = 15018.0
+		const mjd0 = 2400000.5
+		return julianDateToGregorianTime(mjd0, excelTime+offset1900)
+	}
+	floatPart := excelTime - float64(intPart)
+	var dayNanoSeconds float64 = 24 * 60 * 60 * 1000 * 1000 * 1000
+	date := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	for intPart > maxDurationDays {
+		date = date.Add(time.Duration(maxDurationDays) * time.Hour * 24)
+		intPart -= maxDurationDays
+	}
+	durationDays := time.Duration(intPart) * time.Hour * 24
+	durationPart := time.Duration(dayNanoSeconds * floatPart)
+	return date.Add(durationDays).Add(durationPart)
+}
+
+func julianDateToGregorianTime(part1, part2 float64) time.Time {
+	part1I, part1F := math.Modf(part1)
+	part2I, part2F := math.Modf(part2)
+	julianDays := part1I + part2I
+	julianFraction := part1F + part2F
+	switch {
+	case -0.5 < julianFraction && julianFraction < 0.5:
+		julianFraction += 0.5
+	case julianFraction >= 0.5:
+		julianDays++
+		julianFraction -= 0.5
+	case julianFraction <= -0.5:
+		julianDays--
+		julianFraction += 1.5
+	}
+
+	// Fliegel & Van Flandern 算法
+	l := int(julianDays) + 68569
+	n := (4 * l) / 146097
+	l = l - (146097*n+3)/4
+	i := (4000 * (l + 1)) / 1461001
+	l = l - (1461*i)/4 + 31
+	j := (80 * l) / 2447
+	day := l - (2447*j)/80
+	l = j / 11
+	month := j + 2 - (12 * l)
+	year := 100*(n-49) + i + l
+
+	const c1us, c1s = 1e3, 1e9
+	const c1day = 24 * 60 * 60 * c1s
+	frac := int64(c1day*julianFraction + c1us/2)
+	nanoseconds := int((frac%c1s)/c1us) * c1us
+	frac /= c1s
+	seconds := int(frac % 60)
+	frac /= 60
+	minutes := int(frac % 60)
+	hours := int(frac / 60)
+	return time.Date(year, time.Month(month), day, hours, minutes, seconds, nanoseconds, time.UTC)
+}
@@ -0,0 +1,119 @@
+package xlsx
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+)
+
+// newFixtureWorkbook 创建包含各种单元格的工作簿: 字符串, 整数, 浮点数, 布尔值,
+// 内置数字格式, 缺少的单元格和缺少的行
+func newFixtureWorkbook(t *testing.T, sheet string) *bytes.Buffer {
+	t.Helper()
+	file := excelize.NewFile()
+	if sheet != "Sheet1" {
+		file.SetSheetName("Sheet1", sheet)
+	}
+	file.SetSheetRow(sheet, "A1", &[]interface{}{"id", "name", "price", "rate", "date", "ok"})
+	file.SetSheetRow(sheet, "A2", &[]interface{}{"int", "string", "float", "float", "int", "bool"})
+	file.SetSheetRow(sheet, "A3", &[]interface{}{1001, "剑", 0.30000000000000004, 0.125, 43466, true})
+	file.SetSheetRow(sheet, "A4", &[]interface{}{1002, " 盾 ", 12.5, 1, 43831.5, false})
+	// 缺少的单元格和行
+	file.SetCellValue(sheet, "A6", 9007199254740993)
+	file.SetCellValue(sheet, "C6", -3.75)
+	file.SetCellValue(sheet, "H6", "x\"y<&>")
+	file.SetCellValue(sheet, "B8", "")
+	file.SetCellValue(sheet, "A9", 1003)
+
+	percent, err := file.NewStyle(`{"number_format": 10}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date, err := file.NewStyle(`{"number_format": 14}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed, err := file.NewStyle(`{"number_format": 2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.SetCellStyle(sheet, "D3", "D4", percent)
+	file.SetCellStyle(sheet, "E3", "E4", date)
+	file.SetCellStyle(sheet, "C6", "C6", fixed)
+
+	buf, err := file.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// readAllRows 使用 rowReader 读取工作表, 按行号排列, 缺少的行为 nil
+func readAllRows(t *testing.T, content []byte, sheet string) [][]string {
+	t.Helper()
+	reader, err := openRowReader(bytes.NewReader(content), int64(len(content)), sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var rows [][]string
+	for {
+		index, values, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for len(rows) <= index {
+			rows = append(rows, nil)
+		}
+		rows[index] = values
+	}
+	return rows
+}
+
+// trimRows 移除各行末尾的空单元格和末尾的空行, GetRows 按最长的行补齐了所有行
+func trimRows(rows [][]string) [][]string {
+	var result [][]string
+	for _, row := range rows {
+		n := len(row)
+		for n > 0 && row[n-1] == "" {
+			n--
+		}
+		if n == 0 {
+			result = append(result, nil)
+		} else {
+			result = append(result, row[:n])
+		}
+	}
+	for len(result) > 0 && result[len(result)-1] == nil {
+		result = result[:len(result)-1]
+	}
+	return result
+}
+
+func TestRowReaderMatchesGetRows(t *testing.T) {
+	for _, sheet := range []string{"Sheet1", "Item"} {
+		content := newFixtureWorkbook(t, sheet).Bytes()
+		file, err := excelize.OpenReader(bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := trimRows(file.GetRows(sheet))
+		got := trimRows(readAllRows(t, content, sheet))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("sheet %s: rows mismatch\ngot:  %q\nwant: %q", sheet, got, want)
+		}
+	}
+}
+
+func TestRowReaderMissingSheet(t *testing.T) {
+	content := newFixtureWorkbook(t, "Sheet1").Bytes()
+	if rows := readAllRows(t, content, "Missing"); len(rows) != 0 {
+		t.Errorf("missing sheet: got %q, want no rows", rows)
+	}
+}
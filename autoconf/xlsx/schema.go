@@ -0,0 +1,285 @@
+package xlsx
+
+import (
+	"fmt"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// schemaField 协议或结构体的字段在导出数据中的类型, 用于生成其他格式的数据和代码
+type schemaField struct {
+	field *build.Field
+	// 字段名
+	name string
+	// 在导出数据中的字段名, 由 name 标签指定
+	key string
+	// 字段在所有字段(包括继承的字段)中的序号, 从 0 开始
+	index int
+	// 字段的值类型, 数组字段为元素的类型
+	kind valueKind
+	// 基础类型名, 如 int32, string
+	basic string
+	// 结构体或枚举类型
+	bean *build.Bean
+	// 数组大小, 0 表示不是数组
+	size int
+}
+
+// schemaFieldsOfBean 返回协议或结构体导出到 export 的字段, 包括继承的字段, 不包括 `name:"-"` 的字段.
+// export 为空时返回所有导出目标的字段
+func schemaFieldsOfBean(pkg *build.Package, bean *build.Bean, export string) ([]*schemaField, error) {
+	fields, err := fieldsOfBean(pkg, bean)
+	if err != nil {
+		return nil, err
+	}
+	var result []*schemaField
+	for i, field := range fields {
+		f := &schemaField{
+			field: field,
+			name:  fieldName(field),
+			key:   fieldName(field),
+			index: i,
+		}
+		if tag := field.GetTag("name"); tag == "-" || !fieldExported(field, export) {
+			continue
+		} else if tag != "" {
+			f.key = tag
+		}
+		t := field.Type
+		if t.IsArray() {
+			array := t.(*build.ArrayType)
+			size, ok := build.ParseIntFromExpr(array.Size)
+			if !ok || size < 1 {
+				return nil, fmt.Errorf("invalid size of array field '%s.%s::%s'", pkg.Name, bean.Name, f.name)
+			}
+			f.size = size
+			t = array.T
+		}
+		switch {
+		case t.IsStruct():
+			f.bean = pkg.FindBean(t.(*build.StructType).Name)
+			if f.bean == nil {
+				return nil, fmt.Errorf("type of field '%s.%s::%s' not found", pkg.Name, bean.Name, f.name)
+			}
+			switch f.bean.Kind {
+			case "enum":
+				f.kind = kindEnum
+			case "protocol", "struct":
+				f.kind = kindStruct
+			default:
+				return nil, fmt.Errorf("invalid type of field '%s.%s::%s'", pkg.Name, bean.Name, f.name)
+			}
+		case isBasicType(t):
+			f.basic = buildBasicType(t)
+			if _, ok := decimalOf(field); ok {
+				f.kind = kindDecimal
+			} else if t.IsInt() {
+				f.kind = kindInteger
+			} else if t.IsFloat() {
+				f.kind = kindFloat
+			} else if t.IsBool() {
+				f.kind = kindBool
+			} else {
+				f.kind = kindString
+			}
+		default:
+			return nil, fmt.Errorf("unsupported type of field '%s.%s::%s'", pkg.Name, bean.Name, f.name)
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// schemaEnumValue 枚举的一项
+type schemaEnumValue struct {
+	name  string
+	desc  string
+	value int
+	// 枚举项是否有值, 没有值时 value 为 0
+	hasValue bool
+}
+
+// enumValuesOf 返回枚举的所有项
+func enumValuesOf(bean *build.Bean) []schemaEnumValue {
+	values := make([]schemaEnumValue, 0, len(bean.Fields))
+	for _, f := range bean.Fields {
+		value, ok := build.ParseIntFromExpr(f.Default)
+		values = append(values, schemaEnumValue{
+			name:     fieldName(f),
+			desc:     descOfEnum(f),
+			value:    value,
+			hasValue: ok,
+		})
+	}
+	return values
+}
+
+// fieldFilter 从一行数据中移除没有导出到某个导出目标的字段, 见字段的 export 标签
+type fieldFilter struct {
+	// 保留的字段, 值为结构体字段的过滤器, 非结构体字段为 nil
+	keys map[string]*fieldFilter
+}
+
+// newFieldFilter 返回协议 bean 的数据导出到 export 时的过滤器, 所有字段都导出时返回 nil
+func newFieldFilter(pkg *build.Package, bean *build.Bean, export string) (*fieldFilter, error) {
+	filtered := false
+	filter, err := recFieldFilter(pkg, bean, export, make(map[*build.Bean]*fieldFilter), &filtered)
+	if err != nil || !filtered {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// recFieldFilter 返回 bean 的过滤器, 有字段被移除时设置 filtered.
+// 过滤器在递归之前就记入 visited, 引用自身的结构体在嵌套层级中复用同一个过滤器
+func recFieldFilter(pkg *build.Package, bean *build.Bean, export string, visited map[*build.Bean]*fieldFilter, filtered *bool) (*fieldFilter, error) {
+	if filter, ok := visited[bean]; ok {
+		return filter, nil
+	}
+	all, err := schemaFieldsOfBean(pkg, bean, "")
+	if err != nil {
+		return nil, err
+	}
+	filter := &fieldFilter{keys: make(map[string]*fieldFilter, len(all))}
+	visited[bean] = filter
+	for _, f := range all {
+		if !fieldExported(f.field, export) {
+			*filtered = true
+			continue
+		}
+		var child *fieldFilter
+		if f.kind == kindStruct {
+			if child, err = recFieldFilter(pkg, f.bean, export, visited, filtered); err != nil {
+				return nil, err
+			}
+		}
+		filter.keys[f.key] = child
+	}
+	return filter, nil
+}
+
+// apply 返回移除了没有导出的字段的数据
+func (filter *fieldFilter) apply(value interface{}) interface{} {
+	if filter == nil {
+		return value
+	}
+	switch v := value.(type) {
+	case *object:
+		obj := newObject()
+		for _, key := range v.keys {
+			if child, ok := filter.keys[key]; ok {
+				obj.set(key, child.apply(v.values[key]))
+			}
+		}
+		return obj
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = filter.apply(v[i])
+		}
+		return values
+	}
+	return value
+}
+
+// schemaTable 导出到某个导出目标的协议的表格, 用于生成加载导出数据的代码
+type schemaTable struct {
+	bean      *build.Bean
+	singleton bool
+	// key 字段, 为 nil 时表示 key 字段不导出
+	key *schemaField
+	// 有 index 标签的字段
+	indexes []*schemaField
+	// 表格结构的哈希, 与清单中的 schema_hash 相同
+	schemaHash string
+}
+
+// schemaTablesOf 返回导出到 export 的所有协议的表格, 以及它们依赖的所有 bean(包括协议本身), bean 按声明顺序排列
+func schemaTablesOf(pkg *build.Package, export string) ([]*schemaTable, []*build.Bean, error) {
+	var tables []*schemaTable
+	used := make(map[*build.Bean]bool)
+	for _, bean := range protocolsOfExport(pkg, export) {
+		t, err := newSchemaTable(pkg, bean, export)
+		if err != nil {
+			return nil, nil, err
+		}
+		tables = append(tables, t)
+		if err := useSchemaBean(pkg, bean, export, used); err != nil {
+			return nil, nil, err
+		}
+	}
+	var beans []*build.Bean
+	for _, file := range pkg.Files {
+		for _, bean := range file.Beans {
+			if used[bean] {
+				beans = append(beans, bean)
+			}
+		}
+	}
+	return tables, beans, nil
+}
+
+// newSchemaTable 返回协议 bean 导出到 export 的表格
+func newSchemaTable(pkg *build.Package, bean *build.Bean, export string) (*schemaTable, error) {
+	singleton, err := isSingleton(bean)
+	if err != nil {
+		return nil, err
+	}
+	t := &schemaTable{
+		bean:       bean,
+		singleton:  singleton,
+		schemaHash: schemaHash(pkg, bean),
+	}
+	fields, err := schemaFieldsOfBean(pkg, bean, export)
+	if err != nil {
+		return nil, err
+	}
+	key := keyFieldOfBean(pkg, bean)
+	for _, f := range fields {
+		if f.size > 0 {
+			continue
+		}
+		if f.field == key {
+			t.key = f
+		} else if indexOf(f.field) != indexNone {
+			t.indexes = append(t.indexes, f)
+		}
+	}
+	return t, nil
+}
+
+// useSchemaBean 标记 bean 及其继承和引用的枚举和结构体
+func useSchemaBean(pkg *build.Package, bean *build.Bean, export string, used map[*build.Bean]bool) error {
+	if used[bean] {
+		return nil
+	}
+	used[bean] = true
+	if bean.Kind == "enum" {
+		return nil
+	}
+	for _, t := range bean.Extends {
+		st, ok := t.(*build.StructType)
+		if !ok {
+			return fmt.Errorf("invalid extends of %s", bean.Name)
+		}
+		b := pkg.FindBean(st.Name)
+		if b == nil {
+			return fmt.Errorf("type %s not found", st.Name)
+		}
+		if err := useSchemaBean(pkg, b, export, used); err != nil {
+			return err
+		}
+	}
+	fields, err := schemaFieldsOfBean(pkg, bean, export)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.bean != nil {
+			if err := useSchemaBean(pkg, f.bean, export, used); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
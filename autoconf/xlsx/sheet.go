@@ -0,0 +1,258 @@
+package xlsx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// ErrEmptySheet 表单中没有表头
+var ErrEmptySheet = errors.New("empty sheet")
+
+// Table 一个协议的表格数据
+type Table struct {
+	// 协议名称
+	Name string
+	// 单例表只有一行数据, 导出为 {"row": ...}, 否则导出为 {"rows": [...]}
+	Singleton bool
+	// 每一行的 key, 与 Rows 一一对应
+	Keys []string
+	// 每一行的数据
+	Rows []interface{}
+}
+
+// Value 返回表格导出的数据
+func (table *Table) Value() interface{} {
+	if table.Singleton {
+		if len(table.Rows) == 0 {
+			return map[string]interface{}{
+				"row": map[string]interface{}{},
+			}
+		}
+		return map[string]interface{}{
+			"row": table.Rows[0],
+		}
+	}
+	return map[string]interface{}{
+		"rows": table.Rows,
+	}
+}
+
+// ConvertSheet 读取 excel 文件中协议 bean 的表格数据, 表单没有数据时返回 ErrEmptySheet
+func ConvertSheet(pkg *build.Package, bean *build.Bean, r io.Reader) (*Table, error) {
+	ra, size, err := readerAt(r)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := NewSheetReader(pkg, bean, ra, size)
+	if err != nil {
+		return nil, err
+	}
+	defer sheet.Close()
+
+	table := &Table{
+		Name:      bean.Name,
+		Singleton: sheet.Singleton,
+		Keys:      []string{},
+		Rows:      []interface{}{},
+	}
+	for {
+		key, value, err := sheet.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		table.Keys = append(table.Keys, key)
+		table.Rows = append(table.Rows, value)
+	}
+	return table, nil
+}
+
+// SheetReader 逐行读取协议的表格数据, 内存占用只与表格的列数有关
+type SheetReader struct {
+	// 协议名称
+	Name string
+	// 单例表只有一行数据
+	Singleton bool
+
+	rows   *rowReader
+	plan   *rowPlan
+	closer io.Closer
+	keys   map[string]bool
+	count  int
+	// 下一个数据行的行号
+	index int
+	// 预读的下一个非空行, pendingIndex 小于 0 时没有预读
+	pendingIndex int
+	pending      []string
+}
+
+// NewSheetReader 打开 excel 文件中协议 bean 的表格, 表单没有数据时返回 ErrEmptySheet
+func NewSheetReader(pkg *build.Package, bean *build.Bean, r io.ReaderAt, size int64) (*SheetReader, error) {
+	if err := checkBean(pkg, bean); err != nil {
+		return nil, err
+	}
+	singleton, err := isSingleton(bean)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := openRowReader(r, size, defaultSheetName)
+	if err != nil {
+		return nil, err
+	}
+	sheet := &SheetReader{
+		Name:         bean.Name,
+		Singleton:    singleton,
+		rows:         rows,
+		keys:         make(map[string]bool),
+		index:        2,
+		pendingIndex: -1,
+	}
+	// 首行为标注, 之后至少要有一个非空行
+	var header []string
+	for sheet.pendingIndex < 0 {
+		index, values, err := rows.Next()
+		if err == io.EOF {
+			rows.Close()
+			return nil, ErrEmptySheet
+		} else if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if index == 0 {
+			header = values
+		} else if !isEmptyRow(values) {
+			sheet.pendingIndex = index
+			sheet.pending = values
+		}
+	}
+	if sheet.pendingIndex < sheet.index {
+		// 标题行
+		sheet.pendingIndex = -1
+		sheet.pending = nil
+	}
+	sheet.plan = compileRowPlan(pkg, bean, buildJSONNodes(nil, pkg, bean, commentsOfRow(header), header))
+	return sheet, nil
+}
+
+// Close 关闭表格
+func (sheet *SheetReader) Close() error {
+	err := sheet.rows.Close()
+	if sheet.closer != nil {
+		if e := sheet.closer.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Next 返回下一行数据的 key 和值, 读完时返回 io.EOF
+func (sheet *SheetReader) Next() (string, interface{}, error) {
+	for {
+		row, err := sheet.nextRow()
+		if err != nil {
+			return "", nil, err
+		}
+		key, value := sheet.plan.convert(row)
+		if key == "" || value == nil {
+			continue
+		}
+		if sheet.keys[key] {
+			return "", nil, fmt.Errorf("id %q duplicated in table %s", key, sheet.Name)
+		}
+		sheet.keys[key] = true
+		sheet.count++
+		if sheet.Singleton && sheet.count > 1 {
+			return "", nil, fmt.Errorf("singleton %s has more than one values", sheet.Name)
+		}
+		return key, value, nil
+	}
+}
+
+// nextRow 按顺序返回下一个数据行, 中间缺少或为空的行返回 nil,
+// 最后一个非空行之后的行被忽略
+func (sheet *SheetReader) nextRow() ([]string, error) {
+	for sheet.pendingIndex < 0 {
+		index, values, err := sheet.rows.Next()
+		if err != nil {
+			return nil, err
+		}
+		if index >= sheet.index && !isEmptyRow(values) {
+			sheet.pendingIndex = index
+			sheet.pending = values
+		}
+	}
+	sheet.index++
+	if sheet.index <= sheet.pendingIndex {
+		return nil, nil
+	}
+	row := sheet.pending
+	sheet.pendingIndex = -1
+	sheet.pending = nil
+	return row, nil
+}
+
+func isEmptyRow(values []string) bool {
+	for _, value := range values {
+		if value != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// openSheet 打开 excel 文件中协议 bean 的表格
+func openSheet(pkg *build.Package, bean *build.Bean, filename string) (*SheetReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sheet, err := NewSheetReader(pkg, bean, f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sheet.closer = f
+	return sheet, nil
+}
+
+func readerAt(r io.Reader) (io.ReaderAt, int64, error) {
+	switch r := r.(type) {
+	case *bytes.Reader:
+		return r, r.Size(), nil
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		return r, info.Size(), nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+func isSingleton(bean *build.Bean) (bool, error) {
+	tagSingleton := bean.GetTag("singleton")
+	if tagSingleton == "" {
+		return false, nil
+	}
+	singleton, err := strconv.ParseBool(tagSingleton)
+	if err != nil {
+		return false, fmt.Errorf("invalid singleton tag of %s: %w", bean.Name, err)
+	}
+	return singleton, nil
+}
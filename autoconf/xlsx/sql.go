@@ -0,0 +1,368 @@
+package xlsx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// sql 脚本, 用于将表格导入服务器的数据库. 配置了 sql 的导出目标生成两个文件:
+//
+//	<dir>/<export>.schema.sql  建表语句, 表不存在时创建
+//	<dir>/<export>.seed.sql    插入所有行的数据, key 相同的行更新为表格中的数据, 可以重复执行
+//
+// 表的结构见 dbTable, 主键为 key 字段, json 列的类型为 JSON(mysql) 或 JSONB(postgres)
+
+// sqlDialect 数据库的 sql 方言
+type sqlDialect string
+
+// 支持的 sql 方言
+const (
+	sqlMySQL    sqlDialect = "mysql"
+	sqlPostgres sqlDialect = "postgres"
+)
+
+func (d sqlDialect) quote(name string) string {
+	if d == sqlMySQL {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// typ 返回列的类型, 由字段的基础类型决定, indexed 表示列为主键或带有索引
+func (d sqlDialect) typ(c *dbColumn, indexed bool) string {
+	mysql := d == sqlMySQL
+	if c.json {
+		if mysql {
+			return "JSON"
+		}
+		return "JSONB"
+	}
+	switch c.field.kind {
+	case kindEnum:
+		if mysql {
+			return "INT"
+		}
+		return "INTEGER"
+	case kindBool:
+		return "BOOLEAN"
+	case kindDecimal:
+		scale, _ := decimalOf(c.field.field)
+		return fmt.Sprintf("DECIMAL(38,%d)", scale)
+	case kindString:
+		if mysql && indexed {
+			// mysql 的 TEXT 列不能作为主键或不指定前缀长度建索引
+			return "VARCHAR(255)"
+		}
+		return "TEXT"
+	}
+	switch c.field.basic {
+	case "int8":
+		if mysql {
+			return "TINYINT"
+		}
+		return "SMALLINT"
+	case "int16":
+		return "SMALLINT"
+	case "int32":
+		if mysql {
+			return "INT"
+		}
+		return "INTEGER"
+	case "uint8", "byte":
+		if mysql {
+			return "TINYINT UNSIGNED"
+		}
+		return "SMALLINT"
+	case "uint16":
+		if mysql {
+			return "SMALLINT UNSIGNED"
+		}
+		return "INTEGER"
+	case "uint32":
+		if mysql {
+			return "INT UNSIGNED"
+		}
+		return "BIGINT"
+	case "uint", "uint64":
+		if mysql {
+			return "BIGINT UNSIGNED"
+		}
+		return "NUMERIC(20)"
+	case "float32":
+		if mysql {
+			return "FLOAT"
+		}
+		return "REAL"
+	case "float64":
+		if mysql {
+			return "DOUBLE"
+		}
+		return "DOUBLE PRECISION"
+	}
+	return "BIGINT"
+}
+
+// literal 返回列 c 的值的 sql 字面量
+func (d sqlDialect) literal(c *dbColumn, value interface{}) (string, error) {
+	if value == nil {
+		return "NULL", nil
+	}
+	if c.json {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return d.quoteString(string(data))
+	}
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int64Value:
+		return strconv.FormatInt(int64(v), 10), nil
+	case uint64Value:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", fmt.Errorf("unsupported value %v of column %s", v, c.name)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case decimal:
+		return string(v), nil
+	case string:
+		return d.quoteString(v)
+	}
+	return "", fmt.Errorf("unsupported value type %T of column %s", value, c.name)
+}
+
+func (d sqlDialect) quoteString(s string) (string, error) {
+	if d == sqlMySQL {
+		s = strings.NewReplacer(`\`, `\\`, `'`, `''`, "\x00", `\0`, "\x1a", `\Z`).Replace(s)
+		return "'" + s + "'", nil
+	}
+	if strings.IndexByte(s, 0) >= 0 {
+		return "", errors.New("postgres does not support NUL character in string")
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'", nil
+}
+
+// writeCreate 写入建表和建索引的语句
+func (d sqlDialect) writeCreate(buf *bytes.Buffer, table *dbTable) {
+	name := d.quote(table.bean.Name)
+	fmt.Fprintf(buf, "CREATE TABLE IF NOT EXISTS %s (\n", name)
+	for _, c := range table.columns {
+		fmt.Fprintf(buf, "  %s %s", d.quote(c.name), d.typ(c, table.indexed(c)))
+		if c == table.key {
+			buf.WriteString(" NOT NULL")
+		}
+		buf.WriteString(",\n")
+	}
+	fmt.Fprintf(buf, "  PRIMARY KEY (%s)", d.quote(table.key.name))
+	if d == sqlMySQL {
+		for _, c := range table.uniques {
+			fmt.Fprintf(buf, ",\n  UNIQUE KEY %s (%s)", d.quote(table.bean.Name+"_"+c.name), d.quote(c.name))
+		}
+		for _, c := range table.indexes {
+			fmt.Fprintf(buf, ",\n  KEY %s (%s)", d.quote(table.bean.Name+"_"+c.name), d.quote(c.name))
+		}
+		buf.WriteString("\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n")
+		return
+	}
+	buf.WriteString("\n);\n")
+	for _, c := range table.uniques {
+		fmt.Fprintf(buf, "CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s);\n",
+			d.quote(table.bean.Name+"_"+c.name), name, d.quote(c.name))
+	}
+	for _, c := range table.indexes {
+		fmt.Fprintf(buf, "CREATE INDEX IF NOT EXISTS %s ON %s (%s);\n",
+			d.quote(table.bean.Name+"_"+c.name), name, d.quote(c.name))
+	}
+}
+
+// upsert 返回插入一行的语句的前缀和后缀, 中间为各列的值. key 相同的行更新其他列
+func (d sqlDialect) upsert(table *dbTable) (string, string) {
+	names := make([]string, len(table.columns))
+	var updates []string
+	for i, c := range table.columns {
+		names[i] = d.quote(c.name)
+		if c == table.key {
+			continue
+		}
+		if d == sqlMySQL {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", names[i], names[i]))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", names[i], names[i]))
+		}
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES (", d.quote(table.bean.Name), strings.Join(names, ", "))
+	if d == sqlMySQL {
+		if len(updates) == 0 {
+			key := d.quote(table.key.name)
+			updates = append(updates, fmt.Sprintf("%s = %s", key, key))
+		}
+		return prefix, ") ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ") + ";\n"
+	}
+	suffix := fmt.Sprintf(") ON CONFLICT (%s) DO ", d.quote(table.key.name))
+	if len(updates) == 0 {
+		return prefix, suffix + "NOTHING;\n"
+	}
+	return prefix, suffix + "UPDATE SET " + strings.Join(updates, ", ") + ";\n"
+}
+
+// sqlSeedEncoder 将表格的每一行以 upsert 语句逐行写入临时文件, 生成数据脚本时再合并
+type sqlSeedEncoder struct {
+	w              io.Writer
+	d              sqlDialect
+	table          *dbTable
+	prefix, suffix string
+	buf            bytes.Buffer
+}
+
+func newSQLSeedEncoder(w io.Writer, d sqlDialect, table *dbTable) (*sqlSeedEncoder, error) {
+	if table.key == nil {
+		return nil, fmt.Errorf("key field of protocol %s is not a column", table.bean.Name)
+	}
+	e := &sqlSeedEncoder{w: w, d: d, table: table}
+	e.prefix, e.suffix = d.upsert(table)
+	return e, nil
+}
+
+func (e *sqlSeedEncoder) encode(row interface{}) error {
+	e.buf.Reset()
+	e.buf.WriteString(e.prefix)
+	for i, value := range e.table.values(row) {
+		s, err := e.d.literal(e.table.columns[i], value)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			e.buf.WriteString(", ")
+		}
+		e.buf.WriteString(s)
+	}
+	e.buf.WriteString(e.suffix)
+	_, err := e.w.Write(e.buf.Bytes())
+	return err
+}
+
+func (e *sqlSeedEncoder) close() error {
+	return nil
+}
+
+// stageSQLScripts 为配置了 sql 的导出目标生成建表语句和数据脚本.
+// 表格的数据来自导出时写入的临时文件, 使用了缓存的协议重新读取表格.
+// sql 方言, 协议列表和各协议的表格都没有变化且脚本都已存在时不重新生成
+func stageSQLScripts(pkg *build.Package, cfg *Config, st *stage, cache *exportCache, jobs []*exportJob) error {
+	for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+		return exportConfig.SQL != ""
+	}) {
+		dir := exportDir(cfg, export)
+		schemaFile := filepath.Join(dir, export+".schema.sql")
+		seedFile := filepath.Join(dir, export+".seed.sql")
+		d := sqlDialect(cfg.Export(export).SQL)
+		dbJobs := dbJobsOf(jobs, export)
+		if cache.generatedUpToDate(schemaFile, dbFingerprint("sql "+string(d), dbJobs), schemaFile, seedFile) {
+			continue
+		}
+		if err := writeSQLScripts(pkg, st, d, export, dbJobs, schemaFile, seedFile); err != nil {
+			return fmt.Errorf("generate sql of %s error: %w", export, err)
+		}
+	}
+	return nil
+}
+
+func writeSQLScripts(pkg *build.Package, st *stage, d sqlDialect, export string, jobs []*exportJob, schemaFile, seedFile string) error {
+	var schema bytes.Buffer
+	schema.WriteString("-- Code generated by autoconf. DO NOT EDIT.\n")
+	for _, job := range jobs {
+		table, err := newDBTable(pkg, job.bean, export)
+		if err != nil {
+			return err
+		}
+		if table.key == nil {
+			return fmt.Errorf("key field of protocol %s is not a column", job.bean.Name)
+		}
+		schema.WriteString("\n")
+		d.writeCreate(&schema, table)
+	}
+	if err := st.writeFile(schemaFile, schema.Bytes(), stageGenerated); err != nil {
+		return err
+	}
+
+	file, err := st.create(filepath.Dir(seedFile), filepath.Base(seedFile))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	err = writeSQLSeed(pkg, w, d, export, jobs)
+	if err == nil {
+		err = w.Flush()
+	}
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	st.add(file.Name(), seedFile, stageGenerated)
+	return nil
+}
+
+// writeSQLSeed 写入数据脚本, 各行来自导出时写入的临时文件, 没有时重新读取协议的表格
+func writeSQLSeed(pkg *build.Package, w io.Writer, d sqlDialect, export string, jobs []*exportJob) error {
+	io.WriteString(w, "-- Code generated by autoconf. DO NOT EDIT.\n\n")
+	if d == sqlMySQL {
+		io.WriteString(w, "START TRANSACTION;\n")
+	} else {
+		io.WriteString(w, "BEGIN;\n")
+	}
+	for _, job := range jobs {
+		io.WriteString(w, "\n")
+		if files := job.dbRows[export]; files != nil && files.seed != "" {
+			if err := copyFile(w, files.seed); err != nil {
+				return err
+			}
+			continue
+		}
+		table, err := newDBTable(pkg, job.bean, export)
+		if err != nil {
+			return err
+		}
+		enc, err := newSQLSeedEncoder(w, d, table)
+		if err != nil {
+			return err
+		}
+		if err := readDBRows(pkg, job, enc.encode); err != nil {
+			return fmt.Errorf("read rows of %s error: %w", job.bean.Name, err)
+		}
+	}
+	_, err := io.WriteString(w, "\nCOMMIT;\n")
+	return err
+}
+
+func copyFile(w io.Writer, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
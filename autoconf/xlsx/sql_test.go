@@ -0,0 +1,68 @@
+package xlsx
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestExportSQL 比较 testdata/golden 中的表格生成的 sql 脚本与 testdata/golden/sql/<dialect> 中的脚本
+func TestExportSQL(t *testing.T) {
+	for _, d := range []sqlDialect{sqlMySQL, sqlPostgres} {
+		outdir := t.TempDir()
+		cfg := &Config{
+			XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+			Outdir:  outdir,
+			Cache:   "-",
+			Exports: map[string]*ExportConfig{"server": {SQL: string(d)}},
+		}
+		if err := ExportJSON(goldenPackage(), cfg); err != nil {
+			t.Fatalf("%s: %v", d, err)
+		}
+		for _, name := range []string{"server.schema.sql", "server.seed.sql"} {
+			want, err := os.ReadFile(filepath.Join("testdata", "golden", "sql", string(d), name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(filepath.Join(outdir, "server", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: %s mismatch\ngot:\n%s\nwant:\n%s", d, name, got, want)
+			}
+		}
+	}
+}
+
+func TestSQLQuoteString(t *testing.T) {
+	for _, tt := range []struct {
+		d    sqlDialect
+		s    string
+		want string
+	}{
+		{sqlMySQL, "it's", `'it''s'`},
+		{sqlMySQL, `a\b`, `'a\\b'`},
+		{sqlMySQL, "a\x00b\x1a", `'a\0b\Z'`},
+		{sqlMySQL, "多\n行", "'多\n行'"},
+		{sqlPostgres, "it's", `'it''s'`},
+		{sqlPostgres, `a\b`, `'a\b'`},
+		{sqlPostgres, "a\x00b", ""},
+	} {
+		got, err := tt.d.quoteString(tt.s)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s %q: got %s, want error", tt.d, tt.s, got)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("%s %q: got %s, %v, want %s", tt.d, tt.s, got, err, tt.want)
+		}
+	}
+	if got := sqlMySQL.quote("a`b"); got != "`a``b`" {
+		t.Errorf("got mysql name %s", got)
+	}
+	if got := sqlPostgres.quote(`a"b`); got != `"a""b"` {
+		t.Errorf("got postgres name %s", got)
+	}
+}
@@ -0,0 +1,261 @@
+package xlsx
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/midlang/mid/src/mid/build"
+	_ "modernc.org/sqlite"
+)
+
+// sqlite 数据库 <dir>/<export>.sqlite 包含导出到该目标的所有协议的表格, 用于数据分析和 GM 工具.
+// 表的结构见 dbTable, 数组字段可以用 json_each 等 json 函数查询.
+// 整数, 枚举和布尔值为 INTEGER, 浮点数为 REAL, 定点小数为 NUMERIC, 字符串和 json 为 TEXT,
+// 超出 int64 范围的 uint64 为 TEXT. key 字段建唯一索引.
+// 数据库在一个事务中按固定的顺序创建, 相同的数据总是生成相同的文件
+
+// sqliteType 返回列在 sqlite 中的类型
+func sqliteType(c *dbColumn) string {
+	if c.json {
+		return "TEXT"
+	}
+	switch c.field.kind {
+	case kindInteger, kindEnum, kindBool:
+		return "INTEGER"
+	case kindFloat:
+		return "REAL"
+	case kindDecimal:
+		return "NUMERIC"
+	}
+	return "TEXT"
+}
+
+// sqliteValue 将列 c 的值转换为 sqlite 驱动支持的值
+func sqliteValue(c *dbColumn, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if c.json {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	switch v := value.(type) {
+	case bool, int64, float64, string:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int64Value:
+		return int64(v), nil
+	case uint64Value:
+		if v > math.MaxInt64 {
+			return strconv.FormatUint(uint64(v), 10), nil
+		}
+		return int64(v), nil
+	case decimal:
+		return string(v), nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T of column %s", value, c.name)
+}
+
+func quoteSQLiteName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqliteCreate 返回建表和建索引的语句
+func sqliteCreate(table *dbTable) []string {
+	name := quoteSQLiteName(table.bean.Name)
+	columns := make([]string, len(table.columns))
+	for i, c := range table.columns {
+		columns[i] = quoteSQLiteName(c.name) + " " + sqliteType(c)
+	}
+	stmts := []string{fmt.Sprintf("CREATE TABLE %s (%s)", name, strings.Join(columns, ", "))}
+	uniques := table.uniques
+	if table.key != nil {
+		uniques = append([]*dbColumn{table.key}, uniques...)
+	}
+	for _, c := range uniques {
+		stmts = append(stmts, fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)",
+			quoteSQLiteName(table.bean.Name+"_"+c.name), name, quoteSQLiteName(c.name)))
+	}
+	for _, c := range table.indexes {
+		stmts = append(stmts, fmt.Sprintf("CREATE INDEX %s ON %s (%s)",
+			quoteSQLiteName(table.bean.Name+"_"+c.name), name, quoteSQLiteName(c.name)))
+	}
+	return stmts
+}
+
+// sqliteInsert 返回插入一行的语句
+func sqliteInsert(table *dbTable) string {
+	names := make([]string, len(table.columns))
+	params := make([]string, len(table.columns))
+	for i, c := range table.columns {
+		names[i] = quoteSQLiteName(c.name)
+		params[i] = "?"
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteSQLiteName(table.bean.Name), strings.Join(names, ", "), strings.Join(params, ", "))
+}
+
+// sqliteRowsEncoder 将表格的每一行转换为各列的值, 以 json 数组逐行写入临时文件, 生成数据库时再插入
+type sqliteRowsEncoder struct {
+	w     io.Writer
+	table *dbTable
+}
+
+func newSQLiteRowsEncoder(w io.Writer, table *dbTable) *sqliteRowsEncoder {
+	return &sqliteRowsEncoder{w: w, table: table}
+}
+
+func (e *sqliteRowsEncoder) encode(row interface{}) error {
+	values, err := sqliteValues(e.table, row)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+func (e *sqliteRowsEncoder) close() error {
+	return nil
+}
+
+// sqliteValues 返回一行数据中各列在 sqlite 中的值
+func sqliteValues(table *dbTable, row interface{}) ([]interface{}, error) {
+	values := table.values(row)
+	for i, c := range table.columns {
+		value, err := sqliteValue(c, values[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// stageSQLite 为配置了 sqlite 的导出目标生成 sqlite 数据库 <dir>/<export>.sqlite.
+// 表格的数据来自导出时写入的临时文件, 使用了缓存的协议重新读取表格.
+// 协议列表和各协议的表格都没有变化且数据库已存在时不重新生成
+func stageSQLite(pkg *build.Package, cfg *Config, st *stage, cache *exportCache, jobs []*exportJob) error {
+	for _, export := range exportsWhere(cfg, func(exportConfig *ExportConfig) bool {
+		return exportConfig.SQLite
+	}) {
+		filename := filepath.Join(exportDir(cfg, export), export+".sqlite")
+		dbJobs := dbJobsOf(jobs, export)
+		if cache.generatedUpToDate(filename, dbFingerprint("sqlite", dbJobs), filename) {
+			continue
+		}
+		if err := writeSQLite(pkg, st, filename, export, dbJobs); err != nil {
+			return fmt.Errorf("write sqlite %s error: %w", filename, err)
+		}
+	}
+	return nil
+}
+
+func writeSQLite(pkg *build.Package, st *stage, filename, export string, jobs []*exportJob) error {
+	file, err := st.create(filepath.Dir(filename), filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	tmpfile := file.Name()
+	if err := file.Close(); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+tmpfile+"?_pragma=journal_mode(off)&_pragma=synchronous(off)")
+	if err != nil {
+		return err
+	}
+	err = writeSQLiteTables(pkg, db, export, jobs)
+	if e := db.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	st.add(tmpfile, filename, stageGenerated)
+	return nil
+}
+
+func writeSQLiteTables(pkg *build.Package, db *sql.DB, export string, jobs []*exportJob) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, job := range jobs {
+		table, err := newDBTable(pkg, job.bean, export)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range sqliteCreate(table) {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("create table %s error: %w", job.bean.Name, err)
+			}
+		}
+		if err := insertSQLiteRows(pkg, tx, table, job, export); err != nil {
+			return fmt.Errorf("insert rows of %s error: %w", job.bean.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// insertSQLiteRows 插入协议的所有行, 数据来自导出时写入的临时文件, 没有时重新读取协议的表格
+func insertSQLiteRows(pkg *build.Package, tx *sql.Tx, table *dbTable, job *exportJob, export string) error {
+	stmt, err := tx.Prepare(sqliteInsert(table))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	files := job.dbRows[export]
+	if files == nil || files.sqlite == "" {
+		return readDBRows(pkg, job, func(row interface{}) error {
+			values, err := sqliteValues(table, row)
+			if err == nil {
+				_, err = stmt.Exec(values...)
+			}
+			return err
+		})
+	}
+	file, err := os.Open(files.sqlite)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	dec := json.NewDecoder(bufio.NewReader(file))
+	dec.UseNumber()
+	for {
+		var values []interface{}
+		if err := dec.Decode(&values); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for i, value := range values {
+			// 整数按 int64 插入, 保证超出 float64 精度的整数不变
+			if n, ok := value.(json.Number); ok {
+				if v, err := n.Int64(); err == nil {
+					values[i] = v
+				} else if values[i], err = n.Float64(); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return err
+		}
+	}
+}
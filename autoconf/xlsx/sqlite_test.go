@@ -0,0 +1,115 @@
+package xlsx
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// querySQLite 执行查询, 每一行的各列以 "|" 连接, NULL 输出为 NULL
+func querySQLite(t *testing.T, filename, query string) []string {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+filename+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			t.Fatal(err)
+		}
+		cells := make([]string, len(values))
+		for i, value := range values {
+			switch v := value.(type) {
+			case nil:
+				cells[i] = "NULL"
+			case []byte:
+				cells[i] = string(v)
+			default:
+				cells[i] = fmt.Sprint(v)
+			}
+		}
+		result = append(result, strings.Join(cells, "|"))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// TestExportSQLite 导出 testdata/golden 中的表格到 sqlite 数据库, 再次导出时使用缓存:
+// 表格都没有变化时不重新生成数据库, 有表格变化时其他表格的数据从缓存的协议的表格重新读取
+func TestExportSQLite(t *testing.T) {
+	outdir := t.TempDir()
+	cfg := &Config{
+		XlsxDir: filepath.Join("testdata", "golden", "xlsx"),
+		Outdir:  outdir,
+		Cache:   filepath.Join(t.TempDir(), "cache.json"),
+		Exports: map[string]*ExportConfig{"server": {SQLite: true}},
+	}
+	pkg := goldenPackage()
+	filename := filepath.Join(outdir, "server", "server.sqlite")
+	want := map[string][]string{
+		`SELECT id, name, "pos.x", color, price, attrs FROM Item ORDER BY rowid`: {
+			`1001|剑|1|1|12.5|[{"a":1,"b":"x"},{"a":2,"b":"y"}]`,
+			`1002||0|0|0|[{"a":0,"b":""},{"a":0,"b":""}]`,
+			`9007199254740993|<b>&"q"|-1|3|-0.5|[{"a":0,"b":""},{"a":5,"b":"z"}]`,
+			`1003| 前后空格 |0|0|3|[{"a":0,"b":""},{"a":0,"b":""}]`,
+			`1004||0|2|100|[{"a":0,"b":""},{"a":0,"b":""}]`,
+		},
+		`SELECT Item.id, value FROM Item, json_each(Item."values") WHERE value > 1 ORDER BY Item.rowid, key`: {
+			"1001|2", "1001|3", "9007199254740993|7", "1003|2147483647",
+		},
+		`SELECT * FROM Stone ORDER BY rowid`: {"2001|石头|3|5", "2002||0|0", "2003||9|4294967295"},
+		`SELECT * FROM "Global"`:             {"3|hello"},
+		`SELECT count(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'Item'`: {"1"},
+	}
+	var previous os.FileInfo
+	for _, tt := range []struct {
+		name    string
+		prepare func()
+		// 是否重新生成数据库
+		regenerated bool
+	}{
+		{"export", func() {}, true},
+		{"unchanged", func() {}, false},
+		{"table changed", func() { pkg.Files[0].Beans[len(pkg.Files[0].Beans)-1].Comment = "// 全局配置" }, true},
+	} {
+		tt.prepare()
+		if err := ExportJSON(pkg, cfg); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if regenerated := previous == nil || !os.SameFile(previous, info); regenerated != tt.regenerated {
+			t.Errorf("%s: database regenerated %v, want %v", tt.name, regenerated, tt.regenerated)
+		}
+		previous = info
+		for query, rows := range want {
+			if got := querySQLite(t, filename, query); !reflect.DeepEqual(got, rows) {
+				t.Errorf("%s: %s\ngot:  %q\nwant: %q", tt.name, query, got, rows)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package xlsx
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 暂存文件的提交顺序, 先提交数据文件, 最后提交引用它们的清单
+const (
+	stageData      = iota // 导出的 json 文件
+	stageGenerated        // 根据表格生成的代码文件
+	stageHistory          // 历史清单
+	stageManifest         // 导出目标的清单
+	stagePrune            // 删除不再引用的文件
+	stageCache            // 增量导出的缓存
+)
+
+// stage 暂存导出过程中的所有输出文件, 全部生成后再通过重命名替换目标文件,
+// 导出失败时目标文件保持不变. 暂存目录建在目标文件所在的目录中,
+// 保证与目标文件在同一个文件系统上
+type stage struct {
+	mu sync.Mutex
+	// 目标目录到暂存目录的映射
+	dirs  map[string]string
+	files []stagedFile
+	seq   int
+}
+
+type stagedFile struct {
+	// 为空表示删除目标文件
+	tmpfile  string
+	target   string
+	priority int
+}
+
+func newStage() *stage {
+	return &stage{dirs: make(map[string]string)}
+}
+
+// create 在目标目录 dir 的暂存目录中创建一个临时文件, 写完后调用 add 暂存为目标文件
+func (s *stage) create(dir, name string) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	filename, err := s.tempName(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("create file %s error: %w", filename, err)
+	}
+	return file, nil
+}
+
+// tempName 返回目标目录 dir 的暂存目录中一个新的文件名, 暂存目录不存在时创建
+func (s *stage) tempName(dir, name string) (string, error) {
+	stageDir, ok := s.dirs[dir]
+	if !ok {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("mkdirall %s error: %w", dir, err)
+		}
+		var err error
+		stageDir, err = os.MkdirTemp(dir, ".autoconf-stage-")
+		if err != nil {
+			return "", fmt.Errorf("create stage directory in %s error: %w", dir, err)
+		}
+		s.dirs[dir] = stageDir
+	}
+	s.seq++
+	return filepath.Join(stageDir, fmt.Sprintf("%d-%s", s.seq, name)), nil
+}
+
+// add 将 create 创建的临时文件暂存为目标文件 target
+func (s *stage) add(tmpfile, target string, priority int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = append(s.files, stagedFile{
+		tmpfile:  tmpfile,
+		target:   target,
+		priority: priority,
+	})
+}
+
+// source 返回目标文件 target 提交后的内容所在的文件, 已暂存时为暂存的临时文件, 否则为 target 本身
+func (s *stage) source(target string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.files) - 1; i >= 0; i-- {
+		if s.files[i].target == target && s.files[i].tmpfile != "" {
+			return s.files[i].tmpfile
+		}
+	}
+	return target
+}
+
+// remove 提交时删除目标文件 target
+func (s *stage) remove(target string, priority int) {
+	s.add("", target, priority)
+}
+
+// writeFile 暂存目标文件 target 的内容
+func (s *stage) writeFile(target string, data []byte, priority int) error {
+	dir, name := filepath.Split(target)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := s.create(filepath.Clean(dir), name)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return fmt.Errorf("write file %s error: %w", target, err)
+	}
+	s.add(file.Name(), target, priority)
+	return nil
+}
+
+// commit 按优先级依次将暂存的文件重命名为目标文件并删除需要删除的文件, 然后删除暂存目录.
+// 被替换和删除的目标文件先移到暂存目录中备份, 提交失败时恢复所有已经提交的目标文件
+func (s *stage) commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.SliceStable(s.files, func(i, j int) bool {
+		return s.files[i].priority < s.files[j].priority
+	})
+	// 已经提交的目标文件及其备份, 备份为空表示目标文件原来不存在
+	var committed [][2]string
+	rollback := func() {
+		for i := len(committed) - 1; i >= 0; i-- {
+			target, backup := committed[i][0], committed[i][1]
+			if backup == "" {
+				os.Remove(target)
+			} else if err := os.Rename(backup, target); err != nil {
+				log.Printf("restore file %s from %s error: %v", target, backup, err)
+			}
+		}
+	}
+	for _, f := range s.files {
+		backup, err := s.backup(f.target)
+		if err != nil {
+			rollback()
+			return err
+		}
+		committed = append(committed, [2]string{f.target, backup})
+		if f.tmpfile == "" {
+			continue
+		}
+		if err := os.Rename(f.tmpfile, f.target); err != nil {
+			rollback()
+			return fmt.Errorf("write file %s error: %w", f.target, err)
+		}
+	}
+	s.files = nil
+	return s.removeDirs()
+}
+
+// backup 将目标文件 target 移到暂存目录中, 返回备份的文件名, target 不存在时返回空
+func (s *stage) backup(target string) (string, error) {
+	if _, err := os.Lstat(target); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("stat file %s error: %w", target, err)
+	}
+	dir, name := filepath.Split(target)
+	if dir == "" {
+		dir = "."
+	}
+	backup, err := s.tempName(filepath.Clean(dir), name+".bak")
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(target, backup); err != nil {
+		return "", fmt.Errorf("backup file %s error: %w", target, err)
+	}
+	return backup, nil
+}
+
+// discard 丢弃所有暂存的文件
+func (s *stage) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = nil
+	s.removeDirs()
+}
+
+func (s *stage) removeDirs() error {
+	var failed []string
+	for dir, stageDir := range s.dirs {
+		if err := os.RemoveAll(stageDir); err != nil {
+			failed = append(failed, stageDir)
+		}
+		delete(s.dirs, dir)
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("remove stage directory %s failed", strings.Join(failed, ", "))
+	}
+	return nil
+}
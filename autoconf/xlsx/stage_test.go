@@ -0,0 +1,89 @@
+package xlsx
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readTree 返回目录 dir 中所有文件的内容, 以相对路径为键
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestStageCommit(t *testing.T) {
+	old := map[string]string{
+		"a.json":        "old a",
+		"b.json":        "old b",
+		"sub/c.json":    "old c",
+		"manifest.json": "old manifest",
+	}
+	committed := map[string]string{
+		"a.json":        "new a",
+		"sub/c.json":    "new c",
+		"sub/d.json":    "new d",
+		"manifest.json": "new manifest",
+	}
+	for _, tt := range []struct {
+		name string
+		// 提交前删除清单的临时文件, 使提交在最后一步失败
+		fail    bool
+		discard bool
+		want    map[string]string
+	}{
+		{"commit", false, false, committed},
+		{"commit failed", true, false, old},
+		{"discard", false, true, old},
+	} {
+		dir := t.TempDir()
+		for name, content := range old {
+			os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		st := newStage()
+		for _, name := range []string{"a.json", "sub/c.json", "sub/d.json"} {
+			if err := st.writeFile(filepath.Join(dir, name), []byte(committed[name]), stageData); err != nil {
+				t.Fatal(err)
+			}
+		}
+		st.remove(filepath.Join(dir, "b.json"), stagePrune)
+		st.remove(filepath.Join(dir, "missing.json"), stagePrune)
+		manifest := filepath.Join(dir, "manifest.json")
+		if err := st.writeFile(manifest, []byte(committed["manifest.json"]), stageManifest); err != nil {
+			t.Fatal(err)
+		}
+		if tt.fail {
+			os.Remove(st.source(manifest))
+		}
+		if tt.discard {
+			st.discard()
+		} else if err := st.commit(); (err != nil) != tt.fail {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.fail)
+		}
+		// 提交失败时由调用者丢弃暂存的文件
+		st.discard()
+		if got := readTree(t, dir); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot:  %v\nwant: %v", tt.name, got, tt.want)
+		}
+	}
+}
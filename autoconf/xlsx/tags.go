@@ -0,0 +1,291 @@
+package xlsx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/midlang/mid/src/mid/build"
+)
+
+// 可选字段的输出方式
+type optionalMode int
+
+const (
+	notOptional  optionalMode = iota // 输出零值
+	optionalOmit                     // 不输出
+	optionalNull                     // 输出 null
+)
+
+func optionalOf(field *build.Field) optionalMode {
+	if field == nil {
+		return notOptional
+	}
+	switch tag := field.GetTag("optional"); tag {
+	case "null":
+		return optionalNull
+	case "":
+		return notOptional
+	default:
+		if b, err := strconv.ParseBool(tag); err == nil && b {
+			return optionalOmit
+		}
+		return notOptional
+	}
+}
+
+// 数组中未设置元素的处理方式
+type sparseMode int
+
+const (
+	sparseKeep    sparseMode = iota // 保留位置, 输出占位值
+	sparseCompact                   // 移除所有未设置的元素
+	sparseTrim                      // 只移除末尾未设置的元素
+)
+
+// sparseOf 返回数组字段的 sparse 标签, 未指定时 `optional:"true"` 的数组为 compact, 其他为 keep
+func sparseOf(field *build.Field, optional optionalMode) sparseMode {
+	var tag string
+	if field != nil {
+		tag = field.GetTag("sparse")
+	}
+	switch tag {
+	case "keep":
+		return sparseKeep
+	case "compact":
+		return sparseCompact
+	case "trim":
+		return sparseTrim
+	}
+	if optional == optionalOmit {
+		return sparseCompact
+	}
+	return sparseKeep
+}
+
+// precisionOf 返回浮点数字段保留的小数位数, 没有 precision 标签时返回 -1
+func precisionOf(field *build.Field) int {
+	if field == nil {
+		return -1
+	}
+	tag := field.GetTag("precision")
+	if tag == "" {
+		return -1
+	}
+	p, err := strconv.Atoi(tag)
+	if err != nil || p < 0 {
+		return -1
+	}
+	return p
+}
+
+// decimalOf 返回定点小数字段的小数位数, ok 为 false 表示不是定点小数字段
+func decimalOf(field *build.Field) (scale int, ok bool) {
+	if field == nil || !field.HasTag("decimal") {
+		return 0, false
+	}
+	scale, err := strconv.Atoi(field.GetTag("decimal"))
+	if err != nil || scale < 0 {
+		return 0, false
+	}
+	return scale, true
+}
+
+// pbOf 返回字段在 protobuf 消息中的编号, 由 pb 标签指定, ok 为 false 表示没有有效的编号
+func pbOf(field *build.Field) (number int, ok bool) {
+	if field == nil || !field.HasTag("pb") {
+		return 0, false
+	}
+	number, err := strconv.Atoi(strings.TrimSpace(field.GetTag("pb")))
+	if err != nil || number < 1 || number > maxProtoFieldNumber || (number >= 19000 && number <= 19999) {
+		return 0, false
+	}
+	return number, true
+}
+
+// 字段的索引, 由 index 标签指定, 用于生成代码中的查询函数和数据库的索引
+const (
+	indexNone   = ""
+	indexNormal = "true"
+	indexUnique = "unique"
+)
+
+// indexOf 返回字段的索引: indexUnique 表示值不能重复, indexNormal 表示可以重复, 没有索引时为 indexNone
+func indexOf(field *build.Field) string {
+	if field == nil {
+		return indexNone
+	}
+	switch tag := strings.TrimSpace(field.GetTag("index")); tag {
+	case indexUnique:
+		return indexUnique
+	default:
+		if b, err := strconv.ParseBool(tag); err == nil && b {
+			return indexNormal
+		}
+		return indexNone
+	}
+}
+
+// exportsOfField 返回字段的导出目标, 由字段的 export 标签指定, 为 nil 表示导出到协议的所有导出目标
+func exportsOfField(field *build.Field) []string {
+	if field == nil || !field.HasTag("export") {
+		return nil
+	}
+	var exports []string
+	for _, export := range strings.Split(field.GetTag("export"), ",") {
+		if export = strings.TrimSpace(export); export != "" {
+			exports = append(exports, export)
+		}
+	}
+	return exports
+}
+
+// fieldExported 判断字段是否导出到 export, export 为空时总是返回 true
+func fieldExported(field *build.Field, export string) bool {
+	if export == "" || field == nil || !field.HasTag("export") {
+		return true
+	}
+	for _, e := range exportsOfField(field) {
+		if e == export {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBean 检查 bean 及其引用的结构体的继承关系和字段标签的有效性
+func checkBean(pkg *build.Package, bean *build.Bean) error {
+	return recCheckBean(pkg, bean, make(map[*build.Bean]bool))
+}
+
+func recCheckBean(pkg *build.Package, bean *build.Bean, visited map[*build.Bean]bool) error {
+	if visited[bean] {
+		return nil
+	}
+	visited[bean] = true
+	fields, err := fieldsOfBean(pkg, bean)
+	if err != nil {
+		return fmt.Errorf("bean '%s.%s': %w", pkg.Name, bean.Name, err)
+	}
+	if singleton, _ := isSingleton(bean); bean.Kind == "protocol" && !singleton {
+		// key 字段用于在各导出目标中查找行
+		if key := keyFieldOfBean(pkg, bean); key != nil && key.HasTag("export") {
+			return fmt.Errorf("field '%s.%s::%s': export tag is not allowed for key field", pkg.Name, bean.Name, fieldName(key))
+		}
+	}
+	for _, field := range fields {
+		if err := validateFieldTags(pkg, field); err != nil {
+			return fmt.Errorf("field '%s.%s::%s': %w", pkg.Name, bean.Name, fieldName(field), err)
+		}
+		t := field.Type
+		if t.IsArray() {
+			t = t.(*build.ArrayType).T
+		}
+		if t.IsStruct() {
+			if b2 := pkg.FindBean(t.(*build.StructType).Name); b2 != nil && b2.Kind != "enum" {
+				if err := recCheckBean(pkg, b2, visited); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateFieldTags(pkg *build.Package, field *build.Field) error {
+	t := field.Type
+	isArray := t.IsArray()
+	if isArray {
+		t = t.(*build.ArrayType).T
+	}
+	if tag := field.GetTag("optional"); tag != "" && tag != "null" {
+		if _, err := strconv.ParseBool(tag); err != nil {
+			return fmt.Errorf("invalid optional tag %q", tag)
+		}
+	}
+	if field.HasTag("sparse") {
+		if !isArray {
+			return fmt.Errorf("sparse tag is only allowed for array")
+		}
+		switch tag := field.GetTag("sparse"); tag {
+		case "keep", "compact", "trim":
+		default:
+			return fmt.Errorf("invalid sparse tag %q", tag)
+		}
+	}
+	if field.HasTag("precision") {
+		if !t.IsFloat() {
+			return fmt.Errorf("precision tag is only allowed for float")
+		}
+		if p, err := strconv.Atoi(field.GetTag("precision")); err != nil || p < 0 || p > 17 {
+			return fmt.Errorf("invalid precision tag %q", field.GetTag("precision"))
+		}
+	}
+	if field.HasTag("decimal") {
+		if !t.IsInt() && !t.IsFloat() && !t.IsString() {
+			return fmt.Errorf("decimal tag is only allowed for number or string")
+		}
+		if scale, err := strconv.Atoi(field.GetTag("decimal")); err != nil || scale < 0 || scale > 30 {
+			return fmt.Errorf("invalid decimal tag %q", field.GetTag("decimal"))
+		}
+	}
+	if field.HasTag("index") {
+		switch tag := strings.TrimSpace(field.GetTag("index")); tag {
+		case indexUnique:
+		default:
+			if _, err := strconv.ParseBool(tag); err != nil {
+				return fmt.Errorf("invalid index tag %q", tag)
+			}
+		}
+		isEnum := false
+		if t.IsStruct() {
+			b := pkg.FindBean(t.(*build.StructType).Name)
+			isEnum = b != nil && b.Kind == "enum"
+		}
+		if isArray || (!t.IsInt() && !t.IsString() && !t.IsBool() && !isEnum) {
+			return fmt.Errorf("index tag is only allowed for integer, string, bool or enum")
+		}
+	}
+	if _, ok := pbOf(field); field.HasTag("pb") && !ok {
+		return fmt.Errorf("invalid pb tag %q", field.GetTag("pb"))
+	}
+	if field.HasTag("export") && len(exportsOfField(field)) == 0 {
+		return fmt.Errorf("invalid export tag %q", field.GetTag("export"))
+	}
+	if field.HasTag("default") {
+		value := strings.TrimSpace(field.GetTag("default"))
+		if _, ok := decimalOf(field); ok {
+			if _, ok := parseDecimal(value); !ok {
+				return fmt.Errorf("invalid default value %q", value)
+			}
+		} else if t.IsInt() {
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+					return fmt.Errorf("invalid default value %q", value)
+				}
+			}
+		} else if t.IsFloat() {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("invalid default value %q", value)
+			}
+		} else if t.IsStruct() {
+			b := pkg.FindBean(t.(*build.StructType).Name)
+			if b == nil || b.Kind != "enum" {
+				return fmt.Errorf("default tag is only allowed for basic type or enum")
+			}
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil && !hasEnumDesc(b, value) {
+				return fmt.Errorf("invalid default value %q of enum %s", value, b.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func hasEnumDesc(bean *build.Bean, desc string) bool {
+	for _, f := range bean.Fields {
+		if descOfEnum(f) == desc {
+			return true
+		}
+	}
+	return false
+}
@@ -66,10 +66,10 @@ func trimFilenameSuffix(filename string) string {
 // NOTE: index 从 0 开始,只支持到 ZZ 这一列,即 index >= 0 && index < (26+26*26)
 func columnName(index int) string {
 	if index < 26 {
-		return string('A' + index)
+		return string(rune('A' + index))
 	}
 	index -= 26
-	return string('A'+index/26) + string('A'+index%26)
+	return string(rune('A'+index/26)) + string(rune('A'+index%26))
 }
 
 // NOTE: row, col 均从 0 开始
@@ -116,20 +116,53 @@ func buildBasicType(t build.Type) string {
 	return t2.Name
 }
 
-func allFieldsOfBean(pkg *build.Package, bean *build.Bean) []*build.Field {
+// fieldsOfBean 返回 bean 的所有字段, 包括继承的字段
+func fieldsOfBean(pkg *build.Package, bean *build.Bean) ([]*build.Field, error) {
+	return recFieldsOfBean(pkg, bean, nil)
+}
+
+func recFieldsOfBean(pkg *build.Package, bean *build.Bean, path []*build.Bean) ([]*build.Field, error) {
+	for _, b := range path {
+		if b == bean {
+			return nil, fmt.Errorf("circular extension of type '%s'", bean.Name)
+		}
+	}
+	path = append(path, bean)
 	var fields []*build.Field
 	for _, ext := range bean.Extends {
-		t := ext.(*build.StructType)
+		t, ok := ext.(*build.StructType)
+		if !ok {
+			return nil, fmt.Errorf("invalid extended type of '%s'", bean.Name)
+		}
 		bean2 := pkg.FindBean(t.Name)
 		if bean2 == nil {
-			log.Fatal().Printf("extended type '%s' not found", t.Name)
+			return nil, fmt.Errorf("extended type '%s' not found", t.Name)
 		}
-		fields = append(fields, allFieldsOfBean(pkg, bean2)...)
+		extFields, err := recFieldsOfBean(pkg, bean2, path)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, extFields...)
 	}
 	fields = append(fields, bean.Fields...)
+	return fields, nil
+}
+
+// allFieldsOfBean 同 fieldsOfBean, 但忽略错误, 调用前 bean 需要通过 checkBean 的检查
+func allFieldsOfBean(pkg *build.Package, bean *build.Bean) []*build.Field {
+	fields, _ := fieldsOfBean(pkg, bean)
 	return fields
 }
 
+func findFieldOfBean(pkg *build.Package, bean *build.Bean, name string) *build.Field {
+	for _, field := range allFieldsOfBean(pkg, bean) {
+		if fieldName(field) == name {
+			return field
+		}
+	}
+	return nil
+}
+
 func keyFieldOfBean(pkg *build.Package, bean *build.Bean) *build.Field {
 	fields := allFieldsOfBean(pkg, bean)
 	var idField *build.Field
@@ -386,6 +419,8 @@ type Node struct {
 	// 对于以上3者之一的 array 则为数组元素类型的 Bean 结构
 	// 其他则 bean 为 nil
 	bean *build.Bean
+	// 节点对应的字段, 数组元素为数组字段, 根节点为 nil
+	field *build.Field
 
 	// 父节点
 	parent *Node
@@ -445,6 +480,7 @@ func (node *Node) addChild(pkg *build.Package, text string) *Node {
 			child.parent = node
 			child.nodeType = strings.TrimSuffix(node.nodeType, "[]")
 			child.bean = node.bean
+			child.field = node.field
 			node.children.set(index, child)
 			log.Debug().Printf("add child '%s', nodeType=%s", text, child.nodeType)
 		}
@@ -459,6 +495,9 @@ func (node *Node) addChild(pkg *build.Package, text string) *Node {
 			child.parent = node
 			child.nodeType = typeOfComment(text)
 			child.bean = pkg.FindBean(strings.TrimSuffix(child.nodeType, "[]"))
+			if node.bean != nil {
+				child.field = findFieldOfBean(pkg, node.bean, nameOfComment(text))
+			}
 			log.Debug().Printf("add child '%s', nodeType=%s", text, child.nodeType)
 		}
 	}
@@ -521,119 +560,12 @@ func (node *Node) sort(pkg *build.Package) {
 	}
 	if node.children != nil {
 		for _, child := range node.children.list() {
-			child.sort(pkg)
-		}
-	}
-}
-
-func (node *Node) Key(pkg *build.Package) string {
-	if node.bean == nil || node.children == nil || node.children.Len() == 0 {
-		return ""
-	}
-	keyField := keyFieldOfBean(pkg, node.bean)
-	n := node.children.findNodeByField(keyField)
-	if n != nil {
-		var value = n.Value(pkg, true)
-		if value != nil {
-			return fmt.Sprintf("%v", value)
-		}
-	}
-	return ""
-}
-
-func (node *Node) Value(pkg *build.Package, required bool) interface{} {
-	log.Debug().Printf("data of node '%s': '%s'", node.text, node.data)
-	if required && node.data == "" && !node.isString() {
-		return nil
-	}
-	if node.isInteger() {
-		i, _ := strconv.ParseInt(node.data, 10, 64)
-		return i
-	}
-	if node.isFloat() {
-		f, _ := strconv.ParseFloat(node.data, 64)
-		return f
-	}
-	if node.isBool() {
-		var str = strings.TrimSpace(node.data)
-		if str == yes {
-			return true
-		} else {
-			if b, err := strconv.ParseBool(str); err == nil {
-				return b
-			} else {
-				if i, err := strconv.Atoi(str); err == nil {
-					return i != 0
-				}
-				return false
-			}
-		}
-	}
-	if node.isString() {
-		return node.data
-	}
-	if node.isArray() {
-		values := make([]interface{}, 0)
-		for i := 0; i < node.children.Len(); i++ {
-			value := node.children.get(i).Value(pkg, required)
-			if value == nil {
-				log.Debug().Printf("%dth value of array is nil", i)
-			} else {
-				values = append(values, value)
-			}
-		}
-		return values
-	}
-	if node.bean != nil {
-		if node.bean.Kind == "protocol" || node.bean.Kind == "struct" {
-			values := make(map[string]interface{})
-			if node.children != nil {
-				for _, v := range node.children.list() {
-					value := v.Value(pkg, required)
-					if value == nil {
-						log.Debug().Printf("field '%s' is nil", v.text)
-					} else {
-						field := node.bean.FindFieldByName(nameOfComment(v.text))
-						jsonKey := nameOfComment(v.text)
-						if field == nil {
-							values[jsonKey] = value
-						} else {
-							if tag := field.GetTag("name"); tag == "-" {
-								jsonKey = ""
-							} else if tag != "" {
-								jsonKey = tag
-							}
-							if jsonKey != "" {
-								values[jsonKey] = value
-							}
-						}
-					}
-				}
-			}
-			return values
-		} else if node.bean.Kind == "enum" {
-			data := strings.TrimSpace(node.data)
-			if data == "" {
-				return 0
-			}
-			if n, err := strconv.ParseInt(data, 10, 64); err == nil {
-				return n
-			}
-			for _, f := range node.bean.Fields {
-				desc := descOfEnum(f)
-				if desc == strings.TrimSpace(data) {
-					i, ok := build.ParseIntFromExpr(f.Default)
-					if ok {
-						return i
-					}
-					return nil
-				}
+			// 数组中缺少列的元素为 nil
+			if child != nil {
+				child.sort(pkg)
 			}
-			return 0
 		}
 	}
-
-	return nil
 }
 
 func buildJSONNodes(headers map[string]xlsxHeader, pkg *build.Package, bean *build.Bean, comments map[string]string, columns []string) []*Node {
@@ -670,13 +602,18 @@ func buildJSONNodes(headers map[string]xlsxHeader, pkg *build.Package, bean *bui
 }
 
 func getComments(file *excelize.File, sheetName string) map[string]string {
-	var comments = make(map[string]string)
 	rows, err := file.Rows(sheetName)
 	if err == nil && rows.Next() {
-		columns := rows.Columns()
-		for i := 0; i < len(columns); i++ {
-			comments[cellName(0, i)] = columns[i]
-		}
+		return commentsOfRow(rows.Columns())
+	}
+	return make(map[string]string)
+}
+
+// commentsOfRow 返回首行各单元格的标注
+func commentsOfRow(columns []string) map[string]string {
+	var comments = make(map[string]string)
+	for i := 0; i < len(columns); i++ {
+		comments[cellName(0, i)] = columns[i]
 	}
 	return comments
 }